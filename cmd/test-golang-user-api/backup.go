@@ -0,0 +1,260 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/google/uuid"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"test_golang_user_api/internal/backup"
+	"test_golang_user_api/internal/config"
+	"test_golang_user_api/internal/storage"
+	"test_golang_user_api/internal/storage/postgres"
+)
+
+const exportUsage = `usage: export [flags] FILE
+  -tenant T      tenant to export users of (default "default")
+
+Writes every user of the tenant, including deleted ones, to FILE as NDJSON.`
+
+const importUsage = `usage: import [flags] FILE
+  -tenant T      tenant to import users into (default "default")
+  -on-conflict P what to do with users whose id or email exists: fail,
+                 skip or overwrite (default fail); ids of other tenants
+                 fail the import unless skipped
+  -batch N       users imported per transaction (default 1000)
+  -resume        continue after the last batch imported from FILE
+
+Progress is saved with each batch in the database, per tenant and FILE,
+until the import completes. Imported users keep their ids and timestamps
+but get no history and publish no events.`
+
+var (
+	errExportUsage = errors.New("invalid export arguments")
+	errImportUsage = errors.New("invalid import arguments")
+)
+
+// progressEvery is how often export reports progress, in users.
+const progressEvery = 10000
+
+var conflictPolicies = map[string]postgres.ConflictPolicy{
+	"fail":      postgres.ConflictFail,
+	"skip":      postgres.ConflictSkip,
+	"overwrite": postgres.ConflictOverwrite,
+}
+
+// openTenantStorage connects to postgres and returns ctx scoped to tenant,
+// which must exist.
+func openTenantStorage(ctx context.Context, log *slog.Logger, cfg config.Data, tenant string) (*postgres.Storage, context.Context, error) {
+	if cfg.Driver != config.DriverPostgres {
+		return nil, nil, fmt.Errorf("driver %q is not supported, only %q", cfg.Driver, config.DriverPostgres)
+	}
+
+	s, err := postgres.New(log, cfg.Postgres)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if _, err := s.GetTenant(ctx, tenant); err != nil {
+		_ = s.Close()
+		return nil, nil, fmt.Errorf("failed to get tenant %q: %w", tenant, err)
+	}
+
+	return s, storage.WithTenant(ctx, tenant), nil
+}
+
+func runExport(ctx context.Context, log *slog.Logger, cfg config.Data, args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	tenant := fs.String("tenant", storage.DefaultTenant, "")
+	if err := fs.Parse(args); err != nil {
+		return fmt.Errorf("%w: %w", errExportUsage, err)
+	}
+	if fs.NArg() != 1 {
+		return fmt.Errorf("%w: expected one file", errExportUsage)
+	}
+	path := fs.Arg(0)
+
+	s, ctx, err := openTenantStorage(ctx, log, cfg, *tenant)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = s.Close() }()
+
+	// The export is written next to FILE and renamed when complete, so
+	// that a failed export does not leave a truncated backup behind.
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".export-*.ndjson")
+	if err != nil {
+		return fmt.Errorf("failed to create export file: %w", err)
+	}
+	defer func() { _ = os.Remove(tmp.Name()) }()
+	defer func() { _ = tmp.Close() }()
+
+	w := backup.NewWriter(tmp)
+	exported := 0
+	err = s.ExportUsers(ctx, func(user storage.UserDto) error {
+		if err := w.Write(user); err != nil {
+			return fmt.Errorf("failed to write user: %w", err)
+		}
+		exported++
+		if exported%progressEvery == 0 {
+			log.Info("exported users", slog.Int("exported", exported))
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := w.Flush(); err != nil {
+		return fmt.Errorf("failed to write export file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write export file: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to write export file: %w", err)
+	}
+
+	_, _ = fmt.Fprintf(out, "exported %d users of tenant %q to %s\n", exported, *tenant, path)
+	return nil
+}
+
+type importOptions struct {
+	tenant string
+	policy postgres.ConflictPolicy
+	batch  int
+	resume bool
+	path   string
+}
+
+func parseImportArgs(args []string) (importOptions, error) {
+	opts := importOptions{}
+	var policy string
+
+	fs := flag.NewFlagSet("import", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	fs.StringVar(&opts.tenant, "tenant", storage.DefaultTenant, "")
+	fs.StringVar(&policy, "on-conflict", "fail", "")
+	fs.IntVar(&opts.batch, "batch", 1000, "")
+	fs.BoolVar(&opts.resume, "resume", false, "")
+	if err := fs.Parse(args); err != nil {
+		return opts, fmt.Errorf("%w: %w", errImportUsage, err)
+	}
+
+	if fs.NArg() != 1 {
+		return opts, fmt.Errorf("%w: expected one file", errImportUsage)
+	}
+	opts.path = fs.Arg(0)
+
+	var ok bool
+	if opts.policy, ok = conflictPolicies[policy]; !ok {
+		return opts, fmt.Errorf("%w: unknown conflict policy %q", errImportUsage, policy)
+	}
+	if opts.batch <= 0 {
+		return opts, fmt.Errorf("%w: -batch expects a positive number, got %d", errImportUsage, opts.batch)
+	}
+
+	return opts, nil
+}
+
+func runImport(ctx context.Context, log *slog.Logger, cfg config.Data, args []string, out io.Writer) error {
+	opts, err := parseImportArgs(args)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Open(opts.path)
+	if err != nil {
+		return fmt.Errorf("failed to open import file: %w", err)
+	}
+	defer func() { _ = f.Close() }()
+
+	// The checkpoint is kept per tenant and file, so that imports of the
+	// same file into different tenants resume independently.
+	key, err := filepath.Abs(opts.path)
+	if err != nil {
+		return fmt.Errorf("failed to resolve import file: %w", err)
+	}
+
+	s, ctx, err := openTenantStorage(ctx, log, cfg, opts.tenant)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = s.Close() }()
+
+	start := 0
+	if opts.resume {
+		if start, err = s.ImportCheckpoint(ctx, key); err != nil {
+			return err
+		}
+	}
+
+	r := backup.NewReader(f)
+	if err := r.Skip(start); err != nil {
+		return fmt.Errorf("failed to resume after line %d: %w", start, err)
+	}
+	if start > 0 {
+		log.Info("resuming import", slog.Int("line", start))
+	}
+
+	var inserted, skipped int64
+	var foreign []uuid.UUID
+	for {
+		batch, err := readBatch(r, opts.batch)
+		if err != nil {
+			return err
+		}
+		if len(batch) == 0 {
+			break
+		}
+
+		result, err := s.ImportUsers(ctx, batch, opts.policy, &postgres.Checkpoint{Key: key, Line: r.Line()})
+		if err != nil {
+			return fmt.Errorf("failed to import users before line %d: %w", r.Line(), err)
+		}
+		inserted += result.Inserted
+		skipped += result.Skipped
+		foreign = append(foreign, result.Foreign...)
+		for _, id := range result.Foreign {
+			log.Warn("skipped user with id of another tenant", slog.String("id", id.String()))
+		}
+		log.Info("imported users",
+			slog.Int("line", r.Line()),
+			slog.Int64("inserted", inserted),
+			slog.Int64("skipped", skipped),
+		)
+	}
+
+	if err := s.ClearImportCheckpoint(ctx, key); err != nil {
+		return err
+	}
+
+	_, _ = fmt.Fprintf(out, "imported %d users into tenant %q, skipped %d\n", inserted, opts.tenant, skipped)
+	if len(foreign) > 0 {
+		_, _ = fmt.Fprintf(out, "skipped %d users whose id belongs to another tenant:\n", len(foreign))
+		for _, id := range foreign {
+			_, _ = fmt.Fprintln(out, id)
+		}
+	}
+	return nil
+}
+
+func readBatch(r *backup.Reader, size int) ([]storage.UserDto, error) {
+	batch := make([]storage.UserDto, 0, size)
+	for len(batch) < size {
+		user, err := r.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		batch = append(batch, user)
+	}
+	return batch, nil
+}
@@ -1,44 +1,137 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"expvar"
+	"fmt"
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
+	"io"
 	"log/slog"
 	"net/http"
 	"os"
 	"test_golang_user_api/internal/config"
 	dr "test_golang_user_api/internal/http_server/handlers/uri/delete"
 	"test_golang_user_api/internal/http_server/handlers/uri/get"
+	"test_golang_user_api/internal/http_server/handlers/uri/history"
+	ul "test_golang_user_api/internal/http_server/handlers/uri/list"
 	"test_golang_user_api/internal/http_server/handlers/uri/patch"
+	"test_golang_user_api/internal/http_server/handlers/uri/restore"
 	"test_golang_user_api/internal/http_server/handlers/uri/save"
+	"test_golang_user_api/internal/http_server/handlers/uri/search"
+	"test_golang_user_api/internal/http_server/handlers/uri/tenant/create"
+	"test_golang_user_api/internal/http_server/handlers/uri/tenant/disable"
+	"test_golang_user_api/internal/http_server/handlers/uri/tenant/list"
+	"test_golang_user_api/internal/http_server/middleware/actor"
+	"test_golang_user_api/internal/http_server/middleware/auth"
+	"test_golang_user_api/internal/http_server/middleware/consistency"
+	"test_golang_user_api/internal/http_server/middleware/tenant"
+	"test_golang_user_api/internal/outbox"
+	"test_golang_user_api/internal/purge"
+	"test_golang_user_api/internal/storage"
+	"test_golang_user_api/internal/storage/cache"
+	"test_golang_user_api/internal/storage/memory"
 	"test_golang_user_api/internal/storage/postgres"
+	"test_golang_user_api/internal/storage/sqlite"
 )
 
+// subcommand is a one-off task run instead of the server, as
+// "<binary> <name> args...".
+type subcommand struct {
+	run      func(ctx context.Context, log *slog.Logger, cfg config.Data, args []string, out io.Writer) error
+	errUsage error
+	usage    string
+}
+
+var subcommands = map[string]subcommand{
+	"migrate": {run: runMigrate, errUsage: errMigrateUsage, usage: migrateUsage},
+	"seed":    {run: runSeed, errUsage: errSeedUsage, usage: seedUsage},
+	"export":  {run: runExport, errUsage: errExportUsage, usage: exportUsage},
+	"import":  {run: runImport, errUsage: errImportUsage, usage: importUsage},
+}
+
 func main() {
 	cfg := config.LoadConfig()
 
 	log := slog.New(
 		slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelInfo}),
 	)
+
+	if len(os.Args) > 1 {
+		if cmd, ok := subcommands[os.Args[1]]; ok {
+			if err := cmd.run(context.Background(), log, cfg.Data, os.Args[2:], os.Stdout); err != nil {
+				log.Error(os.Args[1]+" failed", slog.Any("err", err))
+				if errors.Is(err, cmd.errUsage) {
+					_, _ = fmt.Fprintln(os.Stderr, cmd.usage)
+				}
+				os.Exit(1)
+			}
+			return
+		}
+	}
+
 	log.Info("starting server with", slog.String("env", cfg.Env))
-	log.Info("starting connect to db")
+	log.Info("starting connect to db", slog.String("driver", cfg.Data.Driver))
 
-	storage, err := postgres.New(cfg.Data.Postgres)
+	storage, err := newStorage(context.Background(), log, cfg.Data)
 	if err != nil {
-		log.Error("failed to connect to database", err)
+		log.Error("failed to connect to database", slog.Any("err", err))
 		os.Exit(1)
 	}
 	log.Info("finished connect to db")
 
+	if cfg.Cache.Enabled {
+		cached := cache.New(storage, cfg.Cache)
+		expvar.Publish("user_cache", expvar.Func(func() any { return cached.Stats() }))
+		storage = cached
+	}
+
 	router := chi.NewRouter()
 	router.Use(middleware.RequestID)
 	router.Use(middleware.Recoverer)
 	router.Use(middleware.URLFormat)
+	router.Use(actor.New)
+	router.Use(auth.New(log, cfg.Auth))
+	if cfg.Data.Driver == config.DriverPostgres && len(cfg.Data.Postgres.Replicas.DSNs) > 0 {
+		router.Use(consistency.New(cfg.Data.Postgres.Replicas.ReadYourWrites))
+	}
+
+	router.Group(func(router chi.Router) {
+		router.Use(tenant.New(log, storage, cfg.Tenancy))
+
+		router.Get("/users", ul.New(log, storage))
+		router.Get("/users/search", search.New(log, storage))
+		router.Post("/user", save.New(log, storage))
+		router.Delete("/user/{id}", dr.New(log, storage))
+		router.Get("/user/{id}", get.New(log, storage))
+		router.Patch("/user/{id}", patch.New(log, storage))
+		router.Post("/user/{id}/restore", restore.New(log, storage))
+		router.Get("/user/{id}/history", history.New(log, storage))
+	})
 
-	router.Post("/user", save.New(log, storage))
-	router.Delete("/user/{id}", dr.New(log, storage))
-	router.Get("/user/{id}", get.New(log, storage))
-	router.Patch("/user/{id}", patch.New(log, storage))
+	adminRouter := chi.NewRouter()
+	adminRouter.Use(middleware.RequestID)
+	adminRouter.Use(middleware.Recoverer)
+	adminRouter.Post("/tenants", create.New(log, storage))
+	adminRouter.Get("/tenants", list.New(log, storage))
+	adminRouter.Post("/tenants/{id}/disable", disable.New(log, storage))
+	adminRouter.Handle("/debug/vars", expvar.Handler())
+
+	if cfg.HTTPServer.AdminAddress != "" {
+		go serveAdmin(log, cfg.HTTPServer, adminRouter)
+	}
+
+	go purge.Run(context.Background(), log, storage, cfg.Data.Purge)
+
+	if cfg.Outbox.Enabled {
+		publisher, err := newPublisher(log, cfg.Outbox)
+		if err != nil {
+			log.Error("failed to create event publisher", slog.Any("err", err))
+			os.Exit(1)
+		}
+		go outbox.New(log, storage, publisher, cfg.Outbox).Run(context.Background())
+	}
 
 	server := &http.Server{
 		Addr:         cfg.HTTPServer.Address,
@@ -51,7 +144,65 @@ func main() {
 	log.Info("starting http server on ", slog.String("host", cfg.HTTPServer.Address))
 
 	if err := server.ListenAndServe(); err != nil {
-		log.Error("failed to start http server", err)
+		log.Error("failed to start http server", slog.Any("err", err))
+	}
+
+}
+
+// serveAdmin serves the tenant administration, which has no auth of its
+// own and must only be reachable from inside the deployment.
+func serveAdmin(log *slog.Logger, cfg config.HTTPServer, handler http.Handler) {
+	server := &http.Server{
+		Addr:         cfg.AdminAddress,
+		Handler:      handler,
+		ReadTimeout:  cfg.Timeout,
+		WriteTimeout: cfg.Timeout,
+		IdleTimeout:  cfg.IdleTimeout,
 	}
 
+	log.Info("starting admin http server on ", slog.String("host", cfg.AdminAddress))
+
+	if err := server.ListenAndServe(); err != nil {
+		log.Error("failed to start admin http server", slog.Any("err", err))
+	}
+}
+
+func newStorage(ctx context.Context, log *slog.Logger, cfg config.Data) (storage.UserStorage, error) {
+	switch cfg.Driver {
+	case config.DriverMemory:
+		return memory.New(), nil
+	case config.DriverSqlite:
+		s, err := sqlite.New(cfg.Sqlite)
+		if err != nil {
+			return nil, err
+		}
+		if cfg.AutoMigrate {
+			if err := s.MigrateUp(); err != nil {
+				_ = s.Close()
+				return nil, fmt.Errorf("migration failed: %w", err)
+			}
+		}
+		return s, nil
+	default:
+		s, err := postgres.New(log, cfg.Postgres)
+		if err != nil {
+			return nil, err
+		}
+		if cfg.AutoMigrate {
+			if err := s.MigrateUp(ctx); err != nil {
+				_ = s.Close()
+				return nil, fmt.Errorf("migration failed: %w", err)
+			}
+		}
+		return s, nil
+	}
+}
+
+func newPublisher(log *slog.Logger, cfg config.Outbox) (outbox.Publisher, error) {
+	switch cfg.Publisher {
+	case config.PublisherFile:
+		return outbox.NewFilePublisher(cfg.FilePath)
+	default:
+		return outbox.NewLogPublisher(log), nil
+	}
 }
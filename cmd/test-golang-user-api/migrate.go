@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/golang-migrate/migrate/v4"
+	"io"
+	"log/slog"
+	"strconv"
+	"test_golang_user_api/internal/config"
+	"test_golang_user_api/internal/storage/postgres"
+	"test_golang_user_api/internal/storage/sqlite"
+)
+
+const migrateUsage = `usage: migrate <command>
+  up            apply all pending migrations
+  down N        roll back N migrations
+  goto V        migrate up or down to version V
+  force V       set version V without running migrations and clear the dirty flag
+  status        print the current version and dirty flag`
+
+var errMigrateUsage = errors.New("invalid migrate arguments")
+
+func runMigrate(ctx context.Context, log *slog.Logger, cfg config.Data, args []string, out io.Writer) error {
+	if len(args) == 0 {
+		return fmt.Errorf("%w: command is required", errMigrateUsage)
+	}
+
+	var n int
+	switch args[0] {
+	case "up", "status":
+	case "down", "goto", "force":
+		var err error
+		if n, err = migrateArg(args); err != nil {
+			return err
+		}
+		if args[0] == "down" && n <= 0 {
+			return fmt.Errorf("%w: down expects a positive number of steps, got %d", errMigrateUsage, n)
+		}
+		if args[0] == "goto" && n < 0 {
+			return fmt.Errorf("%w: goto expects a non-negative version, got %d", errMigrateUsage, n)
+		}
+	default:
+		return fmt.Errorf("%w: unknown command %q", errMigrateUsage, args[0])
+	}
+
+	m, closeMigrator, err := openMigrator(ctx, log, cfg)
+	if err != nil {
+		return err
+	}
+	defer closeMigrator()
+
+	switch args[0] {
+	case "up":
+		err = m.Up()
+	case "down":
+		err = m.Steps(-n)
+	case "goto":
+		err = m.Migrate(uint(n))
+	case "force":
+		err = m.Force(n)
+	case "status":
+		return printMigrationStatus(m, out)
+	}
+
+	if errors.Is(err, migrate.ErrNoChange) {
+		_, _ = fmt.Fprintln(out, "no change")
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("migrate %s: %w", args[0], err)
+	}
+
+	return printMigrationStatus(m, out)
+}
+
+func migrateArg(args []string) (int, error) {
+	if len(args) < 2 {
+		return 0, fmt.Errorf("%w: %s expects a number", errMigrateUsage, args[0])
+	}
+
+	n, err := strconv.Atoi(args[1])
+	if err != nil {
+		return 0, fmt.Errorf("%w: %s expects a number, got %q", errMigrateUsage, args[0], args[1])
+	}
+
+	return n, nil
+}
+
+func printMigrationStatus(m *migrate.Migrate, out io.Writer) error {
+	version, dirty, err := m.Version()
+	if errors.Is(err, migrate.ErrNilVersion) {
+		_, _ = fmt.Fprintln(out, "version: none")
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read migration version: %w", err)
+	}
+
+	_, _ = fmt.Fprintf(out, "version: %d\ndirty: %t\n", version, dirty)
+	return nil
+}
+
+func openMigrator(ctx context.Context, log *slog.Logger, cfg config.Data) (*migrate.Migrate, func(), error) {
+	switch cfg.Driver {
+	case config.DriverPostgres:
+		s, err := postgres.New(log, cfg.Postgres)
+		if err != nil {
+			return nil, nil, err
+		}
+		m, err := s.Migrator(ctx)
+		if err != nil {
+			_ = s.Close()
+			return nil, nil, err
+		}
+		return m, func() {
+			_, _ = m.Close()
+			_ = s.Close()
+		}, nil
+	case config.DriverSqlite:
+		s, err := sqlite.New(cfg.Sqlite)
+		if err != nil {
+			return nil, nil, err
+		}
+		m, err := s.Migrator()
+		if err != nil {
+			_ = s.Close()
+			return nil, nil, err
+		}
+		return m, func() {
+			_, _ = m.Close()
+		}, nil
+	default:
+		return nil, nil, fmt.Errorf("driver %q does not support migrations", cfg.Driver)
+	}
+}
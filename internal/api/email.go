@@ -0,0 +1,29 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"test_golang_user_api/internal/storage"
+)
+
+var ErrInvalidEmail = errors.New("invalid email")
+
+// NormalizeEmail trims email and converts its domain to lowercase ASCII,
+// encoding internationalized domains as punycode. The local part is kept as
+// is; uniqueness is enforced case-insensitively by storage.
+func NormalizeEmail(email string) (string, error) {
+	email = strings.TrimSpace(email)
+
+	at := strings.LastIndex(email, "@")
+	if at <= 0 || at == len(email)-1 {
+		return "", ErrInvalidEmail
+	}
+
+	domain, err := storage.NormalizeDomain(email[at+1:])
+	if err != nil {
+		return "", fmt.Errorf("%w: %w", ErrInvalidEmail, err)
+	}
+
+	return email[:at] + "@" + domain, nil
+}
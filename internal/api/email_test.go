@@ -0,0 +1,36 @@
+package api
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestNormalizeEmail(t *testing.T) {
+	tests := []struct {
+		name  string
+		email string
+		want  string
+	}{
+		{"keeps normalized email", "ivan@example.com", "ivan@example.com"},
+		{"trims spaces", "  ivan@example.com\n", "ivan@example.com"},
+		{"lowercases domain only", "Ivan@Example.COM", "Ivan@example.com"},
+		{"encodes idn domain", "ivan@Пример.рф", "ivan@xn--e1afmkfd.xn--p1ai"},
+		{"keeps punycode domain", "ivan@xn--e1afmkfd.xn--p1ai", "ivan@xn--e1afmkfd.xn--p1ai"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NormalizeEmail(tt.email)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+
+	for _, email := range []string{"", "ivan", "@example.com", "ivan@", "ivan@exa mple.com"} {
+		t.Run("rejects "+email, func(t *testing.T) {
+			_, err := NormalizeEmail(email)
+			assert.ErrorIs(t, err, ErrInvalidEmail)
+		})
+	}
+}
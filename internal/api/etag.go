@@ -0,0 +1,42 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+var ErrInvalidIfMatch = errors.New("invalid If-Match header")
+
+func ETag(version int) string {
+	return fmt.Sprintf(`"%d"`, version)
+}
+
+// IfMatchVersion returns the row version required by the If-Match header of
+// r, or 0 when the header is absent or "*".
+func IfMatchVersion(r *http.Request) (int, error) {
+	header := strings.TrimSpace(r.Header.Get("If-Match"))
+	if header == "" || header == "*" {
+		return 0, nil
+	}
+
+	if len(header) < 2 || header[0] != '"' || header[len(header)-1] != '"' {
+		return 0, ErrInvalidIfMatch
+	}
+
+	version, err := strconv.Atoi(header[1 : len(header)-1])
+	if err != nil || version <= 0 {
+		return 0, ErrInvalidIfMatch
+	}
+
+	return version, nil
+}
+
+func PreconditionFailed(msg string) Response {
+	return Response{
+		Status: http.StatusPreconditionFailed,
+		Error:  msg,
+	}
+}
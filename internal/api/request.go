@@ -1,8 +1,71 @@
 package api
 
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"test_golang_user_api/internal/storage"
+)
+
+const MergePatchType = "application/merge-patch+json"
+
+var (
+	ErrNotObject = errors.New("merge patch must be an object")
+	ErrNullField = errors.New("field cannot be null")
+)
+
 type Request struct {
 	Firstname string `json:"firstname" validate:"required"`
 	Lastname  string `json:"lastname" validate:"required"`
 	Email     string `json:"email" validate:"required,email"`
 	Age       int    `json:"age" validate:"required"`
 }
+
+// MergePatch is an RFC 7396 merge patch of a user: only the fields present
+// are validated and changed. Users have no optional fields, so null, which
+// would remove a field, is rejected.
+type MergePatch struct {
+	Firstname *string `json:"firstname" validate:"omitnil,min=1"`
+	Lastname  *string `json:"lastname" validate:"omitnil,min=1"`
+	Email     *string `json:"email" validate:"omitnil,email"`
+	Age       *int    `json:"age" validate:"omitnil,gt=0"`
+}
+
+// DecodeMergePatch reads a merge patch, failing with ErrNotObject for
+// documents other than objects and ErrNullField for null fields.
+func DecodeMergePatch(body io.Reader) (MergePatch, error) {
+	raw, err := io.ReadAll(body)
+	if err != nil {
+		return MergePatch{}, err
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &fields); err != nil {
+		return MergePatch{}, err
+	}
+	if fields == nil {
+		return MergePatch{}, ErrNotObject
+	}
+	for name, value := range fields {
+		if bytes.Equal(bytes.TrimSpace(value), []byte("null")) {
+			return MergePatch{}, fmt.Errorf("%w: %s", ErrNullField, name)
+		}
+	}
+
+	var patch MergePatch
+	if err := json.Unmarshal(raw, &patch); err != nil {
+		return MergePatch{}, err
+	}
+	return patch, nil
+}
+
+func (p MergePatch) UserPatch() storage.UserPatch {
+	return storage.UserPatch{Firstname: p.Firstname, Lastname: p.Lastname, Email: p.Email, Age: p.Age}
+}
+
+type TenantRequest struct {
+	ID   string `json:"id" validate:"required"`
+	Name string `json:"name" validate:"required"`
+}
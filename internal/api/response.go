@@ -19,3 +19,17 @@ func ErrorStatus(msg string) Response {
 		Error:  msg,
 	}
 }
+
+func Forbidden(msg string) Response {
+	return Response{
+		Status: http.StatusForbidden,
+		Error:  msg,
+	}
+}
+
+func Unauthorized(msg string) Response {
+	return Response{
+		Status: http.StatusUnauthorized,
+		Error:  msg,
+	}
+}
@@ -0,0 +1,162 @@
+package backup
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"io"
+	"test_golang_user_api/internal/api"
+	"test_golang_user_api/internal/storage"
+	"time"
+)
+
+var ErrInvalidRecord = errors.New("invalid record")
+
+// maxLine bounds a single NDJSON line; users are far smaller.
+const maxLine = 1 << 20
+
+// Record is the NDJSON form of a user. The tenant is left out, so users
+// can be imported into any tenant.
+type Record struct {
+	ID        uuid.UUID  `json:"id"`
+	Firstname string     `json:"firstname"`
+	Lastname  string     `json:"lastname"`
+	Email     string     `json:"email"`
+	Age       int        `json:"age"`
+	Created   time.Time  `json:"created"`
+	Updated   time.Time  `json:"updated"`
+	Version   int        `json:"version"`
+	Deleted   *time.Time `json:"deleted,omitempty"`
+}
+
+func FromUser(user storage.UserDto) Record {
+	return Record{
+		ID:        user.ID,
+		Firstname: user.Firstname,
+		Lastname:  user.Lastname,
+		Email:     user.Email,
+		Age:       user.Age,
+		Created:   user.Created.UTC(),
+		Updated:   user.Updated.UTC(),
+		Version:   user.Version,
+		Deleted:   user.Deleted,
+	}
+}
+
+func (r Record) User() storage.UserDto {
+	if r.Deleted != nil {
+		deleted := r.Deleted.UTC()
+		r.Deleted = &deleted
+	}
+	return storage.UserDto{
+		ID:        r.ID,
+		Firstname: r.Firstname,
+		Lastname:  r.Lastname,
+		Email:     r.Email,
+		Age:       r.Age,
+		Created:   r.Created.UTC(),
+		Updated:   r.Updated.UTC(),
+		Version:   r.Version,
+		Deleted:   r.Deleted,
+	}
+}
+
+// normalize checks the record and normalizes its email like the API does,
+// so that imported users keep the invariants of users created through it.
+func (r *Record) normalize() error {
+	switch {
+	case r.ID == uuid.Nil:
+		return errors.New("id is required")
+	case r.Email == "":
+		return errors.New("email is required")
+	case r.Created.IsZero():
+		return errors.New("created is required")
+	case r.Updated.Before(r.Created):
+		return fmt.Errorf("updated %s is before created %s", r.Updated.Format(time.RFC3339Nano), r.Created.Format(time.RFC3339Nano))
+	case r.Version < 1:
+		return fmt.Errorf("version must be positive, got %d", r.Version)
+	}
+
+	email, err := api.NormalizeEmail(r.Email)
+	if err != nil {
+		return err
+	}
+	r.Email = email
+	return nil
+}
+
+type Writer struct {
+	w   *bufio.Writer
+	enc *json.Encoder
+}
+
+func NewWriter(w io.Writer) *Writer {
+	bw := bufio.NewWriter(w)
+	return &Writer{w: bw, enc: json.NewEncoder(bw)}
+}
+
+func (w *Writer) Write(user storage.UserDto) error {
+	return w.enc.Encode(FromUser(user))
+}
+
+func (w *Writer) Flush() error {
+	return w.w.Flush()
+}
+
+// Reader reads users line by line. Blank lines are skipped but counted,
+// so that Line matches the line numbers of the file.
+type Reader struct {
+	s    *bufio.Scanner
+	line int
+}
+
+func NewReader(r io.Reader) *Reader {
+	s := bufio.NewScanner(r)
+	s.Buffer(make([]byte, 0, 64*1024), maxLine)
+	return &Reader{s: s}
+}
+
+// Line is the number of lines read so far.
+func (r *Reader) Line() int {
+	return r.line
+}
+
+// Skip reads past n lines without decoding them.
+func (r *Reader) Skip(n int) error {
+	for r.line < n {
+		if !r.s.Scan() {
+			if err := r.s.Err(); err != nil {
+				return err
+			}
+			return fmt.Errorf("%w: file has %d lines, cannot skip %d", ErrInvalidRecord, r.line, n)
+		}
+		r.line++
+	}
+	return nil
+}
+
+// Next returns the next user, or io.EOF after the last one.
+func (r *Reader) Next() (storage.UserDto, error) {
+	for r.s.Scan() {
+		r.line++
+		if len(r.s.Bytes()) == 0 {
+			continue
+		}
+
+		var record Record
+		if err := json.Unmarshal(r.s.Bytes(), &record); err != nil {
+			return storage.UserDto{}, fmt.Errorf("%w: line %d: %w", ErrInvalidRecord, r.line, err)
+		}
+		if err := record.normalize(); err != nil {
+			return storage.UserDto{}, fmt.Errorf("%w: line %d: %w", ErrInvalidRecord, r.line, err)
+		}
+		return record.User(), nil
+	}
+
+	if err := r.s.Err(); err != nil {
+		return storage.UserDto{}, err
+	}
+	return storage.UserDto{}, io.EOF
+}
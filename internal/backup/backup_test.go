@@ -0,0 +1,149 @@
+package backup
+
+import (
+	"bytes"
+	"errors"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"strings"
+	"test_golang_user_api/internal/storage"
+	"testing"
+	"time"
+)
+
+func readAll(t *testing.T, r *Reader) []storage.UserDto {
+	var users []storage.UserDto
+	for {
+		user, err := r.Next()
+		if errors.Is(err, io.EOF) {
+			return users
+		}
+		require.NoError(t, err)
+		users = append(users, user)
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	t.Run("keeps users as exported", func(t *testing.T) {
+		//given
+		created := time.Date(2024, 1, 2, 3, 4, 5, 6000, time.UTC)
+		deleted := created.Add(time.Hour)
+		users := []storage.UserDto{
+			{ID: uuid.New(), Firstname: "Ivan", Lastname: "Ivanov", Email: "ivan@gmail.com", Age: 30, Created: created, Updated: created, Version: 1},
+			{ID: uuid.New(), Firstname: "Анна", Lastname: "Петрова", Email: "anna@mail.ru", Age: 41, Created: created, Updated: deleted, Version: 3, Deleted: &deleted},
+		}
+		var buf bytes.Buffer
+		w := NewWriter(&buf)
+		for _, user := range users {
+			require.NoError(t, w.Write(user))
+		}
+		require.NoError(t, w.Flush())
+		//when
+		got := readAll(t, NewReader(&buf))
+		//then
+		assert.Equal(t, users, got)
+	})
+
+	t.Run("leaves tenant out", func(t *testing.T) {
+		//given
+		var buf bytes.Buffer
+		w := NewWriter(&buf)
+		user := storage.NewUser(uuid.New(), "Ivan", "Ivanov", "ivan@gmail.com", 30)
+		user.Tenant = "acme"
+		//when
+		require.NoError(t, w.Write(*user))
+		require.NoError(t, w.Flush())
+		//then
+		assert.NotContains(t, buf.String(), "acme")
+		assert.Equal(t, 1, strings.Count(buf.String(), "\n"))
+	})
+}
+
+func TestReader(t *testing.T) {
+	t.Run("skips blank lines and counts them", func(t *testing.T) {
+		//given
+		line := `{"id":"` + uuid.NewString() + `","email":"ivan@gmail.com","created":"2024-01-02T03:04:05Z","updated":"2024-01-02T03:04:05Z","version":1}`
+		r := NewReader(strings.NewReader("\n" + line + "\n\n" + line + "\n"))
+		//when
+		users := readAll(t, r)
+		//then
+		assert.Len(t, users, 2)
+		assert.Equal(t, 4, r.Line())
+	})
+
+	t.Run("reports line of invalid record", func(t *testing.T) {
+		//given
+		r := NewReader(strings.NewReader(`{"id":"` + uuid.NewString() + `","email":"ivan@gmail.com","created":"2024-01-02T03:04:05Z","updated":"2024-01-02T03:04:05Z","version":1}` + "\n{oops\n"))
+		_, err := r.Next()
+		require.NoError(t, err)
+		//when
+		_, err = r.Next()
+		//then
+		require.ErrorIs(t, err, ErrInvalidRecord)
+		assert.Contains(t, err.Error(), "line 2")
+	})
+
+	t.Run("rejects record without id", func(t *testing.T) {
+		//given
+		r := NewReader(strings.NewReader(`{"email":"ivan@gmail.com","created":"2024-01-02T03:04:05Z","updated":"2024-01-02T03:04:05Z","version":1}`))
+		//when
+		_, err := r.Next()
+		//then
+		require.ErrorIs(t, err, ErrInvalidRecord)
+	})
+
+	t.Run("normalizes email domain", func(t *testing.T) {
+		//given
+		r := NewReader(strings.NewReader(`{"id":"` + uuid.NewString() + `","email":" Ivan@Пример.РФ","created":"2024-01-02T03:04:05Z","updated":"2024-01-02T03:04:05Z","version":1}`))
+		//when
+		user, err := r.Next()
+		//then
+		require.NoError(t, err)
+		assert.Equal(t, "Ivan@xn--e1afmkfd.xn--p1ai", user.Email)
+	})
+
+	for _, tc := range []struct {
+		name   string
+		record string
+	}{
+		{"invalid email", `"email":"ivan@xn--zz.com","created":"2024-01-02T03:04:05Z","updated":"2024-01-02T03:04:05Z"`},
+		{"update before creation", `"email":"ivan@gmail.com","created":"2024-01-02T03:04:05Z","updated":"2024-01-01T03:04:05Z"`},
+		{"missing update time", `"email":"ivan@gmail.com","created":"2024-01-02T03:04:05Z"`},
+	} {
+		t.Run("rejects record with "+tc.name, func(t *testing.T) {
+			//given
+			r := NewReader(strings.NewReader("\n" + `{"id":"` + uuid.NewString() + `",` + tc.record + `,"version":1}`))
+			//when
+			_, err := r.Next()
+			//then
+			require.ErrorIs(t, err, ErrInvalidRecord)
+			assert.Contains(t, err.Error(), "line 2")
+		})
+	}
+
+	t.Run("skips lines to resume", func(t *testing.T) {
+		//given
+		first := uuid.New()
+		second := uuid.New()
+		r := NewReader(strings.NewReader(
+			`{"id":"` + first.String() + `","email":"a@gmail.com","created":"2024-01-02T03:04:05Z","updated":"2024-01-02T03:04:05Z","version":1}` + "\n" +
+				`{"id":"` + second.String() + `","email":"b@gmail.com","created":"2024-01-02T03:04:05Z","updated":"2024-01-02T03:04:05Z","version":1}` + "\n"))
+		//when
+		require.NoError(t, r.Skip(1))
+		users := readAll(t, r)
+		//then
+		require.Len(t, users, 1)
+		assert.Equal(t, second, users[0].ID)
+	})
+
+	t.Run("fails to skip past end", func(t *testing.T) {
+		//given
+		r := NewReader(strings.NewReader("\n"))
+		//when
+		err := r.Skip(2)
+		//then
+		require.ErrorIs(t, err, ErrInvalidRecord)
+	})
+}
@@ -0,0 +1,13 @@
+package database
+
+import "embed"
+
+//go:embed migrations/*.sql
+var PostgresMigrations embed.FS
+
+const PostgresMigrationsDir = "migrations"
+
+//go:embed migrations_sqlite/*.sql
+var SqliteMigrations embed.FS
+
+const SqliteMigrationsDir = "migrations_sqlite"
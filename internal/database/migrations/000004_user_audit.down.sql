@@ -0,0 +1 @@
+DROP TABLE IF EXISTS user_audit;
\ No newline at end of file
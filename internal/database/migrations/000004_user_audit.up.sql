@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS user_audit (
+id BIGSERIAL PRIMARY KEY,
+user_id UUID NOT NULL,
+actor TEXT NOT NULL,
+operation TEXT NOT NULL,
+before JSONB,
+after JSONB,
+created TIMESTAMP NOT NULL
+);
+CREATE INDEX IF NOT EXISTS user_audit_user_id_idx ON user_audit (user_id, created DESC, id DESC);
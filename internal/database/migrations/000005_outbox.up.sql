@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS outbox (
+id BIGSERIAL PRIMARY KEY,
+event_type TEXT NOT NULL,
+user_id UUID NOT NULL,
+payload JSONB NOT NULL,
+status TEXT NOT NULL DEFAULT 'pending',
+attempts INTEGER NOT NULL DEFAULT 0,
+last_error TEXT,
+created TIMESTAMP NOT NULL,
+next_attempt_at TIMESTAMP NOT NULL,
+sent_at TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS outbox_pending_idx ON outbox (next_attempt_at, id) WHERE status = 'pending';
@@ -0,0 +1,12 @@
+ALTER TABLE outbox
+    ALTER COLUMN created TYPE TIMESTAMP USING created AT TIME ZONE 'UTC',
+    ALTER COLUMN next_attempt_at TYPE TIMESTAMP USING next_attempt_at AT TIME ZONE 'UTC',
+    ALTER COLUMN sent_at TYPE TIMESTAMP USING sent_at AT TIME ZONE 'UTC';
+
+ALTER TABLE user_audit
+    ALTER COLUMN created TYPE TIMESTAMP USING created AT TIME ZONE 'UTC';
+
+ALTER TABLE users DROP COLUMN IF EXISTS updated_at;
+ALTER TABLE users
+    ALTER COLUMN created TYPE TIMESTAMP USING created AT TIME ZONE 'UTC',
+    ALTER COLUMN deleted_at TYPE TIMESTAMP USING deleted_at AT TIME ZONE 'UTC';
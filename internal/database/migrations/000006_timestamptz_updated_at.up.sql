@@ -0,0 +1,16 @@
+-- Timestamps were written as wall-clock times without a zone; they are
+-- taken to be UTC, which is what the service writes from now on.
+ALTER TABLE users
+    ALTER COLUMN created TYPE TIMESTAMPTZ USING created AT TIME ZONE 'UTC',
+    ALTER COLUMN deleted_at TYPE TIMESTAMPTZ USING deleted_at AT TIME ZONE 'UTC';
+ALTER TABLE users ADD COLUMN IF NOT EXISTS updated_at TIMESTAMPTZ;
+UPDATE users SET updated_at = created WHERE updated_at IS NULL;
+ALTER TABLE users ALTER COLUMN updated_at SET NOT NULL;
+
+ALTER TABLE user_audit
+    ALTER COLUMN created TYPE TIMESTAMPTZ USING created AT TIME ZONE 'UTC';
+
+ALTER TABLE outbox
+    ALTER COLUMN created TYPE TIMESTAMPTZ USING created AT TIME ZONE 'UTC',
+    ALTER COLUMN next_attempt_at TYPE TIMESTAMPTZ USING next_attempt_at AT TIME ZONE 'UTC',
+    ALTER COLUMN sent_at TYPE TIMESTAMPTZ USING sent_at AT TIME ZONE 'UTC';
@@ -0,0 +1,24 @@
+-- Refuse to enforce case-insensitive uniqueness while existing users share
+-- an email in different case; the error lists them so they can be merged,
+-- after which the migration can be forced back one version and rerun.
+DO $$
+DECLARE
+    report TEXT;
+BEGIN
+    SELECT string_agg(format('%s: %s', email, ids), E'\n' ORDER BY email)
+    INTO report
+    FROM (
+        SELECT lower(email) AS email, string_agg(id::TEXT, ', ' ORDER BY created, id) AS ids
+        FROM users
+        GROUP BY lower(email)
+        HAVING count(*) > 1
+    ) duplicates;
+
+    IF report IS NOT NULL THEN
+        RAISE EXCEPTION E'users with case-insensitively duplicate emails:\n%', report;
+    END IF;
+END
+$$;
+
+CREATE UNIQUE INDEX IF NOT EXISTS users_email_lower_key ON users (lower(email));
+ALTER TABLE users DROP CONSTRAINT IF EXISTS users_email_key;
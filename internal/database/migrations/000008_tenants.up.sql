@@ -0,0 +1,41 @@
+CREATE TABLE IF NOT EXISTS tenants (
+id TEXT PRIMARY KEY,
+name TEXT NOT NULL,
+created TIMESTAMPTZ NOT NULL DEFAULT now(),
+disabled_at TIMESTAMPTZ NULL
+);
+INSERT INTO tenants (id, name) VALUES ('default', 'Default') ON CONFLICT (id) DO NOTHING;
+
+ALTER TABLE users ADD COLUMN IF NOT EXISTS tenant_id TEXT NOT NULL DEFAULT 'default' REFERENCES tenants (id);
+ALTER TABLE users ALTER COLUMN tenant_id DROP DEFAULT;
+DROP INDEX IF EXISTS users_email_lower_key;
+CREATE UNIQUE INDEX IF NOT EXISTS users_tenant_email_lower_key ON users (tenant_id, lower(email));
+
+ALTER TABLE user_audit ADD COLUMN IF NOT EXISTS tenant_id TEXT NOT NULL DEFAULT 'default';
+ALTER TABLE user_audit ALTER COLUMN tenant_id DROP DEFAULT;
+
+ALTER TABLE outbox ADD COLUMN IF NOT EXISTS tenant_id TEXT NOT NULL DEFAULT 'default';
+ALTER TABLE outbox ALTER COLUMN tenant_id DROP DEFAULT;
+
+-- Rows are only visible to the tenant set with set_config('app.tenant_id')
+-- in the current transaction, or to every tenant while app.all_tenants is
+-- 'on'. FORCE applies the policies to the table owner as well; roles with
+-- BYPASSRLS, such as superusers, are not restricted. Data migrations from
+-- here on must set app.all_tenants to see any rows.
+ALTER TABLE users ENABLE ROW LEVEL SECURITY;
+ALTER TABLE users FORCE ROW LEVEL SECURITY;
+CREATE POLICY tenant_isolation ON users
+    USING (tenant_id = current_setting('app.tenant_id', true) OR current_setting('app.all_tenants', true) = 'on')
+    WITH CHECK (tenant_id = current_setting('app.tenant_id', true) OR current_setting('app.all_tenants', true) = 'on');
+
+ALTER TABLE user_audit ENABLE ROW LEVEL SECURITY;
+ALTER TABLE user_audit FORCE ROW LEVEL SECURITY;
+CREATE POLICY tenant_isolation ON user_audit
+    USING (tenant_id = current_setting('app.tenant_id', true) OR current_setting('app.all_tenants', true) = 'on')
+    WITH CHECK (tenant_id = current_setting('app.tenant_id', true) OR current_setting('app.all_tenants', true) = 'on');
+
+ALTER TABLE outbox ENABLE ROW LEVEL SECURITY;
+ALTER TABLE outbox FORCE ROW LEVEL SECURITY;
+CREATE POLICY tenant_isolation ON outbox
+    USING (tenant_id = current_setting('app.tenant_id', true) OR current_setting('app.all_tenants', true) = 'on')
+    WITH CHECK (tenant_id = current_setting('app.tenant_id', true) OR current_setting('app.all_tenants', true) = 'on');
@@ -0,0 +1 @@
+DROP INDEX IF EXISTS users_tenant_created_id_idx;
@@ -0,0 +1 @@
+CREATE INDEX IF NOT EXISTS users_tenant_created_id_idx ON users (tenant_id, created, id) WHERE deleted_at IS NULL;
@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS users_search_trgm_idx;
+DROP INDEX IF EXISTS users_search_idx;
+ALTER TABLE users DROP COLUMN IF EXISTS search;
+DROP EXTENSION IF EXISTS pg_trgm;
@@ -0,0 +1,9 @@
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+
+-- The email is indexed both whole and by its local part, which the simple
+-- parser would otherwise keep glued to the domain.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS search TSVECTOR
+    GENERATED ALWAYS AS (to_tsvector('simple', firstname || ' ' || lastname || ' ' || email || ' ' || split_part(email, '@', 1))) STORED;
+
+CREATE INDEX IF NOT EXISTS users_search_idx ON users USING GIN (search);
+CREATE INDEX IF NOT EXISTS users_search_trgm_idx ON users USING GIN (lower(firstname || ' ' || lastname || ' ' || email) gin_trgm_ops);
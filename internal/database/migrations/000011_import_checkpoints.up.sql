@@ -0,0 +1,15 @@
+-- How far an import of a file into a tenant has got. The line is saved in
+-- the transaction of each batch, so it always matches the users imported.
+CREATE TABLE IF NOT EXISTS import_checkpoints (
+tenant_id TEXT NOT NULL REFERENCES tenants (id),
+key TEXT NOT NULL,
+line INTEGER NOT NULL CHECK (line >= 0),
+updated_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+PRIMARY KEY (tenant_id, key)
+);
+
+ALTER TABLE import_checkpoints ENABLE ROW LEVEL SECURITY;
+ALTER TABLE import_checkpoints FORCE ROW LEVEL SECURITY;
+CREATE POLICY tenant_isolation ON import_checkpoints
+    USING (tenant_id = current_setting('app.tenant_id', true) OR current_setting('app.all_tenants', true) = 'on')
+    WITH CHECK (tenant_id = current_setting('app.tenant_id', true) OR current_setting('app.all_tenants', true) = 'on');
@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS users (
+id TEXT PRIMARY KEY,
+firstname TEXT NOT NULL,
+lastname TEXT NOT NULL,
+email TEXT NOT NULL UNIQUE,
+age INTEGER NOT NULL CHECK (age >= 0),
+created TIMESTAMP NOT NULL
+);
\ No newline at end of file
@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS user_audit (
+id INTEGER PRIMARY KEY AUTOINCREMENT,
+user_id TEXT NOT NULL,
+actor TEXT NOT NULL,
+operation TEXT NOT NULL,
+before TEXT,
+after TEXT,
+created TIMESTAMP NOT NULL
+);
+CREATE INDEX IF NOT EXISTS user_audit_user_id_idx ON user_audit (user_id, created DESC, id DESC);
@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS outbox (
+id INTEGER PRIMARY KEY AUTOINCREMENT,
+event_type TEXT NOT NULL,
+user_id TEXT NOT NULL,
+payload TEXT NOT NULL,
+status TEXT NOT NULL DEFAULT 'pending',
+attempts INTEGER NOT NULL DEFAULT 0,
+last_error TEXT,
+created TIMESTAMP NOT NULL,
+next_attempt_at TIMESTAMP NOT NULL,
+sent_at TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS outbox_pending_idx ON outbox (next_attempt_at, id) WHERE status = 'pending';
@@ -0,0 +1,2 @@
+ALTER TABLE users ADD COLUMN updated_at TIMESTAMP;
+UPDATE users SET updated_at = created WHERE updated_at IS NULL;
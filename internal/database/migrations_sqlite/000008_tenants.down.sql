@@ -0,0 +1,22 @@
+ALTER TABLE outbox DROP COLUMN tenant_id;
+ALTER TABLE user_audit DROP COLUMN tenant_id;
+
+CREATE TABLE users_old (
+id TEXT PRIMARY KEY,
+firstname TEXT NOT NULL,
+lastname TEXT NOT NULL,
+email TEXT NOT NULL UNIQUE,
+age INTEGER NOT NULL CHECK (age >= 0),
+created TIMESTAMP NOT NULL,
+version INTEGER NOT NULL DEFAULT 1,
+deleted_at TIMESTAMP NULL,
+updated_at TIMESTAMP
+);
+INSERT INTO users_old (id, firstname, lastname, email, age, created, version, deleted_at, updated_at)
+SELECT id, firstname, lastname, email, age, created, version, deleted_at, updated_at FROM users;
+DROP TABLE users;
+ALTER TABLE users_old RENAME TO users;
+CREATE INDEX IF NOT EXISTS users_deleted_at_idx ON users (deleted_at) WHERE deleted_at IS NOT NULL;
+CREATE UNIQUE INDEX IF NOT EXISTS users_email_lower_key ON users (lower(email));
+
+DROP TABLE IF EXISTS tenants;
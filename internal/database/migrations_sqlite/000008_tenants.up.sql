@@ -0,0 +1,31 @@
+CREATE TABLE IF NOT EXISTS tenants (
+id TEXT PRIMARY KEY,
+name TEXT NOT NULL,
+created TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+disabled_at TIMESTAMP NULL
+);
+INSERT OR IGNORE INTO tenants (id, name) VALUES ('default', 'Default');
+
+-- The inline UNIQUE on email makes emails unique across tenants and cannot
+-- be dropped in place, so the table is rebuilt.
+CREATE TABLE users_new (
+id TEXT PRIMARY KEY,
+tenant_id TEXT NOT NULL REFERENCES tenants (id),
+firstname TEXT NOT NULL,
+lastname TEXT NOT NULL,
+email TEXT NOT NULL,
+age INTEGER NOT NULL CHECK (age >= 0),
+created TIMESTAMP NOT NULL,
+version INTEGER NOT NULL DEFAULT 1,
+deleted_at TIMESTAMP NULL,
+updated_at TIMESTAMP
+);
+INSERT INTO users_new (id, tenant_id, firstname, lastname, email, age, created, version, deleted_at, updated_at)
+SELECT id, 'default', firstname, lastname, email, age, created, version, deleted_at, updated_at FROM users;
+DROP TABLE users;
+ALTER TABLE users_new RENAME TO users;
+CREATE INDEX IF NOT EXISTS users_deleted_at_idx ON users (deleted_at) WHERE deleted_at IS NOT NULL;
+CREATE UNIQUE INDEX IF NOT EXISTS users_tenant_email_lower_key ON users (tenant_id, lower(email));
+
+ALTER TABLE user_audit ADD COLUMN tenant_id TEXT NOT NULL DEFAULT 'default';
+ALTER TABLE outbox ADD COLUMN tenant_id TEXT NOT NULL DEFAULT 'default';
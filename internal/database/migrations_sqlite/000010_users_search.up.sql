@@ -0,0 +1,33 @@
+-- SQLite has no pg_trgm, so the trigrams of the search document are kept
+-- in an FTS5 index to find candidates, which the store then ranks in Go.
+-- The words of the document are separated and surrounded by spaces to give
+-- the index the trigrams starting and ending words, as pg_trgm pads them.
+-- Index rows are tied to users through users_search_rows, as the implicit
+-- rowid of users may change on VACUUM.
+CREATE TABLE IF NOT EXISTS users_search_rows (
+rowid INTEGER PRIMARY KEY,
+user_id TEXT NOT NULL UNIQUE
+);
+CREATE VIRTUAL TABLE IF NOT EXISTS users_search USING fts5(document, tokenize = 'trigram');
+
+INSERT INTO users_search_rows (user_id) SELECT id FROM users;
+INSERT INTO users_search (rowid, document)
+SELECT r.rowid, ' ' || u.firstname || ' ' || u.lastname || ' ' || replace(replace(replace(replace(u.email, '@', ' '), '.', ' '), '-', ' '), '_', ' ') || ' '
+  FROM users_search_rows r JOIN users u ON u.id = r.user_id;
+
+CREATE TRIGGER IF NOT EXISTS users_search_insert AFTER INSERT ON users BEGIN
+    INSERT INTO users_search_rows (user_id) VALUES (new.id);
+    INSERT INTO users_search (rowid, document)
+    VALUES (last_insert_rowid(), ' ' || new.firstname || ' ' || new.lastname || ' ' || replace(replace(replace(replace(new.email, '@', ' '), '.', ' '), '-', ' '), '_', ' ') || ' ');
+END;
+
+CREATE TRIGGER IF NOT EXISTS users_search_update AFTER UPDATE OF firstname, lastname, email ON users BEGIN
+    UPDATE users_search
+       SET document = ' ' || new.firstname || ' ' || new.lastname || ' ' || replace(replace(replace(replace(new.email, '@', ' '), '.', ' '), '-', ' '), '_', ' ') || ' '
+     WHERE rowid = (SELECT rowid FROM users_search_rows WHERE user_id = new.id);
+END;
+
+CREATE TRIGGER IF NOT EXISTS users_search_delete AFTER DELETE ON users BEGIN
+    DELETE FROM users_search WHERE rowid = (SELECT rowid FROM users_search_rows WHERE user_id = old.id);
+    DELETE FROM users_search_rows WHERE user_id = old.id;
+END;
@@ -1,6 +1,8 @@
 package delete
 
 import (
+	"context"
+	"errors"
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/go-chi/render"
@@ -8,10 +10,11 @@ import (
 	"log/slog"
 	"net/http"
 	"test_golang_user_api/internal/api"
+	"test_golang_user_api/internal/storage"
 )
 
 type UserCRUD interface {
-	DeleteUser(id uuid.UUID) error
+	DeleteUser(ctx context.Context, id uuid.UUID, version int) error
 }
 
 func New(log *slog.Logger, crud UserCRUD) http.HandlerFunc {
@@ -29,7 +32,20 @@ func New(log *slog.Logger, crud UserCRUD) http.HandlerFunc {
 			return
 		}
 
-		err = crud.DeleteUser(id)
+		version, err := api.IfMatchVersion(request)
+		if err != nil {
+			log.Error("Invalid If-Match header", slog.Any("err", err))
+			render.JSON(writer, request, api.ErrorStatus("Invalid If-Match header"))
+			return
+		}
+
+		err = crud.DeleteUser(request.Context(), id, version)
+		if errors.Is(err, storage.ErrVersionConflict) {
+			log.Error("User version mismatch", slog.Any("err", err))
+			render.Status(request, http.StatusPreconditionFailed)
+			render.JSON(writer, request, api.PreconditionFailed("User was modified"))
+			return
+		}
 		if err != nil {
 			log.Error("Error deleting user", slog.Any("err", err))
 			render.JSON(writer, request, api.ErrorStatus("Failed to delete user"))
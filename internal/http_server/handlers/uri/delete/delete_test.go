@@ -1,6 +1,7 @@
 package delete
 
 import (
+	"context"
 	"errors"
 	"github.com/go-chi/chi/v5"
 	"github.com/google/uuid"
@@ -9,15 +10,16 @@ import (
 	"log/slog"
 	"net/http"
 	"net/http/httptest"
+	"test_golang_user_api/internal/storage"
 	"testing"
 )
 
 type mockUserCRUD struct {
-	deleteFunc func(id uuid.UUID) error
+	deleteFunc func(id uuid.UUID, version int) error
 }
 
-func (m *mockUserCRUD) DeleteUser(id uuid.UUID) error {
-	return m.deleteFunc(id)
+func (m *mockUserCRUD) DeleteUser(_ context.Context, id uuid.UUID, version int) error {
+	return m.deleteFunc(id, version)
 }
 
 func TestDeleteUserHandler(t *testing.T) {
@@ -26,8 +28,9 @@ func TestDeleteUserHandler(t *testing.T) {
 		id := uuid.New()
 		r := chi.NewRouter()
 		mockCrud := &mockUserCRUD{
-			deleteFunc: func(uid uuid.UUID) error {
+			deleteFunc: func(uid uuid.UUID, version int) error {
 				assert.Equal(t, id, uid)
+				assert.Zero(t, version)
 				return nil
 			},
 		}
@@ -64,7 +67,7 @@ func TestDeleteUserHandler(t *testing.T) {
 		id := uuid.New()
 		r := chi.NewRouter()
 		mockCrud := &mockUserCRUD{
-			deleteFunc: func(uuid.UUID) error {
+			deleteFunc: func(uuid.UUID, int) error {
 				return errors.New("db error")
 			},
 		}
@@ -79,4 +82,67 @@ func TestDeleteUserHandler(t *testing.T) {
 		require.Equal(t, http.StatusOK, resp.Code)
 		assert.Contains(t, resp.Body.String(), "Failed to delete user")
 	})
+
+	t.Run("passes If-Match version to storage", func(t *testing.T) {
+		//given
+		id := uuid.New()
+		r := chi.NewRouter()
+		mockCrud := &mockUserCRUD{
+			deleteFunc: func(uid uuid.UUID, version int) error {
+				assert.Equal(t, 3, version)
+				return nil
+			},
+		}
+		handler := New(slog.Default(), mockCrud)
+		r.Delete("/users/{id}", handler)
+
+		req := httptest.NewRequest(http.MethodDelete, "/users/"+id.String(), nil)
+		req.Header.Set("If-Match", `"3"`)
+		resp := httptest.NewRecorder()
+		//when
+		r.ServeHTTP(resp, req)
+
+		//then
+		require.Equal(t, http.StatusOK, resp.Code)
+	})
+
+	t.Run("returns precondition failed on version mismatch", func(t *testing.T) {
+		//given
+		id := uuid.New()
+		r := chi.NewRouter()
+		mockCrud := &mockUserCRUD{
+			deleteFunc: func(uuid.UUID, int) error {
+				return storage.ErrVersionConflict
+			},
+		}
+		handler := New(slog.Default(), mockCrud)
+		r.Delete("/users/{id}", handler)
+
+		req := httptest.NewRequest(http.MethodDelete, "/users/"+id.String(), nil)
+		req.Header.Set("If-Match", `"2"`)
+		resp := httptest.NewRecorder()
+		//when
+		r.ServeHTTP(resp, req)
+
+		//then
+		require.Equal(t, http.StatusPreconditionFailed, resp.Code)
+	})
+
+	t.Run("returns error for invalid If-Match header", func(t *testing.T) {
+		//given
+		id := uuid.New()
+		r := chi.NewRouter()
+		handler := New(slog.Default(), &mockUserCRUD{})
+		r.Delete("/users/{id}", handler)
+
+		req := httptest.NewRequest(http.MethodDelete, "/users/"+id.String(), nil)
+		req.Header.Set("If-Match", `W/"2"`)
+		resp := httptest.NewRecorder()
+		//when
+		r.ServeHTTP(resp, req)
+
+		//then
+		require.Equal(t, http.StatusOK, resp.Code)
+		assert.Contains(t, resp.Body.String(), "Invalid If-Match header")
+	})
 }
@@ -1,18 +1,22 @@
 package get
 
 import (
+	"context"
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/go-chi/render"
 	"github.com/google/uuid"
 	"log/slog"
 	"net/http"
+	"strconv"
 	"test_golang_user_api/internal/api"
-	"test_golang_user_api/internal/storage/postgres"
+	"test_golang_user_api/internal/http_server/middleware/auth"
+	"test_golang_user_api/internal/storage"
 )
 
 type UserCRUD interface {
-	GetUser(id uuid.UUID) (*postgres.UserDto, error)
+	GetUser(ctx context.Context, id uuid.UUID) (*storage.UserDto, error)
+	GetUserIncludingDeleted(ctx context.Context, id uuid.UUID) (*storage.UserDto, error)
 }
 
 func New(log *slog.Logger, crud UserCRUD) http.HandlerFunc {
@@ -30,7 +34,28 @@ func New(log *slog.Logger, crud UserCRUD) http.HandlerFunc {
 			return
 		}
 
-		user, err := crud.GetUser(id)
+		includeDeleted := false
+		if raw := request.URL.Query().Get("include_deleted"); raw != "" {
+			includeDeleted, err = strconv.ParseBool(raw)
+			if err != nil {
+				log.Error("Invalid include_deleted", slog.Any("err", err))
+				render.JSON(writer, request, api.ErrorStatus("Invalid include_deleted"))
+				return
+			}
+		}
+		if includeDeleted && !auth.IdentityFrom(request.Context()).Admin {
+			log.Info("Deleted users requested by non-admin")
+			render.Status(request, http.StatusForbidden)
+			render.JSON(writer, request, api.Forbidden("Admin required"))
+			return
+		}
+
+		var user *storage.UserDto
+		if includeDeleted {
+			user, err = crud.GetUserIncludingDeleted(request.Context(), id)
+		} else {
+			user, err = crud.GetUser(request.Context(), id)
+		}
 
 		if err != nil {
 			log.Error("User not found", slog.Any("err", err))
@@ -38,6 +63,7 @@ func New(log *slog.Logger, crud UserCRUD) http.HandlerFunc {
 			return
 		}
 
+		writer.Header().Set("ETag", api.ETag(user.Version))
 		render.JSON(writer, request, user)
 
 		log.Info("User successfully retrieved")
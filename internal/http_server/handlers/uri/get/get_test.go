@@ -1,6 +1,7 @@
 package get
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"github.com/go-chi/chi/v5"
@@ -11,33 +12,41 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"test_golang_user_api/internal/api"
-	"test_golang_user_api/internal/storage/postgres"
+	"test_golang_user_api/internal/http_server/middleware/auth"
+	"test_golang_user_api/internal/storage"
 	"testing"
+	"time"
 )
 
 type mockUserCRUD struct {
-	getFunc func(id uuid.UUID) (*postgres.UserDto, error)
+	getFunc               func(id uuid.UUID) (*storage.UserDto, error)
+	getIncludeDeletedFunc func(id uuid.UUID) (*storage.UserDto, error)
 }
 
-func (m *mockUserCRUD) GetUser(id uuid.UUID) (*postgres.UserDto, error) {
+func (m *mockUserCRUD) GetUser(_ context.Context, id uuid.UUID) (*storage.UserDto, error) {
 	return m.getFunc(id)
 }
 
+func (m *mockUserCRUD) GetUserIncludingDeleted(_ context.Context, id uuid.UUID) (*storage.UserDto, error) {
+	return m.getIncludeDeletedFunc(id)
+}
+
 func TestGetUserHandler(t *testing.T) {
 	t.Run("successfully retrieves user", func(t *testing.T) {
 		//given
 		id := uuid.New()
 		r := chi.NewRouter()
-		user := &postgres.UserDto{
+		user := &storage.UserDto{
 			ID:        id,
 			Firstname: "Ivan",
 			Lastname:  "Ivanov",
 			Email:     "ivan@gmail.com",
 			Age:       30,
+			Version:   4,
 		}
 
 		mockCrud := &mockUserCRUD{
-			getFunc: func(uid uuid.UUID) (*postgres.UserDto, error) {
+			getFunc: func(uid uuid.UUID) (*storage.UserDto, error) {
 				assert.Equal(t, id, uid)
 				return user, nil
 			},
@@ -55,6 +64,7 @@ func TestGetUserHandler(t *testing.T) {
 		expected, _ := json.Marshal(user)
 		require.Equal(t, http.StatusOK, resp.Code)
 		assert.JSONEq(t, string(expected), resp.Body.String())
+		assert.Equal(t, `"4"`, resp.Header().Get("ETag"))
 	})
 
 	t.Run("returns error for invalid UUID", func(t *testing.T) {
@@ -79,7 +89,7 @@ func TestGetUserHandler(t *testing.T) {
 		id := uuid.New()
 		r := chi.NewRouter()
 		mockCrud := &mockUserCRUD{
-			getFunc: func(uid uuid.UUID) (*postgres.UserDto, error) {
+			getFunc: func(uid uuid.UUID) (*storage.UserDto, error) {
 				return nil, errors.New("not found")
 			},
 		}
@@ -97,4 +107,78 @@ func TestGetUserHandler(t *testing.T) {
 		require.Equal(t, http.StatusOK, resp.Code)
 		assert.JSONEq(t, string(expected), resp.Body.String())
 	})
+
+	t.Run("retrieves deleted user when include_deleted is set", func(t *testing.T) {
+		//given
+		id := uuid.New()
+		r := chi.NewRouter()
+		deleted := time.Now()
+		user := &storage.UserDto{
+			ID:        id,
+			Firstname: "Ivan",
+			Lastname:  "Ivanov",
+			Email:     "ivan@gmail.com",
+			Age:       30,
+			Version:   2,
+			Deleted:   &deleted,
+		}
+
+		mockCrud := &mockUserCRUD{
+			getIncludeDeletedFunc: func(uid uuid.UUID) (*storage.UserDto, error) {
+				assert.Equal(t, id, uid)
+				return user, nil
+			},
+		}
+
+		handler := New(slog.Default(), mockCrud)
+		r.Get("/users/{id}", handler)
+
+		req := httptest.NewRequest(http.MethodGet, "/users/"+id.String()+"?include_deleted=true", nil)
+		req = req.WithContext(auth.WithIdentity(req.Context(), auth.Identity{Admin: true}))
+		resp := httptest.NewRecorder()
+		//when
+		r.ServeHTTP(resp, req)
+
+		//then
+		expected, _ := json.Marshal(user)
+		require.Equal(t, http.StatusOK, resp.Code)
+		assert.JSONEq(t, string(expected), resp.Body.String())
+	})
+
+	t.Run("forbids include_deleted to non-admins", func(t *testing.T) {
+		//given
+		id := uuid.New()
+		r := chi.NewRouter()
+		handler := New(slog.Default(), &mockUserCRUD{})
+		r.Get("/users/{id}", handler)
+
+		req := httptest.NewRequest(http.MethodGet, "/users/"+id.String()+"?include_deleted=true", nil)
+		req = req.WithContext(auth.WithIdentity(req.Context(), auth.Identity{Claims: auth.Claims{"role": "support"}}))
+		resp := httptest.NewRecorder()
+		//when
+		r.ServeHTTP(resp, req)
+
+		//then
+		expected, _ := json.Marshal(api.Forbidden("Admin required"))
+		require.Equal(t, http.StatusForbidden, resp.Code)
+		assert.JSONEq(t, string(expected), resp.Body.String())
+	})
+
+	t.Run("returns error for invalid include_deleted", func(t *testing.T) {
+		//given
+		id := uuid.New()
+		r := chi.NewRouter()
+		handler := New(slog.Default(), &mockUserCRUD{})
+		r.Get("/users/{id}", handler)
+
+		req := httptest.NewRequest(http.MethodGet, "/users/"+id.String()+"?include_deleted=maybe", nil)
+		resp := httptest.NewRecorder()
+		//when
+		r.ServeHTTP(resp, req)
+
+		//then
+		expected, _ := json.Marshal(api.ErrorStatus("Invalid include_deleted"))
+		require.Equal(t, http.StatusOK, resp.Code)
+		assert.JSONEq(t, string(expected), resp.Body.String())
+	})
 }
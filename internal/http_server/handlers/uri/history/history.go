@@ -0,0 +1,91 @@
+package history
+
+import (
+	"context"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/go-chi/render"
+	"github.com/google/uuid"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"test_golang_user_api/internal/api"
+	"test_golang_user_api/internal/http_server/middleware/auth"
+	"test_golang_user_api/internal/storage"
+)
+
+const (
+	defaultLimit = 20
+	maxLimit     = 100
+)
+
+type UserCRUD interface {
+	GetUser(ctx context.Context, id uuid.UUID) (*storage.UserDto, error)
+	UserHistory(ctx context.Context, id uuid.UUID, limit, offset int) ([]storage.AuditEntry, error)
+}
+
+type Response struct {
+	Entries []storage.AuditEntry `json:"entries"`
+	Limit   int                  `json:"limit"`
+	Offset  int                  `json:"offset"`
+}
+
+func New(log *slog.Logger, crud UserCRUD) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		log.With(
+			slog.String("request_id", middleware.GetReqID(request.Context())),
+		)
+
+		idStr := chi.URLParam(request, "id")
+
+		id, err := uuid.Parse(idStr)
+		if err != nil {
+			log.Error("Invalid UUID", slog.Any("err", err))
+			render.JSON(writer, request, api.ErrorStatus("Invalid UUID"))
+			return
+		}
+
+		limit, err := queryInt(request, "limit", defaultLimit)
+		if err != nil || limit < 1 || limit > maxLimit {
+			log.Error("Invalid limit", slog.Any("err", err))
+			render.JSON(writer, request, api.ErrorStatus("Invalid limit"))
+			return
+		}
+
+		offset, err := queryInt(request, "offset", 0)
+		if err != nil || offset < 0 {
+			log.Error("Invalid offset", slog.Any("err", err))
+			render.JSON(writer, request, api.ErrorStatus("Invalid offset"))
+			return
+		}
+
+		// The history of a deleted user holds its snapshots, which only
+		// admins may read, as with GET /user/{id}?include_deleted=true.
+		if !auth.IdentityFrom(request.Context()).Admin {
+			if _, err := crud.GetUser(request.Context(), id); err != nil {
+				log.Error("User not found", slog.Any("err", err))
+				render.JSON(writer, request, api.ErrorStatus("User not found"))
+				return
+			}
+		}
+
+		entries, err := crud.UserHistory(request.Context(), id, limit, offset)
+		if err != nil {
+			log.Error("Error getting user history", slog.Any("err", err))
+			render.JSON(writer, request, api.ErrorStatus("Failed to get user history"))
+			return
+		}
+
+		render.JSON(writer, request, Response{Entries: entries, Limit: limit, Offset: offset})
+
+		log.Info("User history successfully retrieved")
+	}
+}
+
+func queryInt(request *http.Request, name string, fallback int) (int, error) {
+	raw := request.URL.Query().Get(name)
+	if raw == "" {
+		return fallback, nil
+	}
+	return strconv.Atoi(raw)
+}
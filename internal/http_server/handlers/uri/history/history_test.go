@@ -0,0 +1,183 @@
+package history
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"test_golang_user_api/internal/api"
+	"test_golang_user_api/internal/http_server/middleware/auth"
+	"test_golang_user_api/internal/storage"
+	"testing"
+)
+
+type mockUserCRUD struct {
+	getFunc     func(id uuid.UUID) (*storage.UserDto, error)
+	historyFunc func(id uuid.UUID, limit, offset int) ([]storage.AuditEntry, error)
+}
+
+func (m *mockUserCRUD) GetUser(_ context.Context, id uuid.UUID) (*storage.UserDto, error) {
+	if m.getFunc == nil {
+		return &storage.UserDto{ID: id}, nil
+	}
+	return m.getFunc(id)
+}
+
+func (m *mockUserCRUD) UserHistory(_ context.Context, id uuid.UUID, limit, offset int) ([]storage.AuditEntry, error) {
+	return m.historyFunc(id, limit, offset)
+}
+
+func TestHistoryHandler(t *testing.T) {
+	t.Run("successfully retrieves history page", func(t *testing.T) {
+		//given
+		id := uuid.New()
+		r := chi.NewRouter()
+		entries := []storage.AuditEntry{
+			{ID: 2, UserID: id, Actor: "admin", Operation: storage.AuditEdit, Before: json.RawMessage(`{"Age":30}`), After: json.RawMessage(`{"Age":31}`)},
+		}
+		mockCrud := &mockUserCRUD{
+			historyFunc: func(uid uuid.UUID, limit, offset int) ([]storage.AuditEntry, error) {
+				assert.Equal(t, id, uid)
+				assert.Equal(t, 5, limit)
+				assert.Equal(t, 10, offset)
+				return entries, nil
+			},
+		}
+		handler := New(slog.Default(), mockCrud)
+		r.Get("/users/{id}/history", handler)
+
+		req := httptest.NewRequest(http.MethodGet, "/users/"+id.String()+"/history?limit=5&offset=10", nil)
+		resp := httptest.NewRecorder()
+		//when
+		r.ServeHTTP(resp, req)
+
+		//then
+		expected, _ := json.Marshal(Response{Entries: entries, Limit: 5, Offset: 10})
+		require.Equal(t, http.StatusOK, resp.Code)
+		assert.JSONEq(t, string(expected), resp.Body.String())
+	})
+
+	t.Run("uses default page size", func(t *testing.T) {
+		//given
+		id := uuid.New()
+		r := chi.NewRouter()
+		mockCrud := &mockUserCRUD{
+			historyFunc: func(uid uuid.UUID, limit, offset int) ([]storage.AuditEntry, error) {
+				assert.Equal(t, defaultLimit, limit)
+				assert.Zero(t, offset)
+				return []storage.AuditEntry{}, nil
+			},
+		}
+		handler := New(slog.Default(), mockCrud)
+		r.Get("/users/{id}/history", handler)
+
+		req := httptest.NewRequest(http.MethodGet, "/users/"+id.String()+"/history", nil)
+		resp := httptest.NewRecorder()
+		//when
+		r.ServeHTTP(resp, req)
+
+		//then
+		require.Equal(t, http.StatusOK, resp.Code)
+	})
+
+	t.Run("hides history of deleted user from non-admins", func(t *testing.T) {
+		//given
+		id := uuid.New()
+		r := chi.NewRouter()
+		mockCrud := &mockUserCRUD{
+			getFunc: func(uid uuid.UUID) (*storage.UserDto, error) {
+				assert.Equal(t, id, uid)
+				return nil, storage.ErrUserNotFound
+			},
+		}
+		handler := New(slog.Default(), mockCrud)
+		r.Get("/users/{id}/history", handler)
+
+		req := httptest.NewRequest(http.MethodGet, "/users/"+id.String()+"/history", nil)
+		resp := httptest.NewRecorder()
+		//when
+		r.ServeHTTP(resp, req)
+
+		//then
+		expected, _ := json.Marshal(api.ErrorStatus("User not found"))
+		require.Equal(t, http.StatusOK, resp.Code)
+		assert.JSONEq(t, string(expected), resp.Body.String())
+	})
+
+	t.Run("shows history of deleted user to admins", func(t *testing.T) {
+		//given
+		id := uuid.New()
+		r := chi.NewRouter()
+		entries := []storage.AuditEntry{
+			{ID: 3, UserID: id, Actor: "admin", Operation: storage.AuditDelete, Before: json.RawMessage(`{"Age":31}`)},
+		}
+		mockCrud := &mockUserCRUD{
+			getFunc: func(uuid.UUID) (*storage.UserDto, error) {
+				return nil, storage.ErrUserNotFound
+			},
+			historyFunc: func(uuid.UUID, int, int) ([]storage.AuditEntry, error) {
+				return entries, nil
+			},
+		}
+		handler := New(slog.Default(), mockCrud)
+		r.Get("/users/{id}/history", handler)
+
+		req := httptest.NewRequest(http.MethodGet, "/users/"+id.String()+"/history", nil)
+		req = req.WithContext(auth.WithIdentity(req.Context(), auth.Identity{Admin: true}))
+		resp := httptest.NewRecorder()
+		//when
+		r.ServeHTTP(resp, req)
+
+		//then
+		expected, _ := json.Marshal(Response{Entries: entries, Limit: defaultLimit, Offset: 0})
+		require.Equal(t, http.StatusOK, resp.Code)
+		assert.JSONEq(t, string(expected), resp.Body.String())
+	})
+
+	t.Run("returns error for invalid limit", func(t *testing.T) {
+		//given
+		id := uuid.New()
+		r := chi.NewRouter()
+		handler := New(slog.Default(), &mockUserCRUD{})
+		r.Get("/users/{id}/history", handler)
+
+		req := httptest.NewRequest(http.MethodGet, "/users/"+id.String()+"/history?limit=1000", nil)
+		resp := httptest.NewRecorder()
+		//when
+		r.ServeHTTP(resp, req)
+
+		//then
+		expected, _ := json.Marshal(api.ErrorStatus("Invalid limit"))
+		require.Equal(t, http.StatusOK, resp.Code)
+		assert.JSONEq(t, string(expected), resp.Body.String())
+	})
+
+	t.Run("returns error when history query fails", func(t *testing.T) {
+		//given
+		id := uuid.New()
+		r := chi.NewRouter()
+		mockCrud := &mockUserCRUD{
+			historyFunc: func(uuid.UUID, int, int) ([]storage.AuditEntry, error) {
+				return nil, errors.New("db error")
+			},
+		}
+		handler := New(slog.Default(), mockCrud)
+		r.Get("/users/{id}/history", handler)
+
+		req := httptest.NewRequest(http.MethodGet, "/users/"+id.String()+"/history", nil)
+		resp := httptest.NewRecorder()
+		//when
+		r.ServeHTTP(resp, req)
+
+		//then
+		expected, _ := json.Marshal(api.ErrorStatus("Failed to get user history"))
+		require.Equal(t, http.StatusOK, resp.Code)
+		assert.JSONEq(t, string(expected), resp.Body.String())
+	})
+}
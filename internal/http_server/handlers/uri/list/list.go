@@ -0,0 +1,151 @@
+package list
+
+import (
+	"context"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/go-chi/render"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"test_golang_user_api/internal/api"
+	"test_golang_user_api/internal/storage"
+)
+
+const (
+	defaultLimit = 20
+	maxLimit     = 100
+)
+
+var reserved = map[string]bool{"limit": true, "next": true, "prev": true, "total": true, "sort": true}
+
+type UserCRUD interface {
+	ListUsers(ctx context.Context, query storage.ListQuery) (*storage.UserPage, error)
+}
+
+// Response is a page of users in the requested order, oldest first by
+// default. Next and Prev are opaque cursors for the following and
+// preceding pages, passed back as the next and prev query parameters
+// together with the same sort and filters.
+type Response struct {
+	Users []storage.UserDto `json:"users"`
+	Limit int               `json:"limit"`
+	Next  string            `json:"next,omitempty"`
+	Prev  string            `json:"prev,omitempty"`
+	Total *int64            `json:"total,omitempty"`
+}
+
+func New(log *slog.Logger, crud UserCRUD) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		log.With(
+			slog.String("request_id", middleware.GetReqID(request.Context())),
+		)
+
+		params := request.URL.Query()
+
+		query := storage.ListQuery{Limit: defaultLimit}
+		if raw := params.Get("limit"); raw != "" {
+			limit, err := strconv.Atoi(raw)
+			if err != nil || limit < 1 || limit > maxLimit {
+				log.Error("Invalid limit", slog.Any("err", err))
+				render.JSON(writer, request, api.ErrorStatus("Invalid limit"))
+				return
+			}
+			query.Limit = limit
+		}
+
+		var err error
+		if query.Sort, err = storage.ParseSort(params.Get("sort")); err != nil {
+			log.Error("Invalid sort", slog.Any("err", err))
+			render.JSON(writer, request, api.ErrorStatus("Invalid sort: "+detail(err, storage.ErrInvalidSort)))
+			return
+		}
+
+		if query.Filter, err = filterParams(params); err != nil {
+			log.Error("Invalid filter", slog.Any("err", err))
+			render.JSON(writer, request, api.ErrorStatus("Invalid filter: "+detail(err, storage.ErrInvalidFilter)))
+			return
+		}
+
+		if query.After, err = cursorParam(params.Get("next"), query.Sort); err != nil {
+			log.Error("Invalid cursor", slog.Any("err", err))
+			render.JSON(writer, request, api.ErrorStatus("Invalid cursor"))
+			return
+		}
+		if query.Before, err = cursorParam(params.Get("prev"), query.Sort); err != nil || (query.Before != nil && query.After != nil) {
+			log.Error("Invalid cursor", slog.Any("err", err))
+			render.JSON(writer, request, api.ErrorStatus("Invalid cursor"))
+			return
+		}
+
+		if raw := params.Get("total"); raw != "" {
+			query.WithTotal, err = strconv.ParseBool(raw)
+			if err != nil {
+				log.Error("Invalid total", slog.Any("err", err))
+				render.JSON(writer, request, api.ErrorStatus("Invalid total"))
+				return
+			}
+		}
+
+		page, err := crud.ListUsers(request.Context(), query)
+		if err != nil {
+			log.Error("Error listing users", slog.Any("err", err))
+			render.JSON(writer, request, api.ErrorStatus("Failed to list users"))
+			return
+		}
+
+		response := Response{Users: page.Users, Limit: query.Limit, Total: page.Total}
+		if page.Next != nil {
+			response.Next = page.Next.String()
+		}
+		if page.Prev != nil {
+			response.Prev = page.Prev.String()
+		}
+		render.JSON(writer, request, response)
+
+		log.Info("Users successfully listed")
+	}
+}
+
+func cursorParam(raw string, sort storage.Sort) (*storage.Cursor, error) {
+	if raw == "" {
+		return nil, nil
+	}
+	cursor, err := storage.ParseCursor(raw, sort)
+	if err != nil {
+		return nil, err
+	}
+	return &cursor, nil
+}
+
+// filterParams reads every parameter other than those of paging and
+// sorting as a condition named field or field.op, e.g. age.gte=18.
+func filterParams(params url.Values) ([]storage.Condition, error) {
+	names := make([]string, 0, len(params))
+	for name := range params {
+		if !reserved[name] {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+
+	var filter []storage.Condition
+	for _, name := range names {
+		field, op, _ := strings.Cut(name, ".")
+		for _, value := range params[name] {
+			condition, err := storage.ParseCondition(field, op, value)
+			if err != nil {
+				return nil, err
+			}
+			filter = append(filter, condition)
+		}
+	}
+	return filter, nil
+}
+
+// detail strips the sentinel from err for the response.
+func detail(err, sentinel error) string {
+	return strings.TrimPrefix(err.Error(), sentinel.Error()+": ")
+}
@@ -0,0 +1,179 @@
+package list
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"test_golang_user_api/internal/api"
+	"test_golang_user_api/internal/storage"
+	"testing"
+	"time"
+)
+
+type mockUserCRUD struct {
+	listFunc func(query storage.ListQuery) (*storage.UserPage, error)
+}
+
+func (m *mockUserCRUD) ListUsers(_ context.Context, query storage.ListQuery) (*storage.UserPage, error) {
+	return m.listFunc(query)
+}
+
+func serve(crud UserCRUD, target string) *httptest.ResponseRecorder {
+	r := chi.NewRouter()
+	r.Get("/users", New(slog.Default(), crud))
+
+	resp := httptest.NewRecorder()
+	r.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, target, nil))
+	return resp
+}
+
+func TestListHandler(t *testing.T) {
+	t.Run("successfully lists page with cursors", func(t *testing.T) {
+		//given
+		after := storage.Sort(nil).CursorOf(*storage.NewUser(uuid.New(), "Anna", "Smith", "anna@gmail.com", 20))
+		user := storage.NewUser(uuid.New(), "Ivan", "Ivanov", "ivan@gmail.com", 30)
+		next := storage.Sort(nil).CursorOf(*user)
+		total := int64(42)
+		mockCrud := &mockUserCRUD{
+			listFunc: func(query storage.ListQuery) (*storage.UserPage, error) {
+				assert.Equal(t, 5, query.Limit)
+				require.NotNil(t, query.After)
+				assert.Equal(t, after.String(), query.After.String())
+				assert.Nil(t, query.Before)
+				assert.True(t, query.WithTotal)
+				return &storage.UserPage{Users: []storage.UserDto{*user}, Next: &next, Prev: &next, Total: &total}, nil
+			},
+		}
+		//when
+		resp := serve(mockCrud, "/users?limit=5&next="+after.String()+"&total=true")
+		//then
+		expected, _ := json.Marshal(Response{Users: []storage.UserDto{*user}, Limit: 5, Next: next.String(), Prev: next.String(), Total: &total})
+		require.Equal(t, http.StatusOK, resp.Code)
+		assert.JSONEq(t, string(expected), resp.Body.String())
+	})
+
+	t.Run("uses default page size and pages backward", func(t *testing.T) {
+		//given
+		before := storage.Sort(nil).CursorOf(*storage.NewUser(uuid.New(), "Anna", "Smith", "anna@gmail.com", 20))
+		mockCrud := &mockUserCRUD{
+			listFunc: func(query storage.ListQuery) (*storage.UserPage, error) {
+				assert.Equal(t, defaultLimit, query.Limit)
+				assert.Nil(t, query.After)
+				require.NotNil(t, query.Before)
+				assert.Equal(t, before.String(), query.Before.String())
+				assert.False(t, query.WithTotal)
+				return &storage.UserPage{Users: []storage.UserDto{}}, nil
+			},
+		}
+		//when
+		resp := serve(mockCrud, "/users?prev="+before.String())
+		//then
+		require.Equal(t, http.StatusOK, resp.Code)
+		assert.JSONEq(t, `{"users":[],"limit":20}`, resp.Body.String())
+	})
+
+	t.Run("returns error for invalid limit", func(t *testing.T) {
+		//when
+		resp := serve(&mockUserCRUD{}, "/users?limit=1000")
+		//then
+		expected, _ := json.Marshal(api.ErrorStatus("Invalid limit"))
+		require.Equal(t, http.StatusOK, resp.Code)
+		assert.JSONEq(t, string(expected), resp.Body.String())
+	})
+
+	t.Run("returns error for invalid cursor", func(t *testing.T) {
+		//when
+		resp := serve(&mockUserCRUD{}, "/users?next=garbage")
+		//then
+		expected, _ := json.Marshal(api.ErrorStatus("Invalid cursor"))
+		require.Equal(t, http.StatusOK, resp.Code)
+		assert.JSONEq(t, string(expected), resp.Body.String())
+	})
+
+	t.Run("returns error for both cursors", func(t *testing.T) {
+		//given
+		cursor := storage.Sort(nil).CursorOf(*storage.NewUser(uuid.New(), "Anna", "Smith", "anna@gmail.com", 20))
+		//when
+		resp := serve(&mockUserCRUD{}, "/users?next="+cursor.String()+"&prev="+cursor.String())
+		//then
+		expected, _ := json.Marshal(api.ErrorStatus("Invalid cursor"))
+		require.Equal(t, http.StatusOK, resp.Code)
+		assert.JSONEq(t, string(expected), resp.Body.String())
+	})
+
+	t.Run("passes filters and sort", func(t *testing.T) {
+		//given
+		mockCrud := &mockUserCRUD{
+			listFunc: func(query storage.ListQuery) (*storage.UserPage, error) {
+				assert.Equal(t, storage.Sort{{Field: storage.FieldAge, Desc: true}, {Field: storage.FieldLastname}}, query.Sort)
+				assert.Equal(t, []storage.Condition{
+					{Field: storage.FieldAge, Op: storage.OpGte, Value: 18},
+					{Field: storage.FieldAge, Op: storage.OpLte, Value: 25},
+					{Field: storage.FieldCreated, Op: storage.OpGte, Value: time.Date(2024, 5, 6, 0, 0, 0, 0, time.UTC)},
+					{Field: storage.FieldEmail, Op: storage.OpDomain, Value: "acme.com"},
+					{Field: storage.FieldLastname, Op: storage.OpEq, Value: "Ivanov"},
+				}, query.Filter)
+				return &storage.UserPage{Users: []storage.UserDto{}}, nil
+			},
+		}
+		//when
+		resp := serve(mockCrud, "/users?sort=-age,lastname&email.domain=acme.com&age.gte=18&age.lte=25&created.gte=2024-05-06&lastname=Ivanov")
+		//then
+		require.Equal(t, http.StatusOK, resp.Code)
+		assert.JSONEq(t, `{"users":[],"limit":20}`, resp.Body.String())
+	})
+
+	t.Run("returns cursor error for cursor of another sort", func(t *testing.T) {
+		//given
+		cursor := storage.Sort(nil).CursorOf(*storage.NewUser(uuid.New(), "Anna", "Smith", "anna@gmail.com", 20))
+		//when
+		resp := serve(&mockUserCRUD{}, "/users?sort=age&next="+cursor.String())
+		//then
+		expected, _ := json.Marshal(api.ErrorStatus("Invalid cursor"))
+		assert.JSONEq(t, string(expected), resp.Body.String())
+	})
+
+	for _, tc := range []struct {
+		query string
+		error string
+	}{
+		{"sort=password", `Invalid sort: unknown field "password"`},
+		{"sort=age,-age", "Invalid sort: age is repeated"},
+		{"password=secret", `Invalid filter: unknown field "password"`},
+		{"age.prefix=1", `Invalid filter: age does not support "prefix"`},
+		{"age.gte=old", `Invalid filter: age.gte: strconv.Atoi: parsing "old": invalid syntax`},
+		{"created.gte=yesterday", `Invalid filter: created.gte: expected a date or RFC 3339 time, got "yesterday"`},
+		{"email.domain=", "Invalid filter: email.domain needs a value"},
+	} {
+		t.Run("returns validation error for "+tc.query, func(t *testing.T) {
+			//when
+			resp := serve(&mockUserCRUD{}, "/users?"+tc.query)
+			//then
+			expected, _ := json.Marshal(api.ErrorStatus(tc.error))
+			require.Equal(t, http.StatusOK, resp.Code)
+			assert.JSONEq(t, string(expected), resp.Body.String())
+		})
+	}
+
+	t.Run("returns error when list query fails", func(t *testing.T) {
+		//given
+		mockCrud := &mockUserCRUD{
+			listFunc: func(storage.ListQuery) (*storage.UserPage, error) {
+				return nil, errors.New("db error")
+			},
+		}
+		//when
+		resp := serve(mockCrud, "/users")
+		//then
+		expected, _ := json.Marshal(api.ErrorStatus("Failed to list users"))
+		require.Equal(t, http.StatusOK, resp.Code)
+		assert.JSONEq(t, string(expected), resp.Body.String())
+	})
+}
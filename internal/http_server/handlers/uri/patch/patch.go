@@ -1,19 +1,22 @@
 package patch
 
 import (
+	"context"
+	"errors"
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/go-chi/render"
 	"github.com/go-playground/validator/v10"
 	"github.com/google/uuid"
 	"log/slog"
+	"mime"
 	"net/http"
 	"test_golang_user_api/internal/api"
-	"test_golang_user_api/internal/storage/postgres"
+	"test_golang_user_api/internal/storage"
 )
 
 type UserCRUD interface {
-	EditUser(user *postgres.UserDto) (*postgres.UserDto, error)
+	EditUser(ctx context.Context, id uuid.UUID, patch storage.UserPatch, version int) (*storage.UserDto, error)
 }
 
 func New(log *slog.Logger, userCrud UserCRUD) http.HandlerFunc {
@@ -21,17 +24,19 @@ func New(log *slog.Logger, userCrud UserCRUD) http.HandlerFunc {
 		log.With(
 			slog.String("request_id", middleware.GetReqID(request.Context())),
 		)
-		var req api.Request
 
-		err := render.DecodeJSON(request.Body, &req)
-		if err != nil {
-			log.Error("Error decoding request body", slog.Any("err", err))
-			render.JSON(writer, request, api.ErrorStatus("Failed to decode request body"))
+		var patch storage.UserPatch
+		var failure string
+		if mediaType, _, _ := mime.ParseMediaType(request.Header.Get("Content-Type")); mediaType == api.MergePatchType {
+			patch, failure = decodeMergePatch(log, request)
+		} else {
+			patch, failure = decodeReplacement(log, request)
+		}
+		if failure != "" {
+			render.JSON(writer, request, api.ErrorStatus(failure))
 			return
 		}
 
-		log.Info("Request body decoded", slog.Any("requestBody", req))
-
 		idStr := chi.URLParam(request, "id")
 
 		id, err := uuid.Parse(idStr)
@@ -41,21 +46,92 @@ func New(log *slog.Logger, userCrud UserCRUD) http.HandlerFunc {
 			return
 		}
 
-		if err := validator.New().Struct(req); err != nil {
-			log.Error("Error validating request body", slog.Any("err", err))
-			render.JSON(writer, request, api.ErrorStatus("Failed to validate request body"))
+		version, err := api.IfMatchVersion(request)
+		if err != nil {
+			log.Error("Invalid If-Match header", slog.Any("err", err))
+			render.JSON(writer, request, api.ErrorStatus("Invalid If-Match header"))
 			return
 		}
 
-		user, err := userCrud.EditUser(postgres.NewUser(id, req.Firstname, req.Lastname, req.Email, req.Age))
+		user, err := userCrud.EditUser(request.Context(), id, patch, version)
+		if errors.Is(err, storage.ErrVersionConflict) {
+			log.Error("User version mismatch", slog.Any("err", err))
+			render.Status(request, http.StatusPreconditionFailed)
+			render.JSON(writer, request, api.PreconditionFailed("User was modified"))
+			return
+		}
 		if err != nil {
 			log.Error("Error edit user", slog.Any("err", err))
 			render.JSON(writer, request, api.ErrorStatus("Failed to edit user"))
 			return
 		}
 
+		writer.Header().Set("ETag", api.ETag(user.Version))
 		render.JSON(writer, request, user)
 
 		log.Info("User edit successfully")
 	}
 }
+
+const (
+	failedDecode   = "Failed to decode request body"
+	failedValidate = "Failed to validate request body"
+)
+
+// decodeReplacement reads a whole user, replacing every editable field.
+// A failure is returned as the message of the error response.
+func decodeReplacement(log *slog.Logger, request *http.Request) (storage.UserPatch, string) {
+	var req api.Request
+
+	if err := render.DecodeJSON(request.Body, &req); err != nil {
+		log.Error("Error decoding request body", slog.Any("err", err))
+		return storage.UserPatch{}, failedDecode
+	}
+
+	log.Info("Request body decoded", slog.Any("requestBody", req))
+
+	email, err := api.NormalizeEmail(req.Email)
+	if err != nil {
+		log.Error("Error validating request body", slog.Any("err", err))
+		return storage.UserPatch{}, failedValidate
+	}
+	req.Email = email
+
+	if err := validator.New().Struct(req); err != nil {
+		log.Error("Error validating request body", slog.Any("err", err))
+		return storage.UserPatch{}, failedValidate
+	}
+
+	return storage.UserPatch{Firstname: &req.Firstname, Lastname: &req.Lastname, Email: &req.Email, Age: &req.Age}, ""
+}
+
+// decodeMergePatch reads a merge patch, changing only the fields present.
+func decodeMergePatch(log *slog.Logger, request *http.Request) (storage.UserPatch, string) {
+	req, err := api.DecodeMergePatch(request.Body)
+	if errors.Is(err, api.ErrNullField) {
+		log.Error("Error validating request body", slog.Any("err", err))
+		return storage.UserPatch{}, failedValidate
+	}
+	if err != nil {
+		log.Error("Error decoding request body", slog.Any("err", err))
+		return storage.UserPatch{}, failedDecode
+	}
+
+	log.Info("Request body decoded", slog.Any("requestBody", req))
+
+	if req.Email != nil {
+		email, err := api.NormalizeEmail(*req.Email)
+		if err != nil {
+			log.Error("Error validating request body", slog.Any("err", err))
+			return storage.UserPatch{}, failedValidate
+		}
+		req.Email = &email
+	}
+
+	if err := validator.New().Struct(req); err != nil {
+		log.Error("Error validating request body", slog.Any("err", err))
+		return storage.UserPatch{}, failedValidate
+	}
+
+	return req.UserPatch(), ""
+}
@@ -2,6 +2,7 @@ package patch
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"github.com/go-chi/chi/v5"
@@ -11,17 +12,18 @@ import (
 	"log/slog"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"test_golang_user_api/internal/api"
-	"test_golang_user_api/internal/storage/postgres"
+	"test_golang_user_api/internal/storage"
 	"testing"
 )
 
 type mockUserCRUD struct {
-	editFunc func(user *postgres.UserDto) (*postgres.UserDto, error)
+	editFunc func(id uuid.UUID, patch storage.UserPatch, version int) (*storage.UserDto, error)
 }
 
-func (m *mockUserCRUD) EditUser(user *postgres.UserDto) (*postgres.UserDto, error) {
-	return m.editFunc(user)
+func (m *mockUserCRUD) EditUser(_ context.Context, id uuid.UUID, patch storage.UserPatch, version int) (*storage.UserDto, error) {
+	return m.editFunc(id, patch, version)
 }
 
 func TestPatchUserHandler(t *testing.T) {
@@ -35,10 +37,12 @@ func TestPatchUserHandler(t *testing.T) {
 			Email:     "ivan@example.com",
 			Age:       30,
 		}
-		updatedUser := postgres.NewUser(id, request.Firstname, request.Lastname, request.Email, request.Age)
+		updatedUser := storage.NewUser(id, request.Firstname, request.Lastname, request.Email, request.Age)
 		mockCrud := &mockUserCRUD{
-			editFunc: func(u *postgres.UserDto) (*postgres.UserDto, error) {
-				assert.Equal(t, updatedUser.Firstname, u.Firstname)
+			editFunc: func(editID uuid.UUID, patch storage.UserPatch, version int) (*storage.UserDto, error) {
+				assert.Equal(t, id, editID)
+				assert.Equal(t, updatedUser.Patch(), patch)
+				assert.Zero(t, version)
 				return updatedUser, nil
 			},
 		}
@@ -98,12 +102,29 @@ func TestPatchUserHandler(t *testing.T) {
 		assert.JSONEq(t, string(expected), resp.Body.String())
 	})
 
+	t.Run("returns error for email that cannot be normalized", func(t *testing.T) {
+		//given
+		r := chi.NewRouter()
+		r.Patch("/users/{id}", New(slog.Default(), &mockUserCRUD{}))
+
+		body, _ := json.Marshal(api.Request{Firstname: "Ivan", Lastname: "Ivanov", Email: "ivan@xn--zz.com", Age: 30})
+		req := httptest.NewRequest(http.MethodPatch, "/users/"+uuid.New().String(), bytes.NewReader(body))
+		resp := httptest.NewRecorder()
+		//when
+		r.ServeHTTP(resp, req)
+
+		//then
+		expected, _ := json.Marshal(api.ErrorStatus("Failed to validate request body"))
+		require.Equal(t, http.StatusOK, resp.Code)
+		assert.JSONEq(t, string(expected), resp.Body.String())
+	})
+
 	t.Run("returns error when update fails", func(t *testing.T) {
 		//given
 		id := uuid.New()
 		r := chi.NewRouter()
 		mockCrud := &mockUserCRUD{
-			editFunc: func(u *postgres.UserDto) (*postgres.UserDto, error) {
+			editFunc: func(uuid.UUID, storage.UserPatch, int) (*storage.UserDto, error) {
 				return nil, errors.New("db error")
 			},
 		}
@@ -126,4 +147,143 @@ func TestPatchUserHandler(t *testing.T) {
 		require.Equal(t, http.StatusOK, resp.Code)
 		assert.JSONEq(t, string(expected), resp.Body.String())
 	})
+
+	t.Run("passes If-Match version and returns new ETag", func(t *testing.T) {
+		//given
+		id := uuid.New()
+		r := chi.NewRouter()
+		mockCrud := &mockUserCRUD{
+			editFunc: func(id uuid.UUID, patch storage.UserPatch, version int) (*storage.UserDto, error) {
+				assert.Equal(t, 2, version)
+				updated := patch.Apply(storage.UserDto{ID: id})
+				updated.Version = 3
+				return &updated, nil
+			},
+		}
+		handler := New(slog.Default(), mockCrud)
+		r.Patch("/users/{id}", handler)
+
+		body, _ := json.Marshal(api.Request{
+			Firstname: "Ivan",
+			Lastname:  "Ivanov",
+			Email:     "ivan@example.com",
+			Age:       30,
+		})
+		req := httptest.NewRequest(http.MethodPatch, "/users/"+id.String(), bytes.NewReader(body))
+		req.Header.Set("If-Match", `"2"`)
+		resp := httptest.NewRecorder()
+		//when
+		r.ServeHTTP(resp, req)
+
+		//then
+		require.Equal(t, http.StatusOK, resp.Code)
+		assert.Equal(t, `"3"`, resp.Header().Get("ETag"))
+	})
+
+	t.Run("returns precondition failed on version mismatch", func(t *testing.T) {
+		//given
+		id := uuid.New()
+		r := chi.NewRouter()
+		mockCrud := &mockUserCRUD{
+			editFunc: func(uuid.UUID, storage.UserPatch, int) (*storage.UserDto, error) {
+				return nil, storage.ErrVersionConflict
+			},
+		}
+		handler := New(slog.Default(), mockCrud)
+		r.Patch("/users/{id}", handler)
+
+		body, _ := json.Marshal(api.Request{
+			Firstname: "Ivan",
+			Lastname:  "Ivanov",
+			Email:     "ivan@example.com",
+			Age:       30,
+		})
+		req := httptest.NewRequest(http.MethodPatch, "/users/"+id.String(), bytes.NewReader(body))
+		req.Header.Set("If-Match", `"1"`)
+		resp := httptest.NewRecorder()
+		//when
+		r.ServeHTTP(resp, req)
+
+		//then
+		expected, _ := json.Marshal(api.PreconditionFailed("User was modified"))
+		require.Equal(t, http.StatusPreconditionFailed, resp.Code)
+		assert.JSONEq(t, string(expected), resp.Body.String())
+	})
+
+	t.Run("applies merge patch to present fields only", func(t *testing.T) {
+		//given
+		id := uuid.New()
+		r := chi.NewRouter()
+		updatedUser := storage.NewUser(id, "Ivan", "Petrov", "ivan@example.com", 30)
+		mockCrud := &mockUserCRUD{
+			editFunc: func(editID uuid.UUID, patch storage.UserPatch, version int) (*storage.UserDto, error) {
+				lastname, email := "Petrov", "Ivan@xn--e1afmkfd.xn--p1ai"
+				assert.Equal(t, storage.UserPatch{Lastname: &lastname, Email: &email}, patch)
+				return updatedUser, nil
+			},
+		}
+		handler := New(slog.Default(), mockCrud)
+		r.Patch("/users/{id}", handler)
+
+		req := httptest.NewRequest(http.MethodPatch, "/users/"+id.String(), strings.NewReader(`{"lastname":"Petrov","email":"Ivan@Пример.рф","unknown":1}`))
+		req.Header.Set("Content-Type", "application/merge-patch+json; charset=utf-8")
+		resp := httptest.NewRecorder()
+		//when
+		r.ServeHTTP(resp, req)
+
+		//then
+		expected, _ := json.Marshal(updatedUser)
+		require.Equal(t, http.StatusOK, resp.Code)
+		assert.JSONEq(t, string(expected), resp.Body.String())
+	})
+
+	for _, tc := range []struct {
+		name  string
+		body  string
+		error string
+	}{
+		{"null field", `{"firstname":null}`, "Failed to validate request body"},
+		{"empty field", `{"firstname":""}`, "Failed to validate request body"},
+		{"invalid email", `{"email":"ivan"}`, "Failed to validate request body"},
+		{"email that cannot be normalized", `{"email":"ivan@xn--zz.com"}`, "Failed to validate request body"},
+		{"zero age", `{"age":0}`, "Failed to validate request body"},
+		{"negative age", `{"age":-1}`, "Failed to validate request body"},
+		{"mistyped field", `{"age":"thirty"}`, "Failed to decode request body"},
+		{"array", `[{"age":30}]`, "Failed to decode request body"},
+		{"null document", `null`, "Failed to decode request body"},
+	} {
+		t.Run("rejects merge patch with "+tc.name, func(t *testing.T) {
+			//given
+			r := chi.NewRouter()
+			r.Patch("/users/{id}", New(slog.Default(), &mockUserCRUD{}))
+
+			req := httptest.NewRequest(http.MethodPatch, "/users/"+uuid.New().String(), strings.NewReader(tc.body))
+			req.Header.Set("Content-Type", api.MergePatchType)
+			resp := httptest.NewRecorder()
+			//when
+			r.ServeHTTP(resp, req)
+
+			//then
+			expected, _ := json.Marshal(api.ErrorStatus(tc.error))
+			require.Equal(t, http.StatusOK, resp.Code)
+			assert.JSONEq(t, string(expected), resp.Body.String())
+		})
+	}
+
+	t.Run("requires whole user without merge patch content type", func(t *testing.T) {
+		//given
+		r := chi.NewRouter()
+		r.Patch("/users/{id}", New(slog.Default(), &mockUserCRUD{}))
+
+		req := httptest.NewRequest(http.MethodPatch, "/users/"+uuid.New().String(), strings.NewReader(`{"lastname":"Petrov"}`))
+		req.Header.Set("Content-Type", "application/json")
+		resp := httptest.NewRecorder()
+		//when
+		r.ServeHTTP(resp, req)
+
+		//then
+		expected, _ := json.Marshal(api.ErrorStatus("Failed to validate request body"))
+		require.Equal(t, http.StatusOK, resp.Code)
+		assert.JSONEq(t, string(expected), resp.Body.String())
+	})
 }
@@ -0,0 +1,54 @@
+package restore
+
+import (
+	"context"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/go-chi/render"
+	"github.com/google/uuid"
+	"log/slog"
+	"net/http"
+	"test_golang_user_api/internal/api"
+	"test_golang_user_api/internal/http_server/middleware/auth"
+	"test_golang_user_api/internal/storage"
+)
+
+type UserCRUD interface {
+	RestoreUser(ctx context.Context, id uuid.UUID) (*storage.UserDto, error)
+}
+
+func New(log *slog.Logger, crud UserCRUD) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		log.With(
+			slog.String("request_id", middleware.GetReqID(request.Context())),
+		)
+
+		if !auth.IdentityFrom(request.Context()).Admin {
+			log.Info("Restore requested by non-admin")
+			render.Status(request, http.StatusForbidden)
+			render.JSON(writer, request, api.Forbidden("Admin required"))
+			return
+		}
+
+		idStr := chi.URLParam(request, "id")
+
+		id, err := uuid.Parse(idStr)
+		if err != nil {
+			log.Error("Invalid UUID", slog.Any("err", err))
+			render.JSON(writer, request, api.ErrorStatus("Invalid UUID"))
+			return
+		}
+
+		user, err := crud.RestoreUser(request.Context(), id)
+		if err != nil {
+			log.Error("Error restoring user", slog.Any("err", err))
+			render.JSON(writer, request, api.ErrorStatus("Failed to restore user"))
+			return
+		}
+
+		writer.Header().Set("ETag", api.ETag(user.Version))
+		render.JSON(writer, request, user)
+
+		log.Info("User restored successfully")
+	}
+}
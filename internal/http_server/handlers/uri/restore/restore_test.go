@@ -0,0 +1,122 @@
+package restore
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"test_golang_user_api/internal/api"
+	"test_golang_user_api/internal/http_server/middleware/auth"
+	"test_golang_user_api/internal/storage"
+	"testing"
+)
+
+type mockUserCRUD struct {
+	restoreFunc func(id uuid.UUID) (*storage.UserDto, error)
+}
+
+func (m *mockUserCRUD) RestoreUser(_ context.Context, id uuid.UUID) (*storage.UserDto, error) {
+	return m.restoreFunc(id)
+}
+
+func TestRestoreUserHandler(t *testing.T) {
+	t.Run("successfully restores user", func(t *testing.T) {
+		//given
+		id := uuid.New()
+		r := chi.NewRouter()
+		user := &storage.UserDto{
+			ID:        id,
+			Firstname: "Ivan",
+			Lastname:  "Ivanov",
+			Email:     "ivan@gmail.com",
+			Age:       30,
+			Version:   3,
+		}
+		mockCrud := &mockUserCRUD{
+			restoreFunc: func(uid uuid.UUID) (*storage.UserDto, error) {
+				assert.Equal(t, id, uid)
+				return user, nil
+			},
+		}
+		handler := New(slog.Default(), mockCrud)
+		r.Post("/users/{id}/restore", handler)
+
+		req := httptest.NewRequest(http.MethodPost, "/users/"+id.String()+"/restore", nil)
+		req = req.WithContext(auth.WithIdentity(req.Context(), auth.Identity{Admin: true}))
+		resp := httptest.NewRecorder()
+		//when
+		r.ServeHTTP(resp, req)
+
+		//then
+		expected, _ := json.Marshal(user)
+		require.Equal(t, http.StatusOK, resp.Code)
+		assert.JSONEq(t, string(expected), resp.Body.String())
+		assert.Equal(t, `"3"`, resp.Header().Get("ETag"))
+	})
+
+	t.Run("forbids restore to non-admins", func(t *testing.T) {
+		//given
+		id := uuid.New()
+		r := chi.NewRouter()
+		handler := New(slog.Default(), &mockUserCRUD{})
+		r.Post("/users/{id}/restore", handler)
+
+		req := httptest.NewRequest(http.MethodPost, "/users/"+id.String()+"/restore", nil)
+		req = req.WithContext(auth.WithIdentity(req.Context(), auth.Identity{Claims: auth.Claims{"role": "support"}}))
+		resp := httptest.NewRecorder()
+		//when
+		r.ServeHTTP(resp, req)
+
+		//then
+		expected, _ := json.Marshal(api.Forbidden("Admin required"))
+		require.Equal(t, http.StatusForbidden, resp.Code)
+		assert.JSONEq(t, string(expected), resp.Body.String())
+	})
+
+	t.Run("returns error for invalid UUID", func(t *testing.T) {
+		//given
+		r := chi.NewRouter()
+		handler := New(slog.Default(), &mockUserCRUD{})
+		r.Post("/users/{id}/restore", handler)
+
+		req := httptest.NewRequest(http.MethodPost, "/users/not-a-uuid/restore", nil)
+		req = req.WithContext(auth.WithIdentity(req.Context(), auth.Identity{Admin: true}))
+		resp := httptest.NewRecorder()
+		//when
+		r.ServeHTTP(resp, req)
+
+		//then
+		expected, _ := json.Marshal(api.ErrorStatus("Invalid UUID"))
+		require.Equal(t, http.StatusOK, resp.Code)
+		assert.JSONEq(t, string(expected), resp.Body.String())
+	})
+
+	t.Run("returns error when user is not deleted", func(t *testing.T) {
+		//given
+		id := uuid.New()
+		r := chi.NewRouter()
+		mockCrud := &mockUserCRUD{
+			restoreFunc: func(uuid.UUID) (*storage.UserDto, error) {
+				return nil, storage.ErrUserNotFound
+			},
+		}
+		handler := New(slog.Default(), mockCrud)
+		r.Post("/users/{id}/restore", handler)
+
+		req := httptest.NewRequest(http.MethodPost, "/users/"+id.String()+"/restore", nil)
+		req = req.WithContext(auth.WithIdentity(req.Context(), auth.Identity{Admin: true}))
+		resp := httptest.NewRecorder()
+		//when
+		r.ServeHTTP(resp, req)
+
+		//then
+		expected, _ := json.Marshal(api.ErrorStatus("Failed to restore user"))
+		require.Equal(t, http.StatusOK, resp.Code)
+		assert.JSONEq(t, string(expected), resp.Body.String())
+	})
+}
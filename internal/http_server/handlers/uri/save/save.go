@@ -1,6 +1,7 @@
 package save
 
 import (
+	"context"
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/go-chi/render"
 	"github.com/go-playground/validator/v10"
@@ -8,11 +9,11 @@ import (
 	"log/slog"
 	"net/http"
 	"test_golang_user_api/internal/api"
-	"test_golang_user_api/internal/storage/postgres"
+	"test_golang_user_api/internal/storage"
 )
 
 type UserCRUD interface {
-	CreateUser(user *postgres.UserDto) error
+	CreateUser(ctx context.Context, user *storage.UserDto) error
 }
 
 func New(log *slog.Logger, userCrud UserCRUD) http.HandlerFunc {
@@ -31,13 +32,21 @@ func New(log *slog.Logger, userCrud UserCRUD) http.HandlerFunc {
 
 		log.Info("Request body decoded", slog.Any("requestBody", req))
 
+		email, err := api.NormalizeEmail(req.Email)
+		if err != nil {
+			log.Error("Error validating request body", slog.Any("err", err))
+			render.JSON(writer, request, api.ErrorStatus("Failed to validate request body"))
+			return
+		}
+		req.Email = email
+
 		if err := validator.New().Struct(req); err != nil {
 			log.Error("Error validating request body", slog.Any("err", err))
 			render.JSON(writer, request, api.ErrorStatus("Failed to validate request body"))
 			return
 		}
 
-		err = userCrud.CreateUser(postgres.NewUser(uuid.New(), req.Firstname, req.Lastname, req.Email, req.Age))
+		err = userCrud.CreateUser(request.Context(), storage.NewUser(uuid.New(), req.Firstname, req.Lastname, req.Email, req.Age))
 		if err != nil {
 			log.Error("Error creating user", slog.Any("err", err))
 			render.JSON(writer, request, api.ErrorStatus("Failed to create user"))
@@ -2,6 +2,7 @@ package save
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"github.com/stretchr/testify/assert"
@@ -10,15 +11,15 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"test_golang_user_api/internal/api"
-	"test_golang_user_api/internal/storage/postgres"
+	"test_golang_user_api/internal/storage"
 	"testing"
 )
 
 type mockUserCRUD struct {
-	createFunc func(user *postgres.UserDto) error
+	createFunc func(user *storage.UserDto) error
 }
 
-func (m *mockUserCRUD) CreateUser(user *postgres.UserDto) error {
+func (m *mockUserCRUD) CreateUser(_ context.Context, user *storage.UserDto) error {
 	return m.createFunc(user)
 }
 
@@ -33,7 +34,7 @@ func TestSaveUserHandler(t *testing.T) {
 			Age:       30,
 		}
 		mockCrud := &mockUserCRUD{
-			createFunc: func(u *postgres.UserDto) error {
+			createFunc: func(u *storage.UserDto) error {
 				assert.Equal(t, reqBody.Firstname, u.Firstname)
 				return nil
 			},
@@ -54,6 +55,32 @@ func TestSaveUserHandler(t *testing.T) {
 		assert.JSONEq(t, string(expected), resp.Body.String())
 	})
 
+	t.Run("normalizes email before saving", func(t *testing.T) {
+		//given
+		r := http.NewServeMux()
+		var saved string
+		mockCrud := &mockUserCRUD{
+			createFunc: func(u *storage.UserDto) error {
+				saved = u.Email
+				return nil
+			},
+		}
+
+		handler := New(slog.Default(), mockCrud)
+		r.Handle("/users", handler)
+
+		body, _ := json.Marshal(api.Request{Firstname: "Ivan", Lastname: "Ivanov", Email: " Ivan@Example.COM ", Age: 30})
+		req := httptest.NewRequest(http.MethodPost, "/users", bytes.NewReader(body))
+		resp := httptest.NewRecorder()
+		//when
+		r.ServeHTTP(resp, req)
+
+		//then
+		expected, _ := json.Marshal(api.OkResponse())
+		assert.JSONEq(t, string(expected), resp.Body.String())
+		assert.Equal(t, "Ivan@example.com", saved)
+	})
+
 	t.Run("returns error for invalid JSON body", func(t *testing.T) {
 		//given
 		r := http.NewServeMux()
@@ -90,6 +117,24 @@ func TestSaveUserHandler(t *testing.T) {
 		assert.JSONEq(t, string(expected), resp.Body.String())
 	})
 
+	t.Run("returns error for email that cannot be normalized", func(t *testing.T) {
+		//given
+		r := http.NewServeMux()
+		handler := New(slog.Default(), &mockUserCRUD{})
+		r.Handle("/users", handler)
+
+		body, _ := json.Marshal(api.Request{Firstname: "Ivan", Lastname: "Ivanov", Email: "ivan@xn--zz.com", Age: 30})
+		req := httptest.NewRequest(http.MethodPost, "/users", bytes.NewReader(body))
+		resp := httptest.NewRecorder()
+		//when
+		r.ServeHTTP(resp, req)
+
+		//then
+		expected, _ := json.Marshal(api.ErrorStatus("Failed to validate request body"))
+		require.Equal(t, http.StatusOK, resp.Code)
+		assert.JSONEq(t, string(expected), resp.Body.String())
+	})
+
 	t.Run("returns error when CreateUser fails", func(t *testing.T) {
 		//given
 		r := http.NewServeMux()
@@ -100,7 +145,7 @@ func TestSaveUserHandler(t *testing.T) {
 			Age:       30,
 		}
 		mockCrud := &mockUserCRUD{
-			createFunc: func(u *postgres.UserDto) error {
+			createFunc: func(u *storage.UserDto) error {
 				return errors.New("db error")
 			},
 		}
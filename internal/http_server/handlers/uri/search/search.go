@@ -0,0 +1,86 @@
+package search
+
+import (
+	"context"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/go-chi/render"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"test_golang_user_api/internal/api"
+	"test_golang_user_api/internal/storage"
+	"unicode/utf8"
+)
+
+const (
+	defaultLimit   = 20
+	maxLimit       = 100
+	maxQueryLength = 100
+)
+
+type UserCRUD interface {
+	SearchUsers(ctx context.Context, query string, limit int) ([]storage.SearchResult, error)
+}
+
+// Result is a matching user with its relevance and the matching fields,
+// HTML-escaped with the matching words in <em> tags.
+type Result struct {
+	User       storage.UserDto          `json:"user"`
+	Score      float64                  `json:"score"`
+	Highlights map[storage.Field]string `json:"highlights"`
+}
+
+// Response lists the users matching q, best matches first.
+type Response struct {
+	Results []Result `json:"results"`
+	Limit   int      `json:"limit"`
+}
+
+func New(log *slog.Logger, crud UserCRUD) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		log.With(
+			slog.String("request_id", middleware.GetReqID(request.Context())),
+		)
+
+		params := request.URL.Query()
+
+		q := strings.TrimSpace(params.Get("q"))
+		if q == "" {
+			log.Error("Query is empty")
+			render.JSON(writer, request, api.ErrorStatus("Query required"))
+			return
+		}
+		if utf8.RuneCountInString(q) > maxQueryLength {
+			log.Error("Query too long", slog.Int("length", utf8.RuneCountInString(q)))
+			render.JSON(writer, request, api.ErrorStatus("Query too long"))
+			return
+		}
+
+		limit := defaultLimit
+		if raw := params.Get("limit"); raw != "" {
+			var err error
+			limit, err = strconv.Atoi(raw)
+			if err != nil || limit < 1 || limit > maxLimit {
+				log.Error("Invalid limit", slog.Any("err", err))
+				render.JSON(writer, request, api.ErrorStatus("Invalid limit"))
+				return
+			}
+		}
+
+		found, err := crud.SearchUsers(request.Context(), q, limit)
+		if err != nil {
+			log.Error("Error searching users", slog.Any("err", err))
+			render.JSON(writer, request, api.ErrorStatus("Failed to search users"))
+			return
+		}
+
+		response := Response{Results: make([]Result, len(found)), Limit: limit}
+		for i, result := range found {
+			response.Results[i] = Result{User: result.User, Score: result.Score, Highlights: result.Highlights}
+		}
+		render.JSON(writer, request, response)
+
+		log.Info("Users successfully searched", slog.Int("results", len(found)))
+	}
+}
@@ -0,0 +1,107 @@
+package search
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"test_golang_user_api/internal/api"
+	"test_golang_user_api/internal/storage"
+	"testing"
+)
+
+type mockUserCRUD struct {
+	searchFunc func(query string, limit int) ([]storage.SearchResult, error)
+}
+
+func (m *mockUserCRUD) SearchUsers(_ context.Context, query string, limit int) ([]storage.SearchResult, error) {
+	return m.searchFunc(query, limit)
+}
+
+func serve(crud UserCRUD, target string) *httptest.ResponseRecorder {
+	r := chi.NewRouter()
+	r.Get("/users/search", New(slog.Default(), crud))
+
+	resp := httptest.NewRecorder()
+	r.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, target, nil))
+	return resp
+}
+
+func TestSearchHandler(t *testing.T) {
+	t.Run("successfully searches users", func(t *testing.T) {
+		//given
+		user := storage.NewUser(uuid.New(), "Ivan", "Ivanov", "ivan@gmail.com", 30)
+		highlights := map[storage.Field]string{storage.FieldLastname: "<em>Ivanov</em>"}
+		mockCrud := &mockUserCRUD{
+			searchFunc: func(query string, limit int) ([]storage.SearchResult, error) {
+				assert.Equal(t, "Ivan Ivnov", query)
+				assert.Equal(t, 5, limit)
+				return []storage.SearchResult{{User: *user, Score: 0.75, Highlights: highlights}}, nil
+			},
+		}
+		//when
+		resp := serve(mockCrud, "/users/search?q="+url.QueryEscape(" Ivan Ivnov ")+"&limit=5")
+		//then
+		expected, _ := json.Marshal(Response{Results: []Result{{User: *user, Score: 0.75, Highlights: highlights}}, Limit: 5})
+		require.Equal(t, http.StatusOK, resp.Code)
+		assert.JSONEq(t, string(expected), resp.Body.String())
+	})
+
+	t.Run("uses default limit and returns empty results", func(t *testing.T) {
+		//given
+		mockCrud := &mockUserCRUD{
+			searchFunc: func(query string, limit int) ([]storage.SearchResult, error) {
+				assert.Equal(t, defaultLimit, limit)
+				return nil, nil
+			},
+		}
+		//when
+		resp := serve(mockCrud, "/users/search?q=ivan")
+		//then
+		require.Equal(t, http.StatusOK, resp.Code)
+		assert.JSONEq(t, `{"results":[],"limit":20}`, resp.Body.String())
+	})
+
+	for _, tc := range []struct {
+		query string
+		error string
+	}{
+		{"", "Query required"},
+		{"q=%20%20", "Query required"},
+		{"q=" + strings.Repeat("a", maxQueryLength+1), "Query too long"},
+		{"q=ivan&limit=0", "Invalid limit"},
+		{"q=ivan&limit=many", "Invalid limit"},
+	} {
+		t.Run("returns validation error for "+tc.query, func(t *testing.T) {
+			//when
+			resp := serve(&mockUserCRUD{}, "/users/search?"+tc.query)
+			//then
+			expected, _ := json.Marshal(api.ErrorStatus(tc.error))
+			require.Equal(t, http.StatusOK, resp.Code)
+			assert.JSONEq(t, string(expected), resp.Body.String())
+		})
+	}
+
+	t.Run("returns error when search fails", func(t *testing.T) {
+		//given
+		mockCrud := &mockUserCRUD{
+			searchFunc: func(string, int) ([]storage.SearchResult, error) {
+				return nil, errors.New("db error")
+			},
+		}
+		//when
+		resp := serve(mockCrud, "/users/search?q=ivan")
+		//then
+		expected, _ := json.Marshal(api.ErrorStatus("Failed to search users"))
+		require.Equal(t, http.StatusOK, resp.Code)
+		assert.JSONEq(t, string(expected), resp.Body.String())
+	})
+}
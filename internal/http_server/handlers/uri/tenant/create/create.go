@@ -0,0 +1,56 @@
+package create
+
+import (
+	"context"
+	"errors"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/go-chi/render"
+	"github.com/go-playground/validator/v10"
+	"log/slog"
+	"net/http"
+	"test_golang_user_api/internal/api"
+	"test_golang_user_api/internal/storage"
+)
+
+type TenantCRUD interface {
+	CreateTenant(ctx context.Context, tenant *storage.Tenant) error
+}
+
+func New(log *slog.Logger, crud TenantCRUD) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		log.With(
+			slog.String("request_id", middleware.GetReqID(request.Context())),
+		)
+		var req api.TenantRequest
+
+		err := render.DecodeJSON(request.Body, &req)
+		if err != nil {
+			log.Error("Error decoding request body", slog.Any("err", err))
+			render.JSON(writer, request, api.ErrorStatus("Failed to decode request body"))
+			return
+		}
+
+		if err := validator.New().Struct(req); err != nil || !storage.ValidTenantID(req.ID) {
+			log.Error("Error validating request body", slog.Any("err", err))
+			render.JSON(writer, request, api.ErrorStatus("Failed to validate request body"))
+			return
+		}
+
+		tenant := &storage.Tenant{ID: req.ID, Name: req.Name}
+		err = crud.CreateTenant(request.Context(), tenant)
+		if errors.Is(err, storage.ErrTenantExists) {
+			log.Error("Tenant already exists", slog.Any("err", err))
+			render.JSON(writer, request, api.ErrorStatus("Tenant already exists"))
+			return
+		}
+		if err != nil {
+			log.Error("Error creating tenant", slog.Any("err", err))
+			render.JSON(writer, request, api.ErrorStatus("Failed to create tenant"))
+			return
+		}
+
+		render.JSON(writer, request, tenant)
+
+		log.Info("Tenant created successfully", slog.String("tenant", tenant.ID))
+	}
+}
@@ -0,0 +1,90 @@
+package create
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"test_golang_user_api/internal/api"
+	"test_golang_user_api/internal/storage"
+	"testing"
+)
+
+type mockTenantCRUD struct {
+	createFunc func(tenant *storage.Tenant) error
+}
+
+func (m *mockTenantCRUD) CreateTenant(_ context.Context, tenant *storage.Tenant) error {
+	return m.createFunc(tenant)
+}
+
+func TestCreateTenantHandler(t *testing.T) {
+	t.Run("successfully creates tenant", func(t *testing.T) {
+		//given
+		mockCrud := &mockTenantCRUD{
+			createFunc: func(tenant *storage.Tenant) error {
+				assert.Equal(t, "acme", tenant.ID)
+				assert.Equal(t, "Acme", tenant.Name)
+				return nil
+			},
+		}
+		handler := New(slog.Default(), mockCrud)
+
+		body, _ := json.Marshal(api.TenantRequest{ID: "acme", Name: "Acme"})
+		req := httptest.NewRequest(http.MethodPost, "/tenants", bytes.NewReader(body))
+		resp := httptest.NewRecorder()
+		//when
+		handler.ServeHTTP(resp, req)
+		//then
+		require.Equal(t, http.StatusOK, resp.Code)
+		var tenant storage.Tenant
+		require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &tenant))
+		assert.Equal(t, "acme", tenant.ID)
+	})
+
+	t.Run("rejects tenant id that is not a DNS label", func(t *testing.T) {
+		//given
+		mockCrud := &mockTenantCRUD{
+			createFunc: func(tenant *storage.Tenant) error {
+				t.Fatal("CreateTenant should not be called")
+				return nil
+			},
+		}
+		handler := New(slog.Default(), mockCrud)
+
+		body, _ := json.Marshal(api.TenantRequest{ID: "Acme Corp", Name: "Acme"})
+		req := httptest.NewRequest(http.MethodPost, "/tenants", bytes.NewReader(body))
+		resp := httptest.NewRecorder()
+		//when
+		handler.ServeHTTP(resp, req)
+		//then
+		var response api.Response
+		require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &response))
+		assert.Equal(t, "Failed to validate request body", response.Error)
+	})
+
+	t.Run("returns error for existing tenant", func(t *testing.T) {
+		//given
+		mockCrud := &mockTenantCRUD{
+			createFunc: func(tenant *storage.Tenant) error {
+				return storage.ErrTenantExists
+			},
+		}
+		handler := New(slog.Default(), mockCrud)
+
+		body, _ := json.Marshal(api.TenantRequest{ID: "acme", Name: "Acme"})
+		req := httptest.NewRequest(http.MethodPost, "/tenants", bytes.NewReader(body))
+		resp := httptest.NewRecorder()
+		//when
+		handler.ServeHTTP(resp, req)
+		//then
+		var response api.Response
+		require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &response))
+		assert.Equal(t, http.StatusBadRequest, response.Status)
+		assert.Equal(t, "Tenant already exists", response.Error)
+	})
+}
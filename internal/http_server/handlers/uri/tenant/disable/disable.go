@@ -0,0 +1,43 @@
+package disable
+
+import (
+	"context"
+	"errors"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/go-chi/render"
+	"log/slog"
+	"net/http"
+	"test_golang_user_api/internal/api"
+	"test_golang_user_api/internal/storage"
+)
+
+type TenantCRUD interface {
+	DisableTenant(ctx context.Context, id string) (*storage.Tenant, error)
+}
+
+func New(log *slog.Logger, crud TenantCRUD) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		log.With(
+			slog.String("request_id", middleware.GetReqID(request.Context())),
+		)
+
+		id := chi.URLParam(request, "id")
+
+		tenant, err := crud.DisableTenant(request.Context(), id)
+		if errors.Is(err, storage.ErrTenantNotFound) {
+			log.Error("Tenant not found", slog.Any("err", err))
+			render.JSON(writer, request, api.ErrorStatus("Tenant not found"))
+			return
+		}
+		if err != nil {
+			log.Error("Error disabling tenant", slog.Any("err", err))
+			render.JSON(writer, request, api.ErrorStatus("Failed to disable tenant"))
+			return
+		}
+
+		render.JSON(writer, request, tenant)
+
+		log.Info("Tenant disabled successfully", slog.String("tenant", tenant.ID))
+	}
+}
@@ -0,0 +1,69 @@
+package disable
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"test_golang_user_api/internal/api"
+	"test_golang_user_api/internal/storage"
+	"testing"
+	"time"
+)
+
+type mockTenantCRUD struct {
+	disableFunc func(id string) (*storage.Tenant, error)
+}
+
+func (m *mockTenantCRUD) DisableTenant(_ context.Context, id string) (*storage.Tenant, error) {
+	return m.disableFunc(id)
+}
+
+func TestDisableTenantHandler(t *testing.T) {
+	t.Run("successfully disables tenant", func(t *testing.T) {
+		//given
+		r := chi.NewRouter()
+		disabled := time.Now().UTC()
+		mockCrud := &mockTenantCRUD{
+			disableFunc: func(id string) (*storage.Tenant, error) {
+				assert.Equal(t, "acme", id)
+				return &storage.Tenant{ID: id, Name: "Acme", Disabled: &disabled}, nil
+			},
+		}
+		r.Post("/tenants/{id}/disable", New(slog.Default(), mockCrud))
+
+		req := httptest.NewRequest(http.MethodPost, "/tenants/acme/disable", nil)
+		resp := httptest.NewRecorder()
+		//when
+		r.ServeHTTP(resp, req)
+		//then
+		require.Equal(t, http.StatusOK, resp.Code)
+		var tenant storage.Tenant
+		require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &tenant))
+		require.NotNil(t, tenant.Disabled)
+	})
+
+	t.Run("returns error for unknown tenant", func(t *testing.T) {
+		//given
+		r := chi.NewRouter()
+		mockCrud := &mockTenantCRUD{
+			disableFunc: func(id string) (*storage.Tenant, error) {
+				return nil, storage.ErrTenantNotFound
+			},
+		}
+		r.Post("/tenants/{id}/disable", New(slog.Default(), mockCrud))
+
+		req := httptest.NewRequest(http.MethodPost, "/tenants/acme/disable", nil)
+		resp := httptest.NewRecorder()
+		//when
+		r.ServeHTTP(resp, req)
+		//then
+		var response api.Response
+		require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &response))
+		assert.Equal(t, "Tenant not found", response.Error)
+	})
+}
@@ -0,0 +1,37 @@
+package list
+
+import (
+	"context"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/go-chi/render"
+	"log/slog"
+	"net/http"
+	"test_golang_user_api/internal/api"
+	"test_golang_user_api/internal/storage"
+)
+
+type TenantCRUD interface {
+	ListTenants(ctx context.Context) ([]storage.Tenant, error)
+}
+
+func New(log *slog.Logger, crud TenantCRUD) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		log.With(
+			slog.String("request_id", middleware.GetReqID(request.Context())),
+		)
+
+		tenants, err := crud.ListTenants(request.Context())
+		if err != nil {
+			log.Error("Error listing tenants", slog.Any("err", err))
+			render.JSON(writer, request, api.ErrorStatus("Failed to list tenants"))
+			return
+		}
+
+		if tenants == nil {
+			tenants = []storage.Tenant{}
+		}
+		render.JSON(writer, request, tenants)
+
+		log.Info("Tenants successfully listed")
+	}
+}
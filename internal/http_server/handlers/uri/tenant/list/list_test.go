@@ -0,0 +1,65 @@
+package list
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"test_golang_user_api/internal/api"
+	"test_golang_user_api/internal/storage"
+	"testing"
+)
+
+type mockTenantCRUD struct {
+	listFunc func() ([]storage.Tenant, error)
+}
+
+func (m *mockTenantCRUD) ListTenants(_ context.Context) ([]storage.Tenant, error) {
+	return m.listFunc()
+}
+
+func TestListTenantsHandler(t *testing.T) {
+	t.Run("successfully lists tenants", func(t *testing.T) {
+		//given
+		mockCrud := &mockTenantCRUD{
+			listFunc: func() ([]storage.Tenant, error) {
+				return []storage.Tenant{{ID: "acme", Name: "Acme"}, {ID: storage.DefaultTenant, Name: "Default"}}, nil
+			},
+		}
+		handler := New(slog.Default(), mockCrud)
+
+		req := httptest.NewRequest(http.MethodGet, "/tenants", nil)
+		resp := httptest.NewRecorder()
+		//when
+		handler.ServeHTTP(resp, req)
+		//then
+		require.Equal(t, http.StatusOK, resp.Code)
+		var tenants []storage.Tenant
+		require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &tenants))
+		require.Len(t, tenants, 2)
+		assert.Equal(t, "acme", tenants[0].ID)
+	})
+
+	t.Run("returns error when listing fails", func(t *testing.T) {
+		//given
+		mockCrud := &mockTenantCRUD{
+			listFunc: func() ([]storage.Tenant, error) {
+				return nil, errors.New("db down")
+			},
+		}
+		handler := New(slog.Default(), mockCrud)
+
+		req := httptest.NewRequest(http.MethodGet, "/tenants", nil)
+		resp := httptest.NewRecorder()
+		//when
+		handler.ServeHTTP(resp, req)
+		//then
+		var response api.Response
+		require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &response))
+		assert.Equal(t, "Failed to list tenants", response.Error)
+	})
+}
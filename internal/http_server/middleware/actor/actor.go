@@ -0,0 +1,26 @@
+package actor
+
+import (
+	"net/http"
+	"strings"
+	"test_golang_user_api/internal/storage"
+)
+
+const (
+	Header    = "X-Actor"
+	Anonymous = "anonymous"
+)
+
+// New records the caller named by the X-Actor header in the request context
+// so storage can attribute changes in the audit log. The header is trusted
+// as is; it is expected to be set by the gateway in front of the service.
+func New(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		actor := strings.TrimSpace(request.Header.Get(Header))
+		if actor == "" {
+			actor = Anonymous
+		}
+
+		next.ServeHTTP(writer, request.WithContext(storage.WithActor(request.Context(), actor)))
+	})
+}
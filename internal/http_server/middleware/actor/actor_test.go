@@ -0,0 +1,38 @@
+package actor
+
+import (
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"test_golang_user_api/internal/storage"
+	"testing"
+)
+
+func TestActorMiddleware(t *testing.T) {
+	t.Run("stores actor from header in context", func(t *testing.T) {
+		//given
+		var actor string
+		handler := New(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+			actor = storage.ActorFrom(request.Context())
+		}))
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set(Header, " admin@example.com ")
+		//when
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+		//then
+		assert.Equal(t, "admin@example.com", actor)
+	})
+
+	t.Run("falls back to anonymous", func(t *testing.T) {
+		//given
+		var actor string
+		handler := New(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+			actor = storage.ActorFrom(request.Context())
+		}))
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		//when
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+		//then
+		assert.Equal(t, Anonymous, actor)
+	})
+}
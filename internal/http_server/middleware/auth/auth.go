@@ -0,0 +1,154 @@
+package auth
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/go-chi/render"
+	"log/slog"
+	"net/http"
+	"strings"
+	"test_golang_user_api/internal/api"
+	"test_golang_user_api/internal/config"
+	"time"
+)
+
+var (
+	ErrInvalidToken = errors.New("invalid token")
+	ErrTokenExpired = errors.New("token expired")
+	ErrNoSecret     = errors.New("no secret to verify tokens with")
+)
+
+// Claims are the claims of a verified token.
+type Claims map[string]any
+
+// Identity is the verified caller of a request. Anonymous callers have no
+// claims and are never admins.
+type Identity struct {
+	Claims Claims
+	Admin  bool
+}
+
+type identityKey struct{}
+
+func WithIdentity(ctx context.Context, identity Identity) context.Context {
+	return context.WithValue(ctx, identityKey{}, identity)
+}
+
+func IdentityFrom(ctx context.Context) Identity {
+	identity, _ := ctx.Value(identityKey{}).(Identity)
+	return identity
+}
+
+// New verifies the bearer token of each request and records the caller in
+// the request context. Requests without a token pass as anonymous; requests
+// with a token that does not verify are rejected, so that a bad token never
+// falls back to the tenant or rights of an anonymous caller.
+func New(log *slog.Logger, cfg config.Auth) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+			log := log.With(
+				slog.String("request_id", middleware.GetReqID(request.Context())),
+			)
+
+			token, ok := strings.CutPrefix(request.Header.Get("Authorization"), "Bearer ")
+			if !ok {
+				next.ServeHTTP(writer, request)
+				return
+			}
+
+			claims, err := Verify(strings.TrimSpace(token), []byte(cfg.Secret), time.Now())
+			if err != nil {
+				log.Info("Rejected token", slog.Any("err", err))
+				render.Status(request, http.StatusUnauthorized)
+				render.JSON(writer, request, api.Unauthorized("Invalid token"))
+				return
+			}
+
+			identity := Identity{Claims: claims, Admin: hasRole(claims[cfg.RoleClaim], cfg.AdminRole)}
+			next.ServeHTTP(writer, request.WithContext(WithIdentity(request.Context(), identity)))
+		})
+	}
+}
+
+// hasRole accepts a role claim holding a single role or a list of them.
+func hasRole(claim any, role string) bool {
+	switch claim := claim.(type) {
+	case string:
+		return claim == role
+	case []any:
+		for _, candidate := range claim {
+			if candidate == role {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+type header struct {
+	Alg string `json:"alg"`
+}
+
+// Verify checks that token is a JWT signed with HS256 and secret and, when
+// it has exp or nbf claims, valid at now, and returns its claims.
+func Verify(token string, secret []byte, now time.Time) (Claims, error) {
+	if len(secret) == 0 {
+		return nil, ErrNoSecret
+	}
+
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrInvalidToken
+	}
+
+	var h header
+	if err := decodePart(parts[0], &h); err != nil || h.Alg != "HS256" {
+		return nil, ErrInvalidToken
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(parts[0] + "." + parts[1]))
+	if !hmac.Equal(signature, mac.Sum(nil)) {
+		return nil, ErrInvalidToken
+	}
+
+	var claims Claims
+	if err := decodePart(parts[1], &claims); err != nil || claims == nil {
+		return nil, ErrInvalidToken
+	}
+
+	if exp, ok := claims["exp"]; ok {
+		seconds, ok := exp.(float64)
+		if !ok {
+			return nil, ErrInvalidToken
+		}
+		if !now.Before(time.Unix(int64(seconds), 0)) {
+			return nil, ErrTokenExpired
+		}
+	}
+	if nbf, ok := claims["nbf"]; ok {
+		seconds, ok := nbf.(float64)
+		if !ok || now.Before(time.Unix(int64(seconds), 0)) {
+			return nil, ErrInvalidToken
+		}
+	}
+
+	return claims, nil
+}
+
+func decodePart(part string, v any) error {
+	raw, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(raw, v)
+}
@@ -0,0 +1,112 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"test_golang_user_api/internal/api"
+	"test_golang_user_api/internal/config"
+	"testing"
+	"time"
+)
+
+var cfg = config.Auth{Secret: "secret", RoleClaim: "role", AdminRole: "admin"}
+
+func sign(t *testing.T, alg, secret string, claims map[string]any) string {
+	t.Helper()
+	header, err := json.Marshal(map[string]string{"alg": alg, "typ": "JWT"})
+	require.NoError(t, err)
+	payload, err := json.Marshal(claims)
+	require.NoError(t, err)
+
+	unsigned := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(unsigned))
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func serve(cfg config.Auth, authorization string) (*Identity, *httptest.ResponseRecorder) {
+	var identity *Identity
+	handler := New(slog.Default(), cfg)(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		found := IdentityFrom(request.Context())
+		identity = &found
+	}))
+	req := httptest.NewRequest(http.MethodGet, "/users", nil)
+	if authorization != "" {
+		req.Header.Set("Authorization", authorization)
+	}
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return identity, rec
+}
+
+func TestAuthMiddleware(t *testing.T) {
+	t.Run("passes anonymous requests", func(t *testing.T) {
+		//when
+		identity, rec := serve(cfg, "")
+		//then
+		require.Equal(t, http.StatusOK, rec.Code)
+		require.NotNil(t, identity)
+		assert.Nil(t, identity.Claims)
+		assert.False(t, identity.Admin)
+	})
+
+	t.Run("records claims of verified token", func(t *testing.T) {
+		//given
+		token := sign(t, "HS256", "secret", map[string]any{"tenant_id": "acme", "exp": time.Now().Add(time.Hour).Unix()})
+		//when
+		identity, rec := serve(cfg, "Bearer "+token)
+		//then
+		require.Equal(t, http.StatusOK, rec.Code)
+		require.NotNil(t, identity)
+		assert.Equal(t, "acme", identity.Claims["tenant_id"])
+		assert.False(t, identity.Admin)
+	})
+
+	t.Run("recognizes admin role", func(t *testing.T) {
+		for _, role := range []any{"admin", []string{"support", "admin"}} {
+			//given
+			token := sign(t, "HS256", "secret", map[string]any{"role": role})
+			//when
+			identity, _ := serve(cfg, "Bearer "+token)
+			//then
+			require.NotNil(t, identity)
+			assert.True(t, identity.Admin, role)
+		}
+	})
+
+	for _, tc := range []struct {
+		name  string
+		cfg   config.Auth
+		token func(t *testing.T) string
+	}{
+		{"wrong secret", cfg, func(t *testing.T) string { return sign(t, "HS256", "other", map[string]any{"role": "admin"}) }},
+		{"unsigned token", cfg, func(t *testing.T) string { return sign(t, "none", "secret", map[string]any{"role": "admin"}) }},
+		{"expired token", cfg, func(t *testing.T) string {
+			return sign(t, "HS256", "secret", map[string]any{"exp": time.Now().Add(-time.Minute).Unix()})
+		}},
+		{"token not yet valid", cfg, func(t *testing.T) string {
+			return sign(t, "HS256", "secret", map[string]any{"nbf": time.Now().Add(time.Hour).Unix()})
+		}},
+		{"malformed token", cfg, func(*testing.T) string { return "garbage" }},
+		{"missing secret", config.Auth{RoleClaim: "role", AdminRole: "admin"}, func(t *testing.T) string {
+			return sign(t, "HS256", "", map[string]any{"role": "admin"})
+		}},
+	} {
+		t.Run("rejects "+tc.name, func(t *testing.T) {
+			//when
+			identity, rec := serve(tc.cfg, "Bearer "+tc.token(t))
+			//then
+			assert.Nil(t, identity)
+			require.Equal(t, http.StatusUnauthorized, rec.Code)
+			expected, _ := json.Marshal(api.Unauthorized("Invalid token"))
+			assert.JSONEq(t, string(expected), rec.Body.String())
+		})
+	}
+}
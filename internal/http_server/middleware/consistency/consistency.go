@@ -0,0 +1,65 @@
+package consistency
+
+import (
+	"net/http"
+	"strconv"
+	"test_golang_user_api/internal/storage"
+	"time"
+)
+
+const (
+	Header = "X-Read-Primary"
+	Cookie = "read_primary_until"
+)
+
+// New sends reads to the primary for window after a mutation by the same
+// client, so clients read their own writes despite replica lag. The client
+// is tracked with a cookie holding the end of the window; a request can also
+// ask for the primary explicitly with "X-Read-Primary: true".
+func New(window time.Duration) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+			now := time.Now()
+
+			if readPrimary(request, now) {
+				request = request.WithContext(storage.WithPrimary(request.Context()))
+			}
+
+			if window > 0 && mutates(request.Method) {
+				until := now.Add(window)
+				http.SetCookie(writer, &http.Cookie{
+					Name:     Cookie,
+					Value:    strconv.FormatInt(until.UnixMilli(), 10),
+					Path:     "/",
+					Expires:  until,
+					HttpOnly: true,
+				})
+			}
+
+			next.ServeHTTP(writer, request)
+		})
+	}
+}
+
+func readPrimary(request *http.Request, now time.Time) bool {
+	if explicit, err := strconv.ParseBool(request.Header.Get(Header)); err == nil {
+		return explicit
+	}
+
+	cookie, err := request.Cookie(Cookie)
+	if err != nil {
+		return false
+	}
+
+	until, err := strconv.ParseInt(cookie.Value, 10, 64)
+	return err == nil && now.UnixMilli() < until
+}
+
+func mutates(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return false
+	default:
+		return true
+	}
+}
@@ -0,0 +1,75 @@
+package consistency
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"test_golang_user_api/internal/storage"
+	"testing"
+	"time"
+)
+
+func serve(req *http.Request) (bool, *httptest.ResponseRecorder) {
+	var primary bool
+	handler := New(time.Minute)(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		primary = storage.PrimaryFrom(request.Context())
+	}))
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return primary, rec
+}
+
+func TestConsistencyMiddleware(t *testing.T) {
+	t.Run("reads from replicas by default", func(t *testing.T) {
+		//given
+		req := httptest.NewRequest(http.MethodGet, "/user/1", nil)
+		//when
+		primary, rec := serve(req)
+		//then
+		assert.False(t, primary)
+		assert.Empty(t, rec.Result().Cookies())
+	})
+
+	t.Run("mutation sets read your writes cookie", func(t *testing.T) {
+		//given
+		req := httptest.NewRequest(http.MethodPatch, "/user/1", nil)
+		//when
+		_, rec := serve(req)
+		//then
+		cookies := rec.Result().Cookies()
+		require.Len(t, cookies, 1)
+		assert.Equal(t, Cookie, cookies[0].Name)
+	})
+
+	t.Run("reads from primary within window", func(t *testing.T) {
+		//given
+		req := httptest.NewRequest(http.MethodGet, "/user/1", nil)
+		req.AddCookie(&http.Cookie{Name: Cookie, Value: strconv.FormatInt(time.Now().Add(time.Second).UnixMilli(), 10)})
+		//when
+		primary, _ := serve(req)
+		//then
+		assert.True(t, primary)
+	})
+
+	t.Run("reads from replicas after window", func(t *testing.T) {
+		//given
+		req := httptest.NewRequest(http.MethodGet, "/user/1", nil)
+		req.AddCookie(&http.Cookie{Name: Cookie, Value: strconv.FormatInt(time.Now().Add(-time.Second).UnixMilli(), 10)})
+		//when
+		primary, _ := serve(req)
+		//then
+		assert.False(t, primary)
+	})
+
+	t.Run("header forces primary", func(t *testing.T) {
+		//given
+		req := httptest.NewRequest(http.MethodGet, "/user/1", nil)
+		req.Header.Set(Header, "true")
+		//when
+		primary, _ := serve(req)
+		//then
+		assert.True(t, primary)
+	})
+}
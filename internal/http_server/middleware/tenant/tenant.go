@@ -0,0 +1,100 @@
+package tenant
+
+import (
+	"context"
+	"errors"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/go-chi/render"
+	"log/slog"
+	"net"
+	"net/http"
+	"strings"
+	"test_golang_user_api/internal/api"
+	"test_golang_user_api/internal/config"
+	"test_golang_user_api/internal/http_server/middleware/auth"
+	"test_golang_user_api/internal/storage"
+)
+
+type TenantGetter interface {
+	GetTenant(ctx context.Context, id string) (*storage.Tenant, error)
+}
+
+// New resolves the tenant of each request and records it in the request
+// context, so storage scopes every query to it. The tenant is taken from the
+// configured claim of the token verified by the auth middleware, then the
+// configured header if it is trusted, then the subdomain of the base domain
+// if the Host is trusted, and falls back to the configured default.
+func New(log *slog.Logger, getter TenantGetter, cfg config.Tenancy) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+			log := log.With(
+				slog.String("request_id", middleware.GetReqID(request.Context())),
+			)
+
+			id := resolve(request, cfg)
+			if id == "" {
+				render.JSON(writer, request, api.ErrorStatus("Tenant required"))
+				return
+			}
+			if !storage.ValidTenantID(id) {
+				render.JSON(writer, request, api.ErrorStatus("Invalid tenant"))
+				return
+			}
+
+			tenant, err := getter.GetTenant(request.Context(), id)
+			if errors.Is(err, storage.ErrTenantNotFound) {
+				log.Info("Unknown tenant", slog.String("tenant", id))
+				render.JSON(writer, request, api.ErrorStatus("Unknown tenant"))
+				return
+			}
+			if err != nil {
+				log.Error("Failed to get tenant", slog.Any("err", err))
+				render.JSON(writer, request, api.ErrorStatus("Failed to get tenant"))
+				return
+			}
+
+			if tenant.Disabled != nil {
+				log.Info("Tenant disabled", slog.String("tenant", id))
+				render.Status(request, http.StatusForbidden)
+				render.JSON(writer, request, api.Forbidden("Tenant disabled"))
+				return
+			}
+
+			next.ServeHTTP(writer, request.WithContext(storage.WithTenant(request.Context(), tenant.ID)))
+		})
+	}
+}
+
+func resolve(request *http.Request, cfg config.Tenancy) string {
+	if id, _ := auth.IdentityFrom(request.Context()).Claims[cfg.Claim].(string); cfg.Claim != "" && id != "" {
+		return id
+	}
+	if cfg.TrustHeader && cfg.Header != "" {
+		if id := strings.TrimSpace(request.Header.Get(cfg.Header)); id != "" {
+			return id
+		}
+	}
+	if cfg.TrustHost {
+		if id := fromSubdomain(request, cfg.BaseDomain); id != "" {
+			return id
+		}
+	}
+	return cfg.Default
+}
+
+func fromSubdomain(request *http.Request, baseDomain string) string {
+	if baseDomain == "" {
+		return ""
+	}
+
+	host := request.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+
+	label, ok := strings.CutSuffix(strings.ToLower(host), "."+strings.ToLower(baseDomain))
+	if !ok || strings.Contains(label, ".") {
+		return ""
+	}
+	return label
+}
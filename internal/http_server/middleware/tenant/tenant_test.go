@@ -0,0 +1,199 @@
+package tenant
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"test_golang_user_api/internal/api"
+	"test_golang_user_api/internal/config"
+	"test_golang_user_api/internal/http_server/middleware/auth"
+	"test_golang_user_api/internal/storage"
+	"testing"
+	"time"
+)
+
+type mockTenantGetter struct {
+	tenants map[string]storage.Tenant
+	err     error
+}
+
+func (m *mockTenantGetter) GetTenant(_ context.Context, id string) (*storage.Tenant, error) {
+	if m.err != nil {
+		return nil, m.err
+	}
+	tenant, ok := m.tenants[id]
+	if !ok {
+		return nil, storage.ErrTenantNotFound
+	}
+	return &tenant, nil
+}
+
+var cfg = config.Tenancy{Header: "X-Tenant-ID", TrustHeader: true, Claim: "tenant_id", TrustHost: true, BaseDomain: "users.example.com", Default: storage.DefaultTenant}
+
+func serve(getter TenantGetter, cfg config.Tenancy, req *http.Request) (string, *httptest.ResponseRecorder) {
+	var tenant string
+	handler := New(slog.Default(), getter, cfg)(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		tenant = storage.TenantFrom(request.Context())
+	}))
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return tenant, rec
+}
+
+// unverified is a token whose signature nobody checked.
+func unverified(claims map[string]any) string {
+	payload, _ := json.Marshal(claims)
+	return "Bearer e30." + base64.RawURLEncoding.EncodeToString(payload) + ".signature"
+}
+
+func TestTenantMiddleware(t *testing.T) {
+	getter := &mockTenantGetter{tenants: map[string]storage.Tenant{
+		storage.DefaultTenant: {ID: storage.DefaultTenant},
+		"acme":                {ID: "acme"},
+		"globex":              {ID: "globex"},
+	}}
+
+	t.Run("falls back to default tenant", func(t *testing.T) {
+		//given
+		req := httptest.NewRequest(http.MethodGet, "/user/1", nil)
+		//when
+		tenant, rec := serve(getter, cfg, req)
+		//then
+		require.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, storage.DefaultTenant, tenant)
+	})
+
+	t.Run("resolves tenant from header", func(t *testing.T) {
+		//given
+		req := httptest.NewRequest(http.MethodGet, "/user/1", nil)
+		req.Header.Set("X-Tenant-ID", "acme")
+		//when
+		tenant, _ := serve(getter, cfg, req)
+		//then
+		assert.Equal(t, "acme", tenant)
+	})
+
+	t.Run("resolves tenant from subdomain", func(t *testing.T) {
+		//given
+		req := httptest.NewRequest(http.MethodGet, "http://ACME.users.example.com:8080/user/1", nil)
+		//when
+		tenant, _ := serve(getter, cfg, req)
+		//then
+		assert.Equal(t, "acme", tenant)
+	})
+
+	t.Run("ignores subdomain unless host is trusted", func(t *testing.T) {
+		//given
+		untrusted := cfg
+		untrusted.TrustHost = false
+		req := httptest.NewRequest(http.MethodGet, "http://acme.users.example.com/user/1", nil)
+		//when
+		tenant, _ := serve(getter, untrusted, req)
+		//then
+		assert.Equal(t, storage.DefaultTenant, tenant)
+	})
+
+	t.Run("ignores header unless trusted", func(t *testing.T) {
+		//given
+		untrusted := cfg
+		untrusted.TrustHeader = false
+		req := httptest.NewRequest(http.MethodGet, "/user/1", nil)
+		req.Header.Set("X-Tenant-ID", "acme")
+		//when
+		tenant, _ := serve(getter, untrusted, req)
+		//then
+		assert.Equal(t, storage.DefaultTenant, tenant)
+	})
+
+	t.Run("prefers verified token claim over header", func(t *testing.T) {
+		//given
+		req := httptest.NewRequest(http.MethodGet, "/user/1", nil)
+		req = req.WithContext(auth.WithIdentity(req.Context(), auth.Identity{Claims: auth.Claims{"tenant_id": "globex"}}))
+		req.Header.Set("X-Tenant-ID", "acme")
+		//when
+		tenant, _ := serve(getter, cfg, req)
+		//then
+		assert.Equal(t, "globex", tenant)
+	})
+
+	t.Run("ignores claims of unverified token", func(t *testing.T) {
+		//given
+		req := httptest.NewRequest(http.MethodGet, "/user/1", nil)
+		req.Header.Set("Authorization", unverified(map[string]any{"tenant_id": "globex"}))
+		//when
+		tenant, _ := serve(getter, cfg, req)
+		//then
+		assert.Equal(t, storage.DefaultTenant, tenant)
+	})
+
+	t.Run("requires tenant without default", func(t *testing.T) {
+		//given
+		noDefault := cfg
+		noDefault.Default = ""
+		req := httptest.NewRequest(http.MethodGet, "/user/1", nil)
+		//when
+		_, rec := serve(getter, noDefault, req)
+		//then
+		var resp api.Response
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+		assert.Equal(t, "Tenant required", resp.Error)
+	})
+
+	t.Run("rejects unknown tenant", func(t *testing.T) {
+		//given
+		req := httptest.NewRequest(http.MethodGet, "/user/1", nil)
+		req.Header.Set("X-Tenant-ID", "initech")
+		//when
+		_, rec := serve(getter, cfg, req)
+		//then
+		var resp api.Response
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+		assert.Equal(t, http.StatusBadRequest, resp.Status)
+		assert.Equal(t, "Unknown tenant", resp.Error)
+	})
+
+	t.Run("rejects invalid tenant", func(t *testing.T) {
+		//given
+		req := httptest.NewRequest(http.MethodGet, "/user/1", nil)
+		req.Header.Set("X-Tenant-ID", "Acme Corp")
+		//when
+		_, rec := serve(getter, cfg, req)
+		//then
+		var resp api.Response
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+		assert.Equal(t, "Invalid tenant", resp.Error)
+	})
+
+	t.Run("forbids disabled tenant", func(t *testing.T) {
+		//given
+		disabled := time.Now()
+		getter := &mockTenantGetter{tenants: map[string]storage.Tenant{"acme": {ID: "acme", Disabled: &disabled}}}
+		req := httptest.NewRequest(http.MethodGet, "/user/1", nil)
+		req.Header.Set("X-Tenant-ID", "acme")
+		//when
+		_, rec := serve(getter, cfg, req)
+		//then
+		require.Equal(t, http.StatusForbidden, rec.Code)
+		var resp api.Response
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+		assert.Equal(t, "Tenant disabled", resp.Error)
+	})
+
+	t.Run("reports storage failures", func(t *testing.T) {
+		//given
+		getter := &mockTenantGetter{err: errors.New("db down")}
+		req := httptest.NewRequest(http.MethodGet, "/user/1", nil)
+		//when
+		_, rec := serve(getter, cfg, req)
+		//then
+		var resp api.Response
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+		assert.Equal(t, "Failed to get tenant", resp.Error)
+	})
+}
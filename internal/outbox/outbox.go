@@ -0,0 +1,115 @@
+package outbox
+
+import (
+	"context"
+	"log/slog"
+	"test_golang_user_api/internal/config"
+	"test_golang_user_api/internal/storage"
+	"time"
+)
+
+type Publisher interface {
+	Publish(ctx context.Context, event storage.Event) error
+}
+
+// Dispatcher delivers outbox events to a Publisher. Delivery is at least
+// once: an event is marked sent only after Publish succeeds, failed events
+// are retried with exponential backoff and dead-lettered after MaxAttempts.
+type Dispatcher struct {
+	log       *slog.Logger
+	store     storage.Outbox
+	publisher Publisher
+	cfg       config.Outbox
+}
+
+func New(log *slog.Logger, store storage.Outbox, publisher Publisher, cfg config.Outbox) *Dispatcher {
+	return &Dispatcher{
+		log:       log,
+		store:     store,
+		publisher: publisher,
+		cfg:       cfg,
+	}
+}
+
+// Run dispatches events every PollInterval until ctx is done. A full batch
+// is followed immediately by the next one to drain backlogs quickly.
+func (d *Dispatcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(d.cfg.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		dispatched, err := d.Dispatch(ctx)
+		if err != nil {
+			d.log.Error("failed to dispatch outbox events", slog.Any("err", err))
+		}
+
+		if err == nil && dispatched == d.cfg.BatchSize {
+			if ctx.Err() != nil {
+				return
+			}
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Dispatch claims and publishes a single batch, returning the number of
+// events claimed.
+func (d *Dispatcher) Dispatch(ctx context.Context) (int, error) {
+	events, err := d.store.ClaimEvents(ctx, d.cfg.BatchSize, d.cfg.Lease)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, event := range events {
+		d.deliver(ctx, event)
+	}
+
+	return len(events), nil
+}
+
+func (d *Dispatcher) deliver(ctx context.Context, event storage.Event) {
+	log := d.log.With(
+		slog.Int64("event_id", event.ID),
+		slog.String("event_type", event.Type),
+		slog.Int("attempt", event.Attempts),
+	)
+
+	publishErr := d.publisher.Publish(ctx, event)
+	if publishErr == nil {
+		if err := d.store.MarkEventSent(ctx, event.ID); err != nil {
+			log.Error("failed to mark event sent", slog.Any("err", err))
+		}
+		return
+	}
+
+	if event.Attempts >= d.cfg.MaxAttempts {
+		log.Error("event dead-lettered", slog.Any("err", publishErr))
+		if err := d.store.DeadLetterEvent(ctx, event.ID, publishErr.Error()); err != nil {
+			log.Error("failed to dead-letter event", slog.Any("err", err))
+		}
+		return
+	}
+
+	log.Warn("failed to publish event", slog.Any("err", publishErr))
+	retryAt := time.Now().Add(d.backoff(event.Attempts))
+	if err := d.store.RetryEvent(ctx, event.ID, publishErr.Error(), retryAt); err != nil {
+		log.Error("failed to reschedule event", slog.Any("err", err))
+	}
+}
+
+func (d *Dispatcher) backoff(attempts int) time.Duration {
+	delay := d.cfg.RetryBackoff
+	for i := 1; i < attempts && delay < d.cfg.MaxBackoff; i++ {
+		delay *= 2
+	}
+	if d.cfg.MaxBackoff > 0 && delay > d.cfg.MaxBackoff {
+		delay = d.cfg.MaxBackoff
+	}
+	return delay
+}
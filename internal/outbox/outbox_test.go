@@ -0,0 +1,132 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"log/slog"
+	"test_golang_user_api/internal/config"
+	"test_golang_user_api/internal/storage"
+	"testing"
+	"time"
+)
+
+type mockOutbox struct {
+	events  []storage.Event
+	sent    []int64
+	retried map[int64]time.Time
+	dead    []int64
+}
+
+func (m *mockOutbox) ClaimEvents(_ context.Context, limit int, _ time.Duration) ([]storage.Event, error) {
+	if len(m.events) < limit {
+		limit = len(m.events)
+	}
+	claimed := m.events[:limit]
+	m.events = m.events[limit:]
+	return claimed, nil
+}
+
+func (m *mockOutbox) MarkEventSent(_ context.Context, id int64) error {
+	m.sent = append(m.sent, id)
+	return nil
+}
+
+func (m *mockOutbox) RetryEvent(_ context.Context, id int64, _ string, retryAt time.Time) error {
+	if m.retried == nil {
+		m.retried = map[int64]time.Time{}
+	}
+	m.retried[id] = retryAt
+	return nil
+}
+
+func (m *mockOutbox) DeadLetterEvent(_ context.Context, id int64, _ string) error {
+	m.dead = append(m.dead, id)
+	return nil
+}
+
+type mockPublisher struct {
+	err       error
+	published []storage.Event
+}
+
+func (m *mockPublisher) Publish(_ context.Context, event storage.Event) error {
+	if m.err != nil {
+		return m.err
+	}
+	m.published = append(m.published, event)
+	return nil
+}
+
+func testConfig() config.Outbox {
+	return config.Outbox{
+		BatchSize:    10,
+		Lease:        time.Minute,
+		MaxAttempts:  3,
+		RetryBackoff: time.Second,
+		MaxBackoff:   5 * time.Second,
+	}
+}
+
+func newEvent(id int64, attempts int) storage.Event {
+	return storage.Event{
+		ID:       id,
+		Type:     storage.EventUserCreated,
+		UserID:   uuid.New(),
+		Payload:  []byte(`{}`),
+		Created:  time.Now(),
+		Attempts: attempts,
+	}
+}
+
+func TestDispatch(t *testing.T) {
+	t.Run("marks published events sent", func(t *testing.T) {
+		//given
+		store := &mockOutbox{events: []storage.Event{newEvent(1, 1), newEvent(2, 1)}}
+		publisher := &mockPublisher{}
+		d := New(slog.Default(), store, publisher, testConfig())
+		//when
+		n, err := d.Dispatch(context.Background())
+		//then
+		require.NoError(t, err)
+		assert.Equal(t, 2, n)
+		assert.Len(t, publisher.published, 2)
+		assert.Equal(t, []int64{1, 2}, store.sent)
+	})
+
+	t.Run("reschedules failed events with backoff", func(t *testing.T) {
+		//given
+		store := &mockOutbox{events: []storage.Event{newEvent(1, 2)}}
+		d := New(slog.Default(), store, &mockPublisher{err: errors.New("broker down")}, testConfig())
+		started := time.Now()
+		//when
+		_, err := d.Dispatch(context.Background())
+		//then
+		require.NoError(t, err)
+		assert.Empty(t, store.sent)
+		assert.WithinDuration(t, started.Add(2*time.Second), store.retried[1], 500*time.Millisecond)
+	})
+
+	t.Run("dead-letters events after max attempts", func(t *testing.T) {
+		//given
+		store := &mockOutbox{events: []storage.Event{newEvent(1, 3)}}
+		d := New(slog.Default(), store, &mockPublisher{err: errors.New("broker down")}, testConfig())
+		//when
+		_, err := d.Dispatch(context.Background())
+		//then
+		require.NoError(t, err)
+		assert.Equal(t, []int64{1}, store.dead)
+		assert.Empty(t, store.retried)
+	})
+}
+
+func TestBackoff(t *testing.T) {
+	d := New(slog.Default(), &mockOutbox{}, &mockPublisher{}, testConfig())
+
+	assert.Equal(t, time.Second, d.backoff(1))
+	assert.Equal(t, 2*time.Second, d.backoff(2))
+	assert.Equal(t, 4*time.Second, d.backoff(3))
+	assert.Equal(t, 5*time.Second, d.backoff(10))
+}
@@ -0,0 +1,80 @@
+package outbox
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"test_golang_user_api/internal/storage"
+	"time"
+)
+
+type LogPublisher struct {
+	log *slog.Logger
+}
+
+func NewLogPublisher(log *slog.Logger) *LogPublisher {
+	return &LogPublisher{log: log}
+}
+
+func (p *LogPublisher) Publish(_ context.Context, event storage.Event) error {
+	p.log.Info("user event",
+		slog.Int64("event_id", event.ID),
+		slog.String("tenant", event.Tenant),
+		slog.String("event_type", event.Type),
+		slog.String("user_id", event.UserID.String()),
+		slog.String("payload", string(event.Payload)),
+	)
+	return nil
+}
+
+// FilePublisher appends events to a file as newline-delimited JSON.
+type FilePublisher struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+type fileEvent struct {
+	ID      int64           `json:"id"`
+	Tenant  string          `json:"tenant"`
+	Type    string          `json:"type"`
+	UserID  string          `json:"user_id"`
+	Payload json.RawMessage `json:"payload"`
+	Created time.Time       `json:"created"`
+}
+
+func NewFilePublisher(path string) (*FilePublisher, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open event file: %w", err)
+	}
+	return &FilePublisher{file: file}, nil
+}
+
+func (p *FilePublisher) Publish(_ context.Context, event storage.Event) error {
+	line, err := json.Marshal(fileEvent{
+		ID:      event.ID,
+		Tenant:  event.Tenant,
+		Type:    event.Type,
+		UserID:  event.UserID.String(),
+		Payload: event.Payload,
+		Created: event.Created,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to encode event: %w", err)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if _, err := p.file.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("failed to write event: %w", err)
+	}
+	return p.file.Sync()
+}
+
+func (p *FilePublisher) Close() error {
+	return p.file.Close()
+}
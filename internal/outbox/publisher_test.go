@@ -0,0 +1,35 @@
+package outbox
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFilePublisher(t *testing.T) {
+	//given
+	path := filepath.Join(t.TempDir(), "events.ndjson")
+	publisher, err := NewFilePublisher(path)
+	require.NoError(t, err)
+	event := newEvent(7, 1)
+	//when
+	require.NoError(t, publisher.Publish(context.Background(), event))
+	require.NoError(t, publisher.Publish(context.Background(), newEvent(8, 1)))
+	require.NoError(t, publisher.Close())
+	//then
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	require.Len(t, lines, 2)
+
+	var got fileEvent
+	require.NoError(t, json.Unmarshal([]byte(lines[0]), &got))
+	assert.Equal(t, int64(7), got.ID)
+	assert.Equal(t, event.Type, got.Type)
+	assert.Equal(t, event.UserID.String(), got.UserID)
+}
@@ -0,0 +1,39 @@
+package purge
+
+import (
+	"context"
+	"log/slog"
+	"test_golang_user_api/internal/config"
+	"time"
+)
+
+type Purger interface {
+	PurgeDeleted(ctx context.Context, before time.Time) (int64, error)
+}
+
+// Run hard-deletes users soft-deleted longer than cfg.Retention ago, every
+// cfg.Interval until ctx is done. A zero retention disables purging.
+func Run(ctx context.Context, log *slog.Logger, purger Purger, cfg config.Purge) {
+	if cfg.Retention <= 0 || cfg.Interval <= 0 {
+		log.Info("purge of deleted users disabled")
+		return
+	}
+
+	ticker := time.NewTicker(cfg.Interval)
+	defer ticker.Stop()
+
+	for {
+		purged, err := purger.PurgeDeleted(ctx, time.Now().Add(-cfg.Retention))
+		if err != nil {
+			log.Error("failed to purge deleted users", slog.Any("err", err))
+		} else if purged > 0 {
+			log.Info("purged deleted users", slog.Int64("count", purged))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package purge
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"log/slog"
+	"sync/atomic"
+	"test_golang_user_api/internal/config"
+	"testing"
+	"time"
+)
+
+type mockPurger struct {
+	calls     atomic.Int32
+	purgeFunc func(before time.Time) (int64, error)
+}
+
+func (m *mockPurger) PurgeDeleted(_ context.Context, before time.Time) (int64, error) {
+	m.calls.Add(1)
+	return m.purgeFunc(before)
+}
+
+func TestRun(t *testing.T) {
+	t.Run("purges users deleted before retention", func(t *testing.T) {
+		//given
+		ctx, cancel := context.WithCancel(context.Background())
+		started := time.Now()
+		purger := &mockPurger{
+			purgeFunc: func(before time.Time) (int64, error) {
+				assert.WithinDuration(t, started.Add(-time.Hour), before, time.Second)
+				return 1, nil
+			},
+		}
+		done := make(chan struct{})
+		//when
+		go func() {
+			Run(ctx, slog.Default(), purger, config.Purge{Retention: time.Hour, Interval: 5 * time.Millisecond})
+			close(done)
+		}()
+		//then
+		require.Eventually(t, func() bool { return purger.calls.Load() >= 2 }, time.Second, time.Millisecond)
+		cancel()
+		<-done
+	})
+
+	t.Run("does nothing when retention is zero", func(t *testing.T) {
+		//given
+		purger := &mockPurger{}
+		//when
+		Run(context.Background(), slog.Default(), purger, config.Purge{Interval: time.Millisecond})
+		//then
+		assert.Zero(t, purger.calls.Load())
+	})
+}
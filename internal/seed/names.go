@@ -0,0 +1,104 @@
+package seed
+
+// name is a given name or surname as written in its locale, with the ASCII
+// form used for emails.
+type name struct {
+	native string
+	ascii  string
+}
+
+type locale struct {
+	female   []name
+	male     []name
+	surnames []name
+	domains  []string
+	// feminine derives the female form of a surname, for locales whose
+	// surnames differ by gender.
+	feminine func(surname name) name
+}
+
+func n(native, ascii string) name {
+	return name{native: native, ascii: ascii}
+}
+
+var locales = map[string]locale{
+	"en": {
+		female: []name{
+			n("Emma", "emma"), n("Olivia", "olivia"), n("Sophia", "sophia"), n("Isabella", "isabella"),
+			n("Charlotte", "charlotte"), n("Amelia", "amelia"), n("Emily", "emily"), n("Grace", "grace"),
+			n("Chloe", "chloe"), n("Lucy", "lucy"), n("Hannah", "hannah"), n("Megan", "megan"),
+		},
+		male: []name{
+			n("James", "james"), n("Oliver", "oliver"), n("William", "william"), n("Henry", "henry"),
+			n("Jack", "jack"), n("Thomas", "thomas"), n("George", "george"), n("Daniel", "daniel"),
+			n("Samuel", "samuel"), n("Michael", "michael"), n("David", "david"), n("Ethan", "ethan"),
+		},
+		surnames: []name{
+			n("Smith", "smith"), n("Johnson", "johnson"), n("Williams", "williams"), n("Brown", "brown"),
+			n("Jones", "jones"), n("Taylor", "taylor"), n("Davies", "davies"), n("Wilson", "wilson"),
+			n("Evans", "evans"), n("Thomas", "thomas"), n("Roberts", "roberts"), n("Walker", "walker"),
+			n("Wright", "wright"), n("Robinson", "robinson"), n("Thompson", "thompson"), n("White", "white"),
+		},
+		domains: []string{"gmail.com", "outlook.com", "yahoo.com", "icloud.com"},
+	},
+	"ru": {
+		female: []name{
+			n("Анна", "anna"), n("Мария", "maria"), n("Елена", "elena"), n("Ольга", "olga"),
+			n("Татьяна", "tatiana"), n("Наталья", "natalia"), n("Екатерина", "ekaterina"), n("Ирина", "irina"),
+			n("Светлана", "svetlana"), n("Дарья", "daria"), n("Юлия", "yulia"), n("Ксения", "ksenia"),
+		},
+		male: []name{
+			n("Иван", "ivan"), n("Алексей", "alexey"), n("Дмитрий", "dmitry"), n("Сергей", "sergey"),
+			n("Андрей", "andrey"), n("Михаил", "mikhail"), n("Николай", "nikolay"), n("Павел", "pavel"),
+			n("Владимир", "vladimir"), n("Артём", "artem"), n("Максим", "maxim"), n("Егор", "egor"),
+		},
+		surnames: []name{
+			n("Иванов", "ivanov"), n("Смирнов", "smirnov"), n("Кузнецов", "kuznetsov"), n("Попов", "popov"),
+			n("Васильев", "vasiliev"), n("Петров", "petrov"), n("Соколов", "sokolov"), n("Михайлов", "mikhailov"),
+			n("Новиков", "novikov"), n("Фёдоров", "fedorov"), n("Морозов", "morozov"), n("Волков", "volkov"),
+			n("Алексеев", "alekseev"), n("Лебедев", "lebedev"), n("Семёнов", "semenov"), n("Егоров", "egorov"),
+		},
+		domains: []string{"yandex.ru", "mail.ru", "gmail.com", "rambler.ru"},
+		feminine: func(surname name) name {
+			return n(surname.native+"а", surname.ascii+"a")
+		},
+	},
+	"de": {
+		female: []name{
+			n("Anna", "anna"), n("Lena", "lena"), n("Julia", "julia"), n("Laura", "laura"),
+			n("Sophie", "sophie"), n("Lea", "lea"), n("Jana", "jana"), n("Katharina", "katharina"),
+			n("Mia", "mia"), n("Sarah", "sarah"), n("Hannah", "hannah"), n("Marie", "marie"),
+		},
+		male: []name{
+			n("Lukas", "lukas"), n("Jonas", "jonas"), n("Felix", "felix"), n("Maximilian", "maximilian"),
+			n("Paul", "paul"), n("Leon", "leon"), n("Niklas", "niklas"), n("Tobias", "tobias"),
+			n("Stefan", "stefan"), n("Matthias", "matthias"), n("Jörg", "joerg"), n("Sebastian", "sebastian"),
+		},
+		surnames: []name{
+			n("Müller", "mueller"), n("Schmidt", "schmidt"), n("Schneider", "schneider"), n("Fischer", "fischer"),
+			n("Weber", "weber"), n("Meyer", "meyer"), n("Wagner", "wagner"), n("Becker", "becker"),
+			n("Schulz", "schulz"), n("Hoffmann", "hoffmann"), n("Schäfer", "schaefer"), n("Koch", "koch"),
+			n("Bauer", "bauer"), n("Richter", "richter"), n("Klein", "klein"), n("Wolf", "wolf"),
+		},
+		domains: []string{"web.de", "gmx.de", "t-online.de", "gmail.com"},
+	},
+	"es": {
+		female: []name{
+			n("María", "maria"), n("Lucía", "lucia"), n("Carmen", "carmen"), n("Sofía", "sofia"),
+			n("Paula", "paula"), n("Laura", "laura"), n("Marta", "marta"), n("Elena", "elena"),
+			n("Isabel", "isabel"), n("Ana", "ana"), n("Cristina", "cristina"), n("Raquel", "raquel"),
+		},
+		male: []name{
+			n("José", "jose"), n("Antonio", "antonio"), n("Manuel", "manuel"), n("Francisco", "francisco"),
+			n("Javier", "javier"), n("David", "david"), n("Daniel", "daniel"), n("Carlos", "carlos"),
+			n("Alejandro", "alejandro"), n("Pablo", "pablo"), n("Sergio", "sergio"), n("Álvaro", "alvaro"),
+		},
+		surnames: []name{
+			n("García", "garcia"), n("Rodríguez", "rodriguez"), n("González", "gonzalez"), n("Fernández", "fernandez"),
+			n("López", "lopez"), n("Martínez", "martinez"), n("Sánchez", "sanchez"), n("Pérez", "perez"),
+			n("Gómez", "gomez"), n("Martín", "martin"), n("Jiménez", "jimenez"), n("Ruiz", "ruiz"),
+			n("Hernández", "hernandez"), n("Díaz", "diaz"), n("Moreno", "moreno"), n("Muñoz", "munoz"),
+		},
+		domains: []string{"gmail.com", "hotmail.es", "yahoo.es", "outlook.es"},
+	},
+}
@@ -0,0 +1,162 @@
+package seed
+
+import (
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"math/rand/v2"
+	"slices"
+	"strconv"
+	"test_golang_user_api/internal/storage"
+	"time"
+)
+
+var ErrUnknownLocale = errors.New("unknown locale")
+
+const (
+	minAge  = 18
+	maxAge  = 90
+	meanAge = 35
+	ageSD   = 12
+
+	// createdSpan is how far back the creation times of users are spread.
+	createdSpan = 365 * 24 * time.Hour
+)
+
+// Generator makes fake users. The same seed, locales and now always yield
+// the same users in the same order.
+type Generator struct {
+	rng     *rand.Rand
+	locales []locale
+	now     time.Time
+	emails  map[string]struct{}
+}
+
+// Locales lists the supported locales.
+func Locales() []string {
+	names := make([]string, 0, len(locales))
+	for name := range locales {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// New returns a generator drawing users evenly from the given locales, or
+// from every supported locale when none are given.
+func New(seed uint64, now time.Time, names ...string) (*Generator, error) {
+	if len(names) == 0 {
+		names = Locales()
+	}
+
+	g := &Generator{
+		rng:    rand.New(rand.NewPCG(seed, seed)),
+		now:    now.UTC().Truncate(time.Microsecond),
+		emails: make(map[string]struct{}),
+	}
+	for _, name := range names {
+		l, ok := locales[name]
+		if !ok {
+			return nil, fmt.Errorf("%w: %q", ErrUnknownLocale, name)
+		}
+		g.locales = append(g.locales, l)
+	}
+
+	return g, nil
+}
+
+// User makes a user with a unique email. Its tenant is left empty.
+func (g *Generator) User() *storage.UserDto {
+	l := g.locales[g.rng.IntN(len(g.locales))]
+
+	var first, last name
+	last = pick(g.rng, l.surnames)
+	if g.rng.IntN(2) == 0 {
+		first = pick(g.rng, l.female)
+		if l.feminine != nil {
+			last = l.feminine(last)
+		}
+	} else {
+		first = pick(g.rng, l.male)
+	}
+
+	// Created times are truncated to what postgres stores.
+	created := g.now.Add(-time.Duration(g.rng.Int64N(int64(createdSpan)))).Truncate(time.Microsecond)
+
+	return &storage.UserDto{
+		ID:        g.uuid(),
+		Firstname: first.native,
+		Lastname:  last.native,
+		Email:     g.email(first, last, pick(g.rng, l.domains)),
+		Age:       g.age(),
+		Created:   created,
+		Updated:   created,
+		Version:   1,
+	}
+}
+
+// Users makes n users.
+func (g *Generator) Users(n int) []storage.UserDto {
+	users := make([]storage.UserDto, n)
+	for i := range users {
+		users[i] = *g.User()
+	}
+	return users
+}
+
+func pick[T any](rng *rand.Rand, items []T) T {
+	return items[rng.IntN(len(items))]
+}
+
+// uuid makes a random (version 4) UUID from the generator's source rather
+// than crypto/rand, so that ids are reproducible.
+func (g *Generator) uuid() uuid.UUID {
+	var id uuid.UUID
+	for i := 0; i < len(id); i += 8 {
+		v := g.rng.Uint64()
+		for j := range 8 {
+			id[i+j] = byte(v >> (8 * j))
+		}
+	}
+	id[6] = id[6]&0x0f | 0x40
+	id[8] = id[8]&0x3f | 0x80
+	return id
+}
+
+// email makes a lowercase address from the ASCII forms of the names, adding
+// a number when the address is already taken.
+func (g *Generator) email(first, last name, domain string) string {
+	var local string
+	switch g.rng.IntN(4) {
+	case 0:
+		local = first.ascii + "." + last.ascii
+	case 1:
+		local = first.ascii + last.ascii
+	case 2:
+		local = first.ascii[:1] + "." + last.ascii
+	default:
+		local = first.ascii + "_" + last.ascii
+	}
+
+	email := local + "@" + domain
+	for i := 2; ; i++ {
+		if _, taken := g.emails[email]; !taken {
+			break
+		}
+		email = local + strconv.Itoa(i) + "@" + domain
+	}
+	g.emails[email] = struct{}{}
+
+	return email
+}
+
+// age draws from a normal distribution of adults, redrawing ages outside
+// of minAge..maxAge.
+func (g *Generator) age() int {
+	for {
+		age := int(g.rng.NormFloat64()*ageSD + meanAge)
+		if age >= minAge && age <= maxAge {
+			return age
+		}
+	}
+}
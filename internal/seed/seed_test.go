@@ -0,0 +1,105 @@
+package seed
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/mail"
+	"strings"
+	"test_golang_user_api/internal/api"
+	"testing"
+	"time"
+)
+
+var now = time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+func TestGenerator(t *testing.T) {
+	t.Run("same seed yields same users", func(t *testing.T) {
+		//given
+		first, err1 := New(42, now)
+		second, err2 := New(42, now)
+		require.NoError(t, err1)
+		require.NoError(t, err2)
+		//when
+		a := first.Users(100)
+		b := second.Users(100)
+		//then
+		assert.Equal(t, a, b)
+	})
+
+	t.Run("different seeds yield different users", func(t *testing.T) {
+		//given
+		first, err1 := New(1, now)
+		second, err2 := New(2, now)
+		require.NoError(t, err1)
+		require.NoError(t, err2)
+		//when
+		a := first.Users(10)
+		b := second.Users(10)
+		//then
+		assert.NotEqual(t, a, b)
+	})
+
+	t.Run("makes valid users", func(t *testing.T) {
+		//given
+		g, err := New(7, now)
+		require.NoError(t, err)
+		//when
+		users := g.Users(5000)
+		//then
+		ids := make(map[string]struct{})
+		emails := make(map[string]struct{})
+		for _, user := range users {
+			assert.Equal(t, 4, int(user.ID.Version()))
+			assert.NotEmpty(t, user.Firstname)
+			assert.NotEmpty(t, user.Lastname)
+			_, err := mail.ParseAddress(user.Email)
+			assert.NoError(t, err, user.Email)
+			normalized, err := api.NormalizeEmail(user.Email)
+			assert.NoError(t, err)
+			assert.Equal(t, strings.ToLower(normalized), user.Email)
+			assert.GreaterOrEqual(t, user.Age, minAge)
+			assert.LessOrEqual(t, user.Age, maxAge)
+			assert.False(t, user.Created.After(now))
+			assert.True(t, user.Created.After(now.Add(-createdSpan)))
+			assert.Equal(t, user.Created, user.Updated)
+			assert.Equal(t, 1, user.Version)
+			ids[user.ID.String()] = struct{}{}
+			emails[user.Email] = struct{}{}
+		}
+		assert.Len(t, ids, len(users))
+		assert.Len(t, emails, len(users))
+	})
+
+	t.Run("centers ages on mean", func(t *testing.T) {
+		//given
+		g, err := New(3, now)
+		require.NoError(t, err)
+		//when
+		users := g.Users(10000)
+		//then
+		total := 0
+		for _, user := range users {
+			total += user.Age
+		}
+		assert.InDelta(t, meanAge, float64(total)/float64(len(users)), 1.5)
+	})
+
+	t.Run("uses requested locales only", func(t *testing.T) {
+		//given
+		g, err := New(5, now, "ru")
+		require.NoError(t, err)
+		//when
+		users := g.Users(200)
+		//then
+		for _, user := range users {
+			assert.True(t, strings.ContainsAny(user.Firstname, "АБВГДЕЁЖЗИЙКЛМНОПРСТУФХЦЧШЩЭЮЯ"), user.Firstname)
+		}
+	})
+
+	t.Run("rejects unknown locale", func(t *testing.T) {
+		//when
+		_, err := New(1, now, "en", "xx")
+		//then
+		require.ErrorIs(t, err, ErrUnknownLocale)
+	})
+}
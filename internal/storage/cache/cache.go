@@ -0,0 +1,117 @@
+package cache
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"sync"
+	"test_golang_user_api/internal/config"
+	"test_golang_user_api/internal/storage"
+	"time"
+)
+
+// Storage is a read-through cache of GetUser in front of another store.
+// Every other method is passed through; EditUser, DeleteUser and
+// RestoreUser drop the cached user. Changes made around the cache, by other
+// instances of the service or with postgres.Storage.WithTx, are seen once
+// the cached user expires after the configured TTL.
+//
+// Users are cached as read from the primary, never from a replica that
+// may not have a change yet. Reads with storage.WithPrimary bypass the
+// cache, as it may lag behind changes made through other instances.
+type Storage struct {
+	storage.UserStorage
+
+	mu    sync.Mutex
+	users *lru[key, storage.UserDto]
+	// generation counts invalidations, so that a lookup racing with a
+	// change does not cache the user as read before the change.
+	generation uint64
+	stats      Stats
+	now        func() time.Time
+}
+
+// key scopes cached users to their tenant, like the stores do.
+type key struct {
+	tenant string
+	id     uuid.UUID
+}
+
+type Stats struct {
+	Hits      uint64
+	Misses    uint64
+	Evictions uint64
+	Size      int
+}
+
+func New(next storage.UserStorage, cfg config.Cache) *Storage {
+	return &Storage{
+		UserStorage: next,
+		users:       newLRU[key, storage.UserDto](cfg.Size, cfg.TTL),
+		now:         time.Now,
+	}
+}
+
+func (s *Storage) GetUser(ctx context.Context, id uuid.UUID) (*storage.UserDto, error) {
+	if storage.PrimaryFrom(ctx) {
+		return s.UserStorage.GetUser(ctx, id)
+	}
+
+	k := key{tenant: storage.TenantFrom(ctx), id: id}
+
+	s.mu.Lock()
+	if user, ok := s.users.get(k, s.now()); ok {
+		s.stats.Hits++
+		s.mu.Unlock()
+		return &user, nil
+	}
+	s.stats.Misses++
+	generation := s.generation
+	s.mu.Unlock()
+
+	user, err := s.UserStorage.GetUser(storage.WithPrimary(ctx), id)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	if generation == s.generation && s.users.put(k, *user, s.now()) {
+		s.stats.Evictions++
+	}
+	s.mu.Unlock()
+
+	return user, nil
+}
+
+func (s *Storage) EditUser(ctx context.Context, id uuid.UUID, patch storage.UserPatch, version int) (*storage.UserDto, error) {
+	defer s.invalidate(ctx, id)
+	return s.UserStorage.EditUser(ctx, id, patch, version)
+}
+
+func (s *Storage) DeleteUser(ctx context.Context, id uuid.UUID, version int) error {
+	defer s.invalidate(ctx, id)
+	return s.UserStorage.DeleteUser(ctx, id, version)
+}
+
+func (s *Storage) RestoreUser(ctx context.Context, id uuid.UUID) (*storage.UserDto, error) {
+	defer s.invalidate(ctx, id)
+	return s.UserStorage.RestoreUser(ctx, id)
+}
+
+// invalidate drops the user even when the change failed, as a failure such
+// as ErrVersionConflict suggests the cached copy is stale.
+func (s *Storage) invalidate(ctx context.Context, id uuid.UUID) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.users.remove(key{tenant: storage.TenantFrom(ctx), id: id})
+	s.generation++
+}
+
+func (s *Storage) Stats() Stats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stats := s.stats
+	stats.Size = s.users.len()
+	return stats
+}
@@ -0,0 +1,254 @@
+package cache
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"test_golang_user_api/internal/config"
+	"test_golang_user_api/internal/storage"
+	"test_golang_user_api/internal/storage/memory"
+	"test_golang_user_api/internal/storage/storagetest"
+	"testing"
+	"time"
+)
+
+type countingStorage struct {
+	storage.UserStorage
+	gets int
+	// beforeGet runs before each lookup of the wrapped store.
+	beforeGet func()
+}
+
+func (c *countingStorage) GetUser(ctx context.Context, id uuid.UUID) (*storage.UserDto, error) {
+	c.gets++
+	if c.beforeGet != nil {
+		c.beforeGet()
+	}
+	return c.UserStorage.GetUser(ctx, id)
+}
+
+// laggingStorage serves reads without storage.WithPrimary from a replica
+// that has not seen any change since the last call to sync.
+type laggingStorage struct {
+	storage.UserStorage
+	replica map[uuid.UUID]storage.UserDto
+}
+
+func (l *laggingStorage) sync(t *testing.T, ids ...uuid.UUID) {
+	for _, id := range ids {
+		user, err := l.UserStorage.GetUser(context.Background(), id)
+		require.NoError(t, err)
+		l.replica[id] = *user
+	}
+}
+
+func (l *laggingStorage) GetUser(ctx context.Context, id uuid.UUID) (*storage.UserDto, error) {
+	if storage.PrimaryFrom(ctx) {
+		return l.UserStorage.GetUser(ctx, id)
+	}
+	user, ok := l.replica[id]
+	if !ok {
+		return nil, storage.ErrUserNotFound
+	}
+	return &user, nil
+}
+
+func newTestCache(size int) (*Storage, *countingStorage) {
+	next := &countingStorage{UserStorage: memory.New()}
+	return New(next, config.Cache{Enabled: true, Size: size, TTL: time.Minute}), next
+}
+
+func createUser(t *testing.T, ctx context.Context, store storage.UserStorage, email string) *storage.UserDto {
+	user := storage.NewUser(uuid.New(), "Ivan", "Ivanov", email, 30)
+	require.NoError(t, store.CreateUser(ctx, user))
+	return user
+}
+
+func TestCacheGetUser(t *testing.T) {
+	t.Run("serves repeated lookups from cache", func(t *testing.T) {
+		//given
+		store, next := newTestCache(10)
+		user := createUser(t, context.Background(), store, "ivan@gmail.com")
+		//when
+		first, err1 := store.GetUser(context.Background(), user.ID)
+		second, err2 := store.GetUser(context.Background(), user.ID)
+		//then
+		require.NoError(t, err1)
+		require.NoError(t, err2)
+		assert.Equal(t, first, second)
+		assert.Equal(t, 1, next.gets)
+		assert.Equal(t, Stats{Hits: 1, Misses: 1, Size: 1}, store.Stats())
+	})
+
+	t.Run("does not cache missing users", func(t *testing.T) {
+		//given
+		store, next := newTestCache(10)
+		id := uuid.New()
+		//when
+		_, err1 := store.GetUser(context.Background(), id)
+		_, err2 := store.GetUser(context.Background(), id)
+		//then
+		require.ErrorIs(t, err1, storage.ErrUserNotFound)
+		require.ErrorIs(t, err2, storage.ErrUserNotFound)
+		assert.Equal(t, 2, next.gets)
+	})
+
+	t.Run("returns copies of cached users", func(t *testing.T) {
+		//given
+		store, _ := newTestCache(10)
+		user := createUser(t, context.Background(), store, "ivan@gmail.com")
+		first, err := store.GetUser(context.Background(), user.ID)
+		require.NoError(t, err)
+		first.Firstname = "Petr"
+		//when
+		second, err := store.GetUser(context.Background(), user.ID)
+		//then
+		require.NoError(t, err)
+		assert.Equal(t, "Ivan", second.Firstname)
+	})
+
+	t.Run("evicts least recently used user", func(t *testing.T) {
+		//given
+		store, next := newTestCache(2)
+		first := createUser(t, context.Background(), store, "first@gmail.com")
+		second := createUser(t, context.Background(), store, "second@gmail.com")
+		third := createUser(t, context.Background(), store, "third@gmail.com")
+		for _, id := range []uuid.UUID{first.ID, second.ID, first.ID, third.ID} {
+			_, err := store.GetUser(context.Background(), id)
+			require.NoError(t, err)
+		}
+		next.gets = 0
+		//when
+		_, err1 := store.GetUser(context.Background(), first.ID)
+		_, err2 := store.GetUser(context.Background(), second.ID)
+		//then
+		require.NoError(t, err1)
+		require.NoError(t, err2)
+		assert.Equal(t, 1, next.gets)
+		assert.Equal(t, uint64(2), store.Stats().Evictions)
+		assert.Equal(t, 2, store.Stats().Size)
+	})
+
+	t.Run("expires users after ttl", func(t *testing.T) {
+		//given
+		store, next := newTestCache(10)
+		now := time.Now()
+		store.now = func() time.Time { return now }
+		user := createUser(t, context.Background(), store, "ivan@gmail.com")
+		_, err := store.GetUser(context.Background(), user.ID)
+		require.NoError(t, err)
+		now = now.Add(time.Minute)
+		//when
+		_, err = store.GetUser(context.Background(), user.ID)
+		//then
+		require.NoError(t, err)
+		assert.Equal(t, 2, next.gets)
+	})
+
+	t.Run("scopes cached users to tenant", func(t *testing.T) {
+		//given
+		store, _ := newTestCache(10)
+		require.NoError(t, store.CreateTenant(context.Background(), &storage.Tenant{ID: "acme", Name: "Acme"}))
+		user := createUser(t, context.Background(), store, "ivan@gmail.com")
+		_, err := store.GetUser(context.Background(), user.ID)
+		require.NoError(t, err)
+		//when
+		_, err = store.GetUser(storage.WithTenant(context.Background(), "acme"), user.ID)
+		//then
+		require.ErrorIs(t, err, storage.ErrUserNotFound)
+	})
+}
+
+func TestCacheInvalidation(t *testing.T) {
+	t.Run("edit drops cached user", func(t *testing.T) {
+		//given
+		store, _ := newTestCache(10)
+		user := createUser(t, context.Background(), store, "ivan@gmail.com")
+		_, err := store.GetUser(context.Background(), user.ID)
+		require.NoError(t, err)
+		//when
+		_, err = store.EditUser(context.Background(), user.ID, storage.NewUser(user.ID, "Petr", "Petrov", "petr@gmail.com", 31).Patch(), 0)
+		require.NoError(t, err)
+		got, err := store.GetUser(context.Background(), user.ID)
+		//then
+		require.NoError(t, err)
+		assert.Equal(t, "Petr", got.Firstname)
+		assert.Equal(t, 2, got.Version)
+	})
+
+	t.Run("delete drops cached user", func(t *testing.T) {
+		//given
+		store, _ := newTestCache(10)
+		user := createUser(t, context.Background(), store, "ivan@gmail.com")
+		_, err := store.GetUser(context.Background(), user.ID)
+		require.NoError(t, err)
+		//when
+		require.NoError(t, store.DeleteUser(context.Background(), user.ID, 0))
+		_, err = store.GetUser(context.Background(), user.ID)
+		//then
+		require.ErrorIs(t, err, storage.ErrUserNotFound)
+	})
+
+	t.Run("does not cache user read before concurrent edit", func(t *testing.T) {
+		//given
+		store, next := newTestCache(10)
+		user := createUser(t, context.Background(), store, "ivan@gmail.com")
+		next.beforeGet = func() {
+			next.beforeGet = nil
+			store.invalidate(context.Background(), user.ID)
+		}
+		_, err := store.GetUser(context.Background(), user.ID)
+		require.NoError(t, err)
+		//when
+		_, err = store.GetUser(context.Background(), user.ID)
+		//then
+		require.NoError(t, err)
+		assert.Equal(t, 2, next.gets)
+		assert.Equal(t, 1, store.Stats().Size)
+	})
+}
+
+func TestCacheReplicas(t *testing.T) {
+	newLaggingCache := func() (*Storage, *laggingStorage) {
+		next := &laggingStorage{UserStorage: memory.New(), replica: make(map[uuid.UUID]storage.UserDto)}
+		return New(next, config.Cache{Enabled: true, Size: 10, TTL: time.Minute}), next
+	}
+
+	t.Run("does not cache user from lagging replica after write", func(t *testing.T) {
+		//given
+		store, next := newLaggingCache()
+		user := createUser(t, context.Background(), store, "ivan@gmail.com")
+		next.sync(t, user.ID)
+		_, err := store.EditUser(context.Background(), user.ID, storage.NewUser(user.ID, "Petr", "Petrov", "petr@gmail.com", 31).Patch(), 0)
+		require.NoError(t, err)
+		//when
+		got, err := store.GetUser(context.Background(), user.ID)
+		require.NoError(t, err)
+		primary, primaryErr := store.GetUser(storage.WithPrimary(context.Background()), user.ID)
+		//then
+		assert.Equal(t, "Petr", got.Firstname)
+		require.NoError(t, primaryErr)
+		assert.Equal(t, "Petr", primary.Firstname)
+	})
+
+	t.Run("bypasses cache for reads from primary", func(t *testing.T) {
+		//given
+		store, _ := newLaggingCache()
+		user := createUser(t, context.Background(), store, "ivan@gmail.com")
+		_, err := store.GetUser(storage.WithPrimary(context.Background()), user.ID)
+		require.NoError(t, err)
+		//when
+		_, err = store.GetUser(storage.WithPrimary(context.Background()), user.ID)
+		//then
+		require.NoError(t, err)
+		assert.Equal(t, Stats{}, store.Stats())
+	})
+}
+
+func TestConformance(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) storage.UserStorage {
+		store, _ := newTestCache(10)
+		return store
+	})
+}
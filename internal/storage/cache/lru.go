@@ -0,0 +1,81 @@
+package cache
+
+import (
+	"container/list"
+	"time"
+)
+
+// lru is a size-bounded least recently used map whose entries expire after
+// a fixed TTL. It is not safe for concurrent use.
+type lru[K comparable, V any] struct {
+	size    int
+	ttl     time.Duration
+	order   *list.List
+	entries map[K]*list.Element
+}
+
+type lruEntry[K comparable, V any] struct {
+	key     K
+	value   V
+	expires time.Time
+}
+
+func newLRU[K comparable, V any](size int, ttl time.Duration) *lru[K, V] {
+	return &lru[K, V]{
+		size:    size,
+		ttl:     ttl,
+		order:   list.New(),
+		entries: make(map[K]*list.Element, size),
+	}
+}
+
+func (c *lru[K, V]) get(key K, now time.Time) (V, bool) {
+	element, ok := c.entries[key]
+	if !ok {
+		var zero V
+		return zero, false
+	}
+
+	entry := element.Value.(*lruEntry[K, V])
+	if !now.Before(entry.expires) {
+		c.order.Remove(element)
+		delete(c.entries, key)
+		var zero V
+		return zero, false
+	}
+
+	c.order.MoveToFront(element)
+	return entry.value, true
+}
+
+// put stores value and reports whether another entry was evicted for it.
+func (c *lru[K, V]) put(key K, value V, now time.Time) bool {
+	if element, ok := c.entries[key]; ok {
+		entry := element.Value.(*lruEntry[K, V])
+		entry.value = value
+		entry.expires = now.Add(c.ttl)
+		c.order.MoveToFront(element)
+		return false
+	}
+
+	c.entries[key] = c.order.PushFront(&lruEntry[K, V]{key: key, value: value, expires: now.Add(c.ttl)})
+	if c.order.Len() <= c.size {
+		return false
+	}
+
+	oldest := c.order.Back()
+	c.order.Remove(oldest)
+	delete(c.entries, oldest.Value.(*lruEntry[K, V]).key)
+	return true
+}
+
+func (c *lru[K, V]) remove(key K) {
+	if element, ok := c.entries[key]; ok {
+		c.order.Remove(element)
+		delete(c.entries, key)
+	}
+}
+
+func (c *lru[K, V]) len() int {
+	return c.order.Len()
+}
@@ -0,0 +1,343 @@
+package storage
+
+import (
+	"bytes"
+	"cmp"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"golang.org/x/net/idna"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	ErrInvalidFilter = errors.New("invalid filter")
+	ErrInvalidSort   = errors.New("invalid sort")
+)
+
+// Field names a UserDto field that users can be filtered and sorted by.
+type Field string
+
+const (
+	FieldID        Field = "id"
+	FieldFirstname Field = "firstname"
+	FieldLastname  Field = "lastname"
+	FieldEmail     Field = "email"
+	FieldAge       Field = "age"
+	FieldCreated   Field = "created"
+	FieldUpdated   Field = "updated"
+	FieldVersion   Field = "version"
+)
+
+// Op is how a Condition compares a field with its value. Text fields are
+// compared case-insensitively.
+type Op string
+
+const (
+	OpEq     Op = "eq"
+	OpPrefix Op = "prefix"
+	// OpDomain matches the domain of an email address.
+	OpDomain Op = "domain"
+	OpGt     Op = "gt"
+	OpGte    Op = "gte"
+	OpLt     Op = "lt"
+	OpLte    Op = "lte"
+)
+
+type kind int
+
+const (
+	kindUUID kind = iota
+	kindText
+	kindInt
+	kindTime
+)
+
+var rangeOps = []Op{OpEq, OpGt, OpGte, OpLt, OpLte}
+
+var fields = map[Field]struct {
+	kind kind
+	ops  []Op
+}{
+	FieldID:        {kind: kindUUID, ops: []Op{OpEq}},
+	FieldFirstname: {kind: kindText, ops: []Op{OpEq, OpPrefix}},
+	FieldLastname:  {kind: kindText, ops: []Op{OpEq, OpPrefix}},
+	FieldEmail:     {kind: kindText, ops: []Op{OpEq, OpPrefix, OpDomain}},
+	FieldAge:       {kind: kindInt, ops: rangeOps},
+	FieldCreated:   {kind: kindTime, ops: rangeOps},
+	FieldUpdated:   {kind: kindTime, ops: rangeOps},
+	FieldVersion:   {kind: kindInt, ops: rangeOps},
+}
+
+// Value returns the field of user: a uuid.UUID, string, int or UTC
+// time.Time.
+func (f Field) Value(user UserDto) any {
+	switch f {
+	case FieldID:
+		return user.ID
+	case FieldFirstname:
+		return user.Firstname
+	case FieldLastname:
+		return user.Lastname
+	case FieldEmail:
+		return user.Email
+	case FieldAge:
+		return user.Age
+	case FieldCreated:
+		return user.Created.UTC()
+	case FieldUpdated:
+		return user.Updated.UTC()
+	case FieldVersion:
+		return user.Version
+	}
+	return nil
+}
+
+func (f Field) parse(raw string) (any, error) {
+	switch fields[f].kind {
+	case kindUUID:
+		return uuid.Parse(raw)
+	case kindInt:
+		return strconv.Atoi(raw)
+	case kindTime:
+		return parseTime(raw)
+	default:
+		return raw, nil
+	}
+}
+
+func (f Field) format(value any) string {
+	switch v := value.(type) {
+	case time.Time:
+		return v.UTC().Format(time.RFC3339Nano)
+	case int:
+		return strconv.Itoa(v)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// parseTime accepts RFC 3339 timestamps and dates, which stand for
+// midnight UTC.
+func parseTime(raw string) (time.Time, error) {
+	if t, err := time.Parse(time.DateOnly, raw); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse(time.RFC3339Nano, raw)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("expected a date or RFC 3339 time, got %q", raw)
+	}
+	return t.UTC(), nil
+}
+
+// TimeRange is the value of an OpEq condition on a date: the times from
+// From up to, but not including, To.
+type TimeRange struct {
+	From time.Time
+	To   time.Time
+}
+
+// wholeDay makes a condition on a date cover the whole UTC day: equal
+// times fall within it, later ones come after its end and earlier ones
+// before its start.
+func wholeDay(c Condition) Condition {
+	day := c.Value.(time.Time)
+	next := day.AddDate(0, 0, 1)
+	switch c.Op {
+	case OpEq:
+		c.Value = TimeRange{From: day, To: next}
+	case OpGt:
+		c.Op, c.Value = OpGte, next
+	case OpLte:
+		c.Op, c.Value = OpLt, next
+	}
+	return c
+}
+
+// NormalizeDomain converts an email domain to lowercase ASCII, encoding
+// internationalized domains as punycode, as emails are stored.
+func NormalizeDomain(domain string) (string, error) {
+	ascii, err := idna.Lookup.ToASCII(domain)
+	if err != nil {
+		return "", err
+	}
+	return strings.ToLower(ascii), nil
+}
+
+// Condition restricts a listing to users whose Field compares to Value
+// as Op says. All conditions of a listing must hold.
+type Condition struct {
+	Field Field
+	Op    Op
+	Value any
+}
+
+// ParseCondition checks that field supports op and parses raw into the
+// type of the field. A date given for a time field covers the whole UTC
+// day, see TimeRange, and a domain is normalized like the domains of
+// stored emails.
+func ParseCondition(field, op, raw string) (Condition, error) {
+	spec, ok := fields[Field(field)]
+	if !ok {
+		return Condition{}, fmt.Errorf("%w: unknown field %q", ErrInvalidFilter, field)
+	}
+	if op == "" {
+		op = string(OpEq)
+	}
+	supported := false
+	for _, candidate := range spec.ops {
+		supported = supported || candidate == Op(op)
+	}
+	if !supported {
+		return Condition{}, fmt.Errorf("%w: %s does not support %q", ErrInvalidFilter, field, op)
+	}
+
+	if raw == "" {
+		return Condition{}, fmt.Errorf("%w: %s.%s needs a value", ErrInvalidFilter, field, op)
+	}
+	if Op(op) == OpDomain {
+		domain, err := NormalizeDomain(raw)
+		if err != nil {
+			return Condition{}, fmt.Errorf("%w: %s.%s: %w", ErrInvalidFilter, field, op, err)
+		}
+		raw = domain
+	}
+	value, err := Field(field).parse(raw)
+	if err != nil {
+		return Condition{}, fmt.Errorf("%w: %s.%s: %w", ErrInvalidFilter, field, op, err)
+	}
+
+	condition := Condition{Field: Field(field), Op: Op(op), Value: value}
+	if _, err := time.Parse(time.DateOnly, raw); err == nil && spec.kind == kindTime {
+		condition = wholeDay(condition)
+	}
+	return condition, nil
+}
+
+// Match evaluates the condition in Go, like the SQL stores do in SQL.
+func (c Condition) Match(user UserDto) bool {
+	value := c.Field.Value(user)
+
+	if text, ok := value.(string); ok {
+		text, want := strings.ToLower(text), strings.ToLower(c.Value.(string))
+		switch c.Op {
+		case OpPrefix:
+			return strings.HasPrefix(text, want)
+		case OpDomain:
+			return strings.HasSuffix(text, "@"+want)
+		default:
+			return text == want
+		}
+	}
+
+	if r, ok := c.Value.(TimeRange); ok {
+		t := value.(time.Time)
+		return !t.Before(r.From) && t.Before(r.To)
+	}
+
+	n := compareValues(value, c.Value)
+	switch c.Op {
+	case OpGt:
+		return n > 0
+	case OpGte:
+		return n >= 0
+	case OpLt:
+		return n < 0
+	case OpLte:
+		return n <= 0
+	default:
+		return n == 0
+	}
+}
+
+func compareValues(a, b any) int {
+	switch a := a.(type) {
+	case uuid.UUID:
+		b := b.(uuid.UUID)
+		return bytes.Compare(a[:], b[:])
+	case string:
+		return cmp.Compare(a, b.(string))
+	case int:
+		return cmp.Compare(a, b.(int))
+	case time.Time:
+		return a.Compare(b.(time.Time))
+	}
+	return 0
+}
+
+type SortField struct {
+	Field Field
+	Desc  bool
+}
+
+// Sort orders a listing by its fields in turn. Users are ordered by
+// Created when no fields are given, and by ID after the given fields, so
+// that the order is total.
+type Sort []SortField
+
+// ParseSort parses a comma-separated list of fields, each descending
+// when prefixed with "-", e.g. "-age,lastname".
+func ParseSort(raw string) (Sort, error) {
+	if raw == "" {
+		return nil, nil
+	}
+
+	var sort Sort
+	seen := make(map[Field]bool)
+	for _, name := range strings.Split(raw, ",") {
+		desc := strings.HasPrefix(name, "-")
+		field := Field(strings.TrimPrefix(name, "-"))
+		if _, ok := fields[field]; !ok {
+			return nil, fmt.Errorf("%w: unknown field %q", ErrInvalidSort, field)
+		}
+		if seen[field] {
+			return nil, fmt.Errorf("%w: %s is repeated", ErrInvalidSort, field)
+		}
+		seen[field] = true
+		sort = append(sort, SortField{Field: field, Desc: desc})
+	}
+
+	return sort, nil
+}
+
+func (s Sort) String() string {
+	names := make([]string, len(s))
+	for i, field := range s {
+		names[i] = string(field.Field)
+		if field.Desc {
+			names[i] = "-" + names[i]
+		}
+	}
+	return strings.Join(names, ",")
+}
+
+// Keys returns the fields users are actually ordered by: the sort with
+// the defaults described on Sort.
+func (s Sort) Keys() []SortField {
+	keys := append([]SortField(nil), s...)
+	if len(keys) == 0 {
+		keys = append(keys, SortField{Field: FieldCreated})
+	}
+	for _, key := range keys {
+		if key.Field == FieldID {
+			return keys
+		}
+	}
+	return append(keys, SortField{Field: FieldID})
+}
+
+// Compare orders users like a listing with the sort does.
+func (s Sort) Compare(a, b UserDto) int {
+	for _, key := range s.Keys() {
+		n := compareValues(key.Field.Value(a), key.Field.Value(b))
+		if key.Desc {
+			n = -n
+		}
+		if n != 0 {
+			return n
+		}
+	}
+	return 0
+}
@@ -0,0 +1,188 @@
+// Package fuzzy ranks and highlights users for a search query the way
+// pg_trgm does: by the similarity of the trigrams of the query and the
+// words of the user's names and email.
+package fuzzy
+
+import (
+	"cmp"
+	"html"
+	"slices"
+	"strings"
+	"test_golang_user_api/internal/storage"
+	"unicode"
+)
+
+// Threshold is the least word similarity of a match, like
+// pg_trgm.word_similarity_threshold; it is low enough to find names with
+// a typo or two.
+const Threshold = 0.3
+
+// Fields are the fields searched, in the order of the search document.
+var Fields = []storage.Field{storage.FieldFirstname, storage.FieldLastname, storage.FieldEmail}
+
+// words splits text into lowercase runs of letters and digits, as pg_trgm
+// does before making trigrams.
+func words(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// trigrams returns the trigrams of a word padded with two spaces in front
+// and one behind, in order.
+func trigrams(word string) []string {
+	runes := []rune("  " + word + " ")
+	result := make([]string, 0, len(runes)-2)
+	for i := 0; i+3 <= len(runes); i++ {
+		result = append(result, string(runes[i:i+3]))
+	}
+	return result
+}
+
+// Trigrams returns the distinct trigrams of the words of text, in order of
+// first appearance.
+func Trigrams(text string) []string {
+	var result []string
+	seen := make(map[string]bool)
+	for _, word := range words(text) {
+		for _, trigram := range trigrams(word) {
+			if !seen[trigram] {
+				seen[trigram] = true
+				result = append(result, trigram)
+			}
+		}
+	}
+	return result
+}
+
+func trigramSet(text string) map[string]bool {
+	set := make(map[string]bool)
+	for _, word := range words(text) {
+		for _, trigram := range trigrams(word) {
+			set[trigram] = true
+		}
+	}
+	return set
+}
+
+// WordSimilarity is the greatest similarity between the trigrams of query
+// and any continuous extent of the trigrams of text, as pg_trgm's
+// word_similarity(query, text).
+func WordSimilarity(query, text string) float64 {
+	want := trigramSet(query)
+	if len(want) == 0 {
+		return 0
+	}
+
+	var sequence []string
+	for _, word := range words(text) {
+		sequence = append(sequence, trigrams(word)...)
+	}
+
+	best := 0.0
+	for start := range sequence {
+		extent := make(map[string]bool)
+		matched := 0
+		for _, trigram := range sequence[start:] {
+			if extent[trigram] {
+				continue
+			}
+			extent[trigram] = true
+			if want[trigram] {
+				matched++
+			}
+			similarity := float64(matched) / float64(len(want)+len(extent)-matched)
+			best = max(best, similarity)
+		}
+	}
+	return best
+}
+
+// Document is the text searched for user.
+func Document(user storage.UserDto) string {
+	parts := make([]string, len(Fields))
+	for i, field := range Fields {
+		parts[i] = field.Value(user).(string)
+	}
+	return strings.Join(parts, " ")
+}
+
+// Rank scores users against query and returns up to limit matches, best
+// first and then by id.
+func Rank(query string, users []storage.UserDto, limit int) []storage.SearchResult {
+	results := make([]storage.SearchResult, 0, limit)
+	for _, user := range users {
+		score := WordSimilarity(query, Document(user))
+		if score < Threshold {
+			continue
+		}
+		results = append(results, storage.SearchResult{User: user, Score: score})
+	}
+
+	slices.SortFunc(results, func(a, b storage.SearchResult) int {
+		if n := cmp.Compare(b.Score, a.Score); n != 0 {
+			return n
+		}
+		return strings.Compare(a.User.ID.String(), b.User.ID.String())
+	})
+	if len(results) > limit {
+		results = results[:limit]
+	}
+
+	for i := range results {
+		results[i].Highlights = Highlight(query, results[i].User)
+	}
+	return results
+}
+
+// Highlight marks the words of each searched field that match a word of
+// query with <em> tags, HTML-escaping the rest. Fields without matches are
+// left out.
+func Highlight(query string, user storage.UserDto) map[storage.Field]string {
+	terms := words(query)
+	highlights := make(map[storage.Field]string)
+	for _, field := range Fields {
+		if snippet, ok := highlight(terms, field.Value(user).(string)); ok {
+			highlights[field] = snippet
+		}
+	}
+	return highlights
+}
+
+func highlight(terms []string, text string) (string, bool) {
+	var b strings.Builder
+	found := false
+
+	runes := []rune(text)
+	for i := 0; i < len(runes); {
+		j := i
+		for j < len(runes) && (unicode.IsLetter(runes[j]) || unicode.IsDigit(runes[j])) {
+			j++
+		}
+		if j == i {
+			b.WriteString(html.EscapeString(string(runes[i])))
+			i++
+			continue
+		}
+
+		word := string(runes[i:j])
+		if matches(terms, strings.ToLower(word)) {
+			found = true
+			b.WriteString("<em>" + html.EscapeString(word) + "</em>")
+		} else {
+			b.WriteString(html.EscapeString(word))
+		}
+		i = j
+	}
+
+	return b.String(), found
+}
+
+func matches(terms []string, word string) bool {
+	for _, term := range terms {
+		if strings.HasPrefix(word, term) || WordSimilarity(term, word) >= Threshold {
+			return true
+		}
+	}
+	return false
+}
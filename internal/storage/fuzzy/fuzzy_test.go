@@ -0,0 +1,89 @@
+package fuzzy
+
+import (
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"test_golang_user_api/internal/storage"
+	"testing"
+)
+
+func TestWordSimilarity(t *testing.T) {
+	t.Run("matches pg_trgm", func(t *testing.T) {
+		//when
+		similarity := WordSimilarity("word", "two words")
+		//then
+		assert.InDelta(t, 0.8, similarity, 1e-9)
+	})
+
+	t.Run("tolerates typos", func(t *testing.T) {
+		for _, query := range []string{"ivna", "Ivanvo", "ivanov@gmial"} {
+			//when
+			similarity := WordSimilarity(query, "Ivan Ivanov ivanov@gmail.com")
+			//then
+			assert.GreaterOrEqual(t, similarity, Threshold, query)
+		}
+	})
+
+	t.Run("rejects unrelated text", func(t *testing.T) {
+		//when
+		similarity := WordSimilarity("petrov", "Ivan Ivanov ivan@gmail.com")
+		//then
+		assert.Less(t, similarity, Threshold)
+	})
+
+	t.Run("is zero for empty query", func(t *testing.T) {
+		assert.Zero(t, WordSimilarity(" ,. ", "Ivan"))
+	})
+}
+
+func TestTrigrams(t *testing.T) {
+	t.Run("pads words like pg_trgm", func(t *testing.T) {
+		//when
+		trigrams := Trigrams("Ivan, ivo")
+		//then
+		assert.Equal(t, []string{"  i", " iv", "iva", "van", "an ", "ivo", "vo "}, trigrams)
+	})
+}
+
+func TestRank(t *testing.T) {
+	t.Run("orders matches by score and drops the rest", func(t *testing.T) {
+		//given
+		exact := storage.NewUser(uuid.New(), "Ivan", "Ivanov", "ivan@gmail.com", 30)
+		close := storage.NewUser(uuid.New(), "Iva", "Smith", "iva@gmail.com", 30)
+		unrelated := storage.NewUser(uuid.New(), "Petr", "Petrov", "petr@gmail.com", 30)
+		//when
+		results := Rank("ivanov", []storage.UserDto{*unrelated, *close, *exact}, 10)
+		//then
+		require.Len(t, results, 2)
+		assert.Equal(t, exact.ID, results[0].User.ID)
+		assert.Equal(t, close.ID, results[1].User.ID)
+		assert.Greater(t, results[0].Score, results[1].Score)
+		assert.Equal(t, "<em>Ivanov</em>", results[0].Highlights[storage.FieldLastname])
+	})
+
+	t.Run("applies limit", func(t *testing.T) {
+		//given
+		first := storage.NewUser(uuid.New(), "Ivan", "Ivanov", "ivan@gmail.com", 30)
+		second := storage.NewUser(uuid.New(), "Ivan", "Ivanov", "ivan2@gmail.com", 30)
+		//when
+		results := Rank("ivan", []storage.UserDto{*first, *second}, 1)
+		//then
+		assert.Len(t, results, 1)
+	})
+}
+
+func TestHighlight(t *testing.T) {
+	t.Run("marks similar and prefixed words and escapes the rest", func(t *testing.T) {
+		//given
+		user := storage.NewUser(uuid.New(), "Anna-Maria", "O'Neil", "a.oneil@example.com", 30)
+		//when
+		highlights := Highlight("mari neill", *user)
+		//then
+		assert.Equal(t, map[storage.Field]string{
+			storage.FieldFirstname: "Anna-<em>Maria</em>",
+			storage.FieldLastname:  "O&#39;<em>Neil</em>",
+			storage.FieldEmail:     "a.<em>oneil</em>@example.com",
+		}, highlights)
+	})
+}
@@ -0,0 +1,164 @@
+package storage
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+)
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// Cursor is the position of a user in a listing: the values of the user
+// for the keys of Sort, see Sort.Keys. A cursor only applies to listings
+// with the same sort.
+type Cursor struct {
+	Sort   Sort
+	Values []any
+}
+
+func (s Sort) CursorOf(user UserDto) Cursor {
+	keys := s.Keys()
+	values := make([]any, len(keys))
+	for i, key := range keys {
+		values[i] = key.Field.Value(user)
+	}
+	return Cursor{Sort: s, Values: values}
+}
+
+type cursorToken struct {
+	Sort   string   `json:"s"`
+	Values []string `json:"v"`
+}
+
+// String encodes the cursor as an opaque URL-safe token.
+func (c Cursor) String() string {
+	keys := c.Sort.Keys()
+	token := cursorToken{Sort: c.Sort.String(), Values: make([]string, len(c.Values))}
+	for i, value := range c.Values {
+		token.Values[i] = keys[i].Field.format(value)
+	}
+	raw, _ := json.Marshal(token)
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+// ParseCursor decodes a token made by Cursor.String for a listing with
+// sort, failing with ErrInvalidCursor for tokens of another sort.
+func ParseCursor(token string, sort Sort) (Cursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return Cursor{}, ErrInvalidCursor
+	}
+
+	var decoded cursorToken
+	if err := json.Unmarshal(raw, &decoded); err != nil {
+		return Cursor{}, ErrInvalidCursor
+	}
+	keys := sort.Keys()
+	if decoded.Sort != sort.String() || len(decoded.Values) != len(keys) {
+		return Cursor{}, fmt.Errorf("%w: cursor is for another sort", ErrInvalidCursor)
+	}
+
+	c := Cursor{Sort: sort, Values: make([]any, len(keys))}
+	for i, key := range keys {
+		if c.Values[i], err = key.Field.parse(decoded.Values[i]); err != nil {
+			return Cursor{}, ErrInvalidCursor
+		}
+	}
+
+	return c, nil
+}
+
+// CompareUser orders user relative to the cursor like the listing does:
+// negative when user comes first.
+func (c Cursor) CompareUser(user UserDto) int {
+	for i, key := range c.Sort.Keys() {
+		n := compareValues(key.Field.Value(user), c.Values[i])
+		if key.Desc {
+			n = -n
+		}
+		if n != 0 {
+			return n
+		}
+	}
+	return 0
+}
+
+// ListQuery selects a page of the users matching every condition of
+// Filter, in the order of Sort. At most one of After and Before is set:
+// After pages forward from a cursor, Before pages backward from it, and
+// neither starts at the first user.
+type ListQuery struct {
+	Limit     int
+	Filter    []Condition
+	Sort      Sort
+	After     *Cursor
+	Before    *Cursor
+	WithTotal bool
+}
+
+func (q ListQuery) Validate() error {
+	if q.After != nil && q.Before != nil {
+		return fmt.Errorf("%w: after and before are exclusive", ErrInvalidCursor)
+	}
+	for _, cursor := range []*Cursor{q.After, q.Before} {
+		if cursor != nil && (cursor.Sort.String() != q.Sort.String() || len(cursor.Values) != len(q.Sort.Keys())) {
+			return fmt.Errorf("%w: cursor is for another sort", ErrInvalidCursor)
+		}
+	}
+	if q.Limit < 1 {
+		return fmt.Errorf("limit must be positive, got %d", q.Limit)
+	}
+	return nil
+}
+
+// UserPage is a page of live users in the order of the query. Next and
+// Prev are set when there may be users after or before the page; Total
+// counts every live user of the tenant matching the filter and is only set
+// when asked for.
+type UserPage struct {
+	Users []UserDto
+	Next  *Cursor
+	Prev  *Cursor
+	Total *int64
+}
+
+// NewUserPage builds the page for query from up to query.Limit+1 users
+// read in the direction of the query: ascending, or descending when
+// paging backward. The extra user only tells whether more follow.
+func NewUserPage(query ListQuery, users []UserDto) *UserPage {
+	if users == nil {
+		users = []UserDto{}
+	}
+	more := len(users) > query.Limit
+	if more {
+		users = users[:query.Limit]
+	}
+	backward := query.Before != nil
+	if backward {
+		slices.Reverse(users)
+	}
+
+	page := &UserPage{Users: users}
+	if len(users) == 0 {
+		return page
+	}
+
+	first, last := query.Sort.CursorOf(users[0]), query.Sort.CursorOf(users[len(users)-1])
+	if backward {
+		page.Next = &last
+		if more {
+			page.Prev = &first
+		}
+	} else {
+		if more {
+			page.Next = &last
+		}
+		if query.After != nil {
+			page.Prev = &first
+		}
+	}
+
+	return page
+}
@@ -0,0 +1,159 @@
+// Package listsql compiles storage.ListQuery into parameterized SQL for the
+// users table. Values are always passed as parameters; field names only
+// come from a fixed table of columns.
+package listsql
+
+import (
+	"strconv"
+	"strings"
+	"test_golang_user_api/internal/storage"
+)
+
+// Placeholder renders the n-th parameter of a statement, counting from 1.
+type Placeholder func(n int) string
+
+func Dollar(n int) string {
+	return "$" + strconv.Itoa(n)
+}
+
+func Question(int) string {
+	return "?"
+}
+
+var columns = map[storage.Field]string{
+	storage.FieldID:        "id",
+	storage.FieldFirstname: "firstname",
+	storage.FieldLastname:  "lastname",
+	storage.FieldEmail:     "email",
+	storage.FieldAge:       "age",
+	storage.FieldCreated:   "created",
+	storage.FieldUpdated:   "updated_at",
+	storage.FieldVersion:   "version",
+}
+
+var operators = map[storage.Op]string{
+	storage.OpEq:  "=",
+	storage.OpGt:  ">",
+	storage.OpGte: ">=",
+	storage.OpLt:  "<",
+	storage.OpLte: "<=",
+}
+
+type builder struct {
+	placeholder Placeholder
+	args        []any
+}
+
+func (b *builder) arg(value any) string {
+	b.args = append(b.args, value)
+	return b.placeholder(len(b.args))
+}
+
+// Select reads the page for query from the live users of tenant: one
+// user more than query.Limit, in reverse order when paging backward, as
+// storage.NewUserPage expects.
+func Select(userColumns, tenant string, query storage.ListQuery, placeholder Placeholder) (string, []any) {
+	b := &builder{placeholder: placeholder}
+	where := b.where(tenant, query.Filter)
+
+	backward := query.Before != nil
+	cursor := query.After
+	if backward {
+		cursor = query.Before
+	}
+	if cursor != nil {
+		where = append(where, b.keyset(query.Sort.Keys(), cursor, backward))
+	}
+
+	keys := query.Sort.Keys()
+	order := make([]string, len(keys))
+	for i, key := range keys {
+		order[i] = columns[key.Field]
+		if key.Desc != backward {
+			order[i] += " DESC"
+		}
+	}
+
+	statement := `SELECT ` + userColumns + ` FROM users WHERE ` + strings.Join(where, " AND ") +
+		` ORDER BY ` + strings.Join(order, ", ") +
+		` LIMIT ` + b.arg(query.Limit+1)
+
+	return statement, b.args
+}
+
+// Count counts the live users of tenant matching query.Filter.
+func Count(tenant string, query storage.ListQuery, placeholder Placeholder) (string, []any) {
+	b := &builder{placeholder: placeholder}
+	where := b.where(tenant, query.Filter)
+
+	return `SELECT count(*) FROM users WHERE ` + strings.Join(where, " AND "), b.args
+}
+
+func (b *builder) where(tenant string, filter []storage.Condition) []string {
+	where := []string{`tenant_id = ` + b.arg(tenant), `deleted_at IS NULL`}
+	for _, condition := range filter {
+		where = append(where, b.condition(condition))
+	}
+	return where
+}
+
+// condition compares text case-insensitively with lower, which only folds
+// ASCII letters on sqlite.
+func (b *builder) condition(c storage.Condition) string {
+	column := columns[c.Field]
+	switch c.Op {
+	case storage.OpPrefix:
+		return `lower(` + column + `) LIKE lower(` + b.arg(escapeLike(c.Value.(string))+"%") + `) ESCAPE '\'`
+	case storage.OpDomain:
+		return `lower(` + column + `) LIKE lower(` + b.arg("%@"+escapeLike(c.Value.(string))) + `) ESCAPE '\'`
+	}
+	switch v := c.Value.(type) {
+	case string:
+		return `lower(` + column + `) = lower(` + b.arg(v) + `)`
+	case storage.TimeRange:
+		return `(` + column + ` >= ` + b.arg(v.From) + ` AND ` + column + ` < ` + b.arg(v.To) + `)`
+	}
+	return column + ` ` + operators[c.Op] + ` ` + b.arg(c.Value)
+}
+
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+// keyset selects the users after the cursor in the order of keys, or
+// before it when backward. Keys sorted in the same direction compare as one
+// row value; mixed directions expand to
+// a > x OR (a = x AND b < y) OR (a = x AND b = y AND c > z) ...
+func (b *builder) keyset(keys []storage.SortField, cursor *storage.Cursor, backward bool) string {
+	operator := func(key storage.SortField) string {
+		if key.Desc != backward {
+			return "<"
+		}
+		return ">"
+	}
+
+	uniform := true
+	for _, key := range keys {
+		uniform = uniform && operator(key) == operator(keys[0])
+	}
+	if uniform {
+		cols := make([]string, len(keys))
+		params := make([]string, len(keys))
+		for i, key := range keys {
+			cols[i] = columns[key.Field]
+			params[i] = b.arg(cursor.Values[i])
+		}
+		return `(` + strings.Join(cols, ", ") + `) ` + operator(keys[0]) + ` (` + strings.Join(params, ", ") + `)`
+	}
+
+	alternatives := make([]string, len(keys))
+	for i := range keys {
+		terms := make([]string, 0, i+1)
+		for j := 0; j < i; j++ {
+			terms = append(terms, columns[keys[j].Field]+` = `+b.arg(cursor.Values[j]))
+		}
+		terms = append(terms, columns[keys[i].Field]+` `+operator(keys[i])+` `+b.arg(cursor.Values[i]))
+		alternatives[i] = `(` + strings.Join(terms, " AND ") + `)`
+	}
+	return `(` + strings.Join(alternatives, " OR ") + `)`
+}
@@ -0,0 +1,510 @@
+package memory
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"github.com/google/uuid"
+	"slices"
+	"strings"
+	"sync"
+	"test_golang_user_api/internal/storage"
+	"test_golang_user_api/internal/storage/fuzzy"
+	"time"
+)
+
+// Storage keeps everything in memory for tests and local runs. Like the SQL
+// stores it keeps the history of every user for as long as it lives, but it
+// drops outbox events once they are sent, keeping only pending and dead
+// ones.
+type Storage struct {
+	mu        sync.RWMutex
+	tenants   map[string]storage.Tenant
+	users     map[uuid.UUID]storage.UserDto
+	byEmail   map[string]uuid.UUID
+	audit     map[auditKey][]storage.AuditEntry
+	lastAudit int64
+	lastEvent int64
+	outbox    []outboxEntry
+}
+
+type auditKey struct {
+	tenant string
+	user   uuid.UUID
+}
+
+type outboxEntry struct {
+	event       storage.Event
+	status      string
+	nextAttempt time.Time
+	lastErr     string
+}
+
+const (
+	eventPending = "pending"
+	eventDead    = "dead"
+)
+
+func New() *Storage {
+	return &Storage{
+		tenants: map[string]storage.Tenant{
+			storage.DefaultTenant: {ID: storage.DefaultTenant, Name: "Default", Created: time.Now().UTC()},
+		},
+		users:   make(map[uuid.UUID]storage.UserDto),
+		byEmail: make(map[string]uuid.UUID),
+		audit:   make(map[auditKey][]storage.AuditEntry),
+	}
+}
+
+func (s *Storage) CreateUser(ctx context.Context, user *storage.UserDto) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if err := validate(user); err != nil {
+		return fmt.Errorf("failed to insert user: %w", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tenant := storage.TenantFrom(ctx)
+	if _, ok := s.tenants[tenant]; !ok {
+		return fmt.Errorf("failed to insert user: %w", storage.ErrConstraintViolation)
+	}
+	if _, ok := s.users[user.ID]; ok {
+		return fmt.Errorf("failed to insert user: %w", storage.ErrConstraintViolation)
+	}
+	if _, ok := s.byEmail[emailKey(tenant, user.Email)]; ok {
+		return fmt.Errorf("failed to insert user: %w", storage.ErrEmailConflict)
+	}
+
+	stored := *user
+	stored.Tenant = tenant
+	stored.Created = stored.Created.UTC()
+	stored.Updated = stored.Updated.UTC()
+	stored.Version = 1
+	stored.Deleted = nil
+
+	if err := s.recordLocked(ctx, storage.AuditCreate, nil, &stored); err != nil {
+		return err
+	}
+
+	s.users[user.ID] = stored
+	s.byEmail[emailKey(tenant, user.Email)] = user.ID
+	user.Tenant = tenant
+
+	return nil
+}
+
+func (s *Storage) GetUser(ctx context.Context, id uuid.UUID) (*storage.UserDto, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	user, ok := s.lookupLocked(ctx, id)
+	if !ok || user.Deleted != nil {
+		return nil, storage.ErrUserNotFound
+	}
+
+	return &user, nil
+}
+
+func (s *Storage) GetUserIncludingDeleted(ctx context.Context, id uuid.UUID) (*storage.UserDto, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	user, ok := s.lookupLocked(ctx, id)
+	if !ok {
+		return nil, storage.ErrUserNotFound
+	}
+
+	return &user, nil
+}
+
+func (s *Storage) GetUserByEmail(ctx context.Context, email string) (*storage.UserDto, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	id, ok := s.byEmail[emailKey(storage.TenantFrom(ctx), email)]
+	if !ok {
+		return nil, storage.ErrUserNotFound
+	}
+	user, ok := s.lookupLocked(ctx, id)
+	if !ok || user.Deleted != nil {
+		return nil, storage.ErrUserNotFound
+	}
+
+	return &user, nil
+}
+
+func (s *Storage) ListUsers(ctx context.Context, query storage.ListQuery) (*storage.UserPage, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if err := query.Validate(); err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	tenant := storage.TenantFrom(ctx)
+	var users []storage.UserDto
+	for _, user := range s.users {
+		if user.Tenant != tenant || user.Deleted != nil || !matches(user, query.Filter) {
+			continue
+		}
+		users = append(users, user)
+	}
+	total := int64(len(users))
+
+	slices.SortFunc(users, query.Sort.Compare)
+	if query.Before != nil {
+		slices.Reverse(users)
+	}
+
+	matched := make([]storage.UserDto, 0, query.Limit+1)
+	for _, user := range users {
+		switch {
+		case query.After != nil && query.After.CompareUser(user) <= 0:
+			continue
+		case query.Before != nil && query.Before.CompareUser(user) >= 0:
+			continue
+		}
+		matched = append(matched, user)
+		if len(matched) > query.Limit {
+			break
+		}
+	}
+
+	page := storage.NewUserPage(query, matched)
+	if query.WithTotal {
+		page.Total = &total
+	}
+
+	return page, nil
+}
+
+func (s *Storage) SearchUsers(ctx context.Context, query string, limit int) ([]storage.SearchResult, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	tenant := storage.TenantFrom(ctx)
+	var users []storage.UserDto
+	for _, user := range s.users {
+		if user.Tenant == tenant && user.Deleted == nil {
+			users = append(users, user)
+		}
+	}
+
+	return fuzzy.Rank(query, users, limit), nil
+}
+
+func (s *Storage) EditUser(ctx context.Context, id uuid.UUID, patch storage.UserPatch, version int) (*storage.UserDto, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	current, ok := s.lookupLocked(ctx, id)
+	if !ok || current.Deleted != nil {
+		return nil, storage.ErrUserNotFound
+	}
+	if version != 0 && version != current.Version {
+		return nil, storage.ErrVersionConflict
+	}
+	if patch.Empty() {
+		return &current, nil
+	}
+
+	before := current
+	current = patch.Apply(current)
+	if err := validate(&current); err != nil {
+		return nil, fmt.Errorf("failed to update user: %w", err)
+	}
+	if owner, ok := s.byEmail[emailKey(current.Tenant, current.Email)]; ok && owner != id {
+		return nil, fmt.Errorf("failed to update user: %w", storage.ErrEmailConflict)
+	}
+
+	current.Updated = time.Now().UTC()
+	current.Version++
+
+	if err := s.recordLocked(ctx, storage.AuditEdit, &before, &current); err != nil {
+		return nil, err
+	}
+
+	delete(s.byEmail, emailKey(before.Tenant, before.Email))
+	s.users[id] = current
+	s.byEmail[emailKey(current.Tenant, current.Email)] = current.ID
+
+	return &current, nil
+}
+
+func (s *Storage) DeleteUser(ctx context.Context, id uuid.UUID, version int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, ok := s.lookupLocked(ctx, id)
+	if !ok || user.Deleted != nil {
+		return storage.ErrUserNotFound
+	}
+	if version != 0 && version != user.Version {
+		return storage.ErrVersionConflict
+	}
+
+	before := user
+	deleted := time.Now().UTC()
+	user.Deleted = &deleted
+	user.Updated = deleted
+	user.Version++
+
+	if err := s.recordLocked(ctx, storage.AuditDelete, &before, &user); err != nil {
+		return err
+	}
+
+	s.users[id] = user
+
+	return nil
+}
+
+func (s *Storage) RestoreUser(ctx context.Context, id uuid.UUID) (*storage.UserDto, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, ok := s.lookupLocked(ctx, id)
+	if !ok || user.Deleted == nil {
+		return nil, storage.ErrUserNotFound
+	}
+
+	before := user
+	user.Deleted = nil
+	user.Updated = time.Now().UTC()
+	user.Version++
+
+	if err := s.recordLocked(ctx, storage.AuditRestore, &before, &user); err != nil {
+		return nil, err
+	}
+
+	s.users[id] = user
+
+	return &user, nil
+}
+
+func (s *Storage) PurgeDeleted(ctx context.Context, before time.Time) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var purged int64
+	for id, user := range s.users {
+		if user.Deleted != nil && user.Deleted.Before(before) {
+			delete(s.users, id)
+			delete(s.byEmail, emailKey(user.Tenant, user.Email))
+			purged++
+		}
+	}
+
+	return purged, nil
+}
+
+func (s *Storage) UserHistory(ctx context.Context, id uuid.UUID, limit, offset int) ([]storage.AuditEntry, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	entries := s.audit[auditKey{tenant: storage.TenantFrom(ctx), user: id}]
+	result := make([]storage.AuditEntry, 0, limit)
+	for i := len(entries) - 1 - offset; i >= 0 && len(result) < limit; i-- {
+		result = append(result, entries[i])
+	}
+
+	return result, nil
+}
+
+// recordLocked appends an audit entry; callers must hold s.mu for writing.
+func (s *Storage) recordLocked(ctx context.Context, operation string, before, after *storage.UserDto) error {
+	beforeJSON, err := storage.Snapshot(before)
+	if err != nil {
+		return fmt.Errorf("failed to encode audit snapshot: %w", err)
+	}
+	afterJSON, err := storage.Snapshot(after)
+	if err != nil {
+		return fmt.Errorf("failed to encode audit snapshot: %w", err)
+	}
+
+	s.lastAudit++
+	key := auditKey{tenant: after.Tenant, user: after.ID}
+	s.audit[key] = append(s.audit[key], storage.AuditEntry{
+		ID:        s.lastAudit,
+		UserID:    after.ID,
+		Actor:     storage.ActorFrom(ctx),
+		Operation: operation,
+		Before:    beforeJSON,
+		After:     afterJSON,
+		Created:   time.Now().UTC(),
+	})
+
+	now := time.Now().UTC()
+	s.lastEvent++
+	s.outbox = append(s.outbox, outboxEntry{
+		event: storage.Event{
+			ID:      s.lastEvent,
+			Tenant:  after.Tenant,
+			Type:    storage.EventType(operation),
+			UserID:  after.ID,
+			Payload: afterJSON,
+			Created: now,
+		},
+		status:      eventPending,
+		nextAttempt: now,
+	})
+
+	return nil
+}
+
+func (s *Storage) ClaimEvents(ctx context.Context, limit int, lease time.Duration) ([]storage.Event, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	var events []storage.Event
+	for i := range s.outbox {
+		if len(events) >= limit {
+			break
+		}
+		entry := &s.outbox[i]
+		if entry.status != eventPending || entry.nextAttempt.After(now) {
+			continue
+		}
+		entry.event.Attempts++
+		entry.nextAttempt = now.Add(lease)
+		events = append(events, entry.event)
+	}
+
+	return events, nil
+}
+
+func (s *Storage) MarkEventSent(ctx context.Context, id int64) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i, ok := s.findEventLocked(id)
+	if !ok {
+		return fmt.Errorf("event %d not found", id)
+	}
+	s.outbox = slices.Delete(s.outbox, i, i+1)
+
+	return nil
+}
+
+func (s *Storage) RetryEvent(ctx context.Context, id int64, lastErr string, retryAt time.Time) error {
+	return s.updateEvent(ctx, id, func(entry *outboxEntry) {
+		entry.lastErr = lastErr
+		entry.nextAttempt = retryAt
+	})
+}
+
+func (s *Storage) DeadLetterEvent(ctx context.Context, id int64, lastErr string) error {
+	return s.updateEvent(ctx, id, func(entry *outboxEntry) {
+		entry.status = eventDead
+		entry.lastErr = lastErr
+	})
+}
+
+func (s *Storage) updateEvent(ctx context.Context, id int64, update func(entry *outboxEntry)) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i, ok := s.findEventLocked(id)
+	if !ok {
+		return fmt.Errorf("event %d not found", id)
+	}
+	update(&s.outbox[i])
+
+	return nil
+}
+
+// findEventLocked returns the index of the event with id in s.outbox, which
+// is ordered by id; callers must hold s.mu.
+func (s *Storage) findEventLocked(id int64) (int, bool) {
+	return slices.BinarySearchFunc(s.outbox, id, func(entry outboxEntry, id int64) int {
+		return cmp.Compare(entry.event.ID, id)
+	})
+}
+
+// lookupLocked returns the user with id if it belongs to the tenant of ctx;
+// callers must hold s.mu.
+func (s *Storage) lookupLocked(ctx context.Context, id uuid.UUID) (storage.UserDto, bool) {
+	user, ok := s.users[id]
+	if !ok || user.Tenant != storage.TenantFrom(ctx) {
+		return storage.UserDto{}, false
+	}
+	return user, true
+}
+
+func matches(user storage.UserDto, filter []storage.Condition) bool {
+	for _, condition := range filter {
+		if !condition.Match(user) {
+			return false
+		}
+	}
+	return true
+}
+
+// emailKey makes email uniqueness case-insensitive and per tenant, like the
+// (tenant_id, lower(email)) index of the SQL stores.
+func emailKey(tenant, email string) string {
+	return tenant + "\x00" + strings.ToLower(email)
+}
+
+// validate mirrors the CHECK constraints of the users table.
+func validate(user *storage.UserDto) error {
+	if user.Age < 0 {
+		return storage.ErrConstraintViolation
+	}
+	return nil
+}
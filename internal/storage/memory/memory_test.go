@@ -0,0 +1,462 @@
+package memory
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"test_golang_user_api/internal/storage"
+	"test_golang_user_api/internal/storage/storagetest"
+	"testing"
+	"time"
+)
+
+func newTestUser(email string) *storage.UserDto {
+	return storage.NewUser(uuid.New(), "Ivan", "Ivanov", email, 30)
+}
+
+func TestStorageCreateUser(t *testing.T) {
+	t.Run("success save user", func(t *testing.T) {
+		//given
+		store := New()
+		user := newTestUser("ivan@gmail.com")
+		//when
+		err := store.CreateUser(context.Background(), user)
+		//then
+		require.NoError(t, err)
+		saved, err := store.GetUser(context.Background(), user.ID)
+		require.NoError(t, err)
+		assert.Equal(t, user, saved)
+	})
+
+	t.Run("returns email conflict for duplicate email", func(t *testing.T) {
+		//given
+		store := New()
+		require.NoError(t, store.CreateUser(context.Background(), newTestUser("ivan@gmail.com")))
+		//when
+		err := store.CreateUser(context.Background(), newTestUser("ivan@gmail.com"))
+		//then
+		require.ErrorIs(t, err, storage.ErrEmailConflict)
+	})
+
+	t.Run("returns constraint violation for negative age", func(t *testing.T) {
+		//given
+		store := New()
+		user := newTestUser("ivan@gmail.com")
+		user.Age = -1
+		//when
+		err := store.CreateUser(context.Background(), user)
+		//then
+		require.ErrorIs(t, err, storage.ErrConstraintViolation)
+	})
+
+	t.Run("returns email conflict for email differing in case", func(t *testing.T) {
+		//given
+		store := New()
+		require.NoError(t, store.CreateUser(context.Background(), newTestUser("ivan@gmail.com")))
+		//when
+		err := store.CreateUser(context.Background(), newTestUser("IVAN@Gmail.com"))
+		//then
+		require.ErrorIs(t, err, storage.ErrEmailConflict)
+	})
+
+	t.Run("allows changing case of own email", func(t *testing.T) {
+		//given
+		store := New()
+		user := newTestUser("ivan@gmail.com")
+		require.NoError(t, store.CreateUser(context.Background(), user))
+		//when
+		updated, err := store.EditUser(context.Background(), user.ID, storage.NewUser(user.ID, "Ivan", "Ivanov", "Ivan@gmail.com", 30).Patch(), 0)
+		//then
+		require.NoError(t, err)
+		assert.Equal(t, "Ivan@gmail.com", updated.Email)
+	})
+}
+
+func TestStorageGetUser(t *testing.T) {
+	t.Run("returns error when user not found", func(t *testing.T) {
+		//given
+		store := New()
+		//when
+		user, err := store.GetUser(context.Background(), uuid.New())
+		//then
+		require.Nil(t, user)
+		require.ErrorIs(t, err, storage.ErrUserNotFound)
+	})
+}
+
+func TestStorageEditUser(t *testing.T) {
+	t.Run("success edit user and keeps created", func(t *testing.T) {
+		//given
+		store := New()
+		user := newTestUser("ivan@gmail.com")
+		require.NoError(t, store.CreateUser(context.Background(), user))
+		edit := storage.NewUser(user.ID, "Petr", "Petrov", "petr@gmail.com", 40)
+		//when
+		updated, err := store.EditUser(context.Background(), edit.ID, edit.Patch(), edit.Version)
+		//then
+		require.NoError(t, err)
+		assert.Equal(t, "Petr", updated.Firstname)
+		assert.Equal(t, "petr@gmail.com", updated.Email)
+		assert.Equal(t, user.Created, updated.Created)
+		require.NoError(t, store.CreateUser(context.Background(), newTestUser("ivan@gmail.com")))
+	})
+
+	t.Run("returns email conflict when email taken by another user", func(t *testing.T) {
+		//given
+		store := New()
+		first := newTestUser("ivan@gmail.com")
+		second := newTestUser("petr@gmail.com")
+		require.NoError(t, store.CreateUser(context.Background(), first))
+		require.NoError(t, store.CreateUser(context.Background(), second))
+		second.Email = first.Email
+		//when
+		updated, err := store.EditUser(context.Background(), second.ID, second.Patch(), second.Version)
+		//then
+		require.Nil(t, updated)
+		require.ErrorIs(t, err, storage.ErrEmailConflict)
+	})
+
+	t.Run("tracks updated time in UTC", func(t *testing.T) {
+		//given
+		store := New()
+		user := newTestUser("ivan@gmail.com")
+		user.Created = time.Now().Add(-time.Hour).In(time.FixedZone("MSK", 3*60*60))
+		user.Updated = user.Created
+		require.NoError(t, store.CreateUser(context.Background(), user))
+		//when
+		updated, err := store.EditUser(context.Background(), user.ID, storage.NewUser(user.ID, "Petr", "Petrov", "petr@gmail.com", 40).Patch(), 0)
+		//then
+		require.NoError(t, err)
+		saved, err := store.GetUser(context.Background(), user.ID)
+		require.NoError(t, err)
+		assert.Equal(t, time.UTC, saved.Created.Location())
+		assert.Equal(t, time.UTC, saved.Updated.Location())
+		assert.True(t, user.Created.Equal(saved.Created))
+		assert.True(t, saved.Updated.After(saved.Created))
+		assert.True(t, updated.Updated.Equal(saved.Updated))
+	})
+
+	t.Run("returns version conflict for stale version", func(t *testing.T) {
+		//given
+		store := New()
+		user := newTestUser("ivan@gmail.com")
+		require.NoError(t, store.CreateUser(context.Background(), user))
+		first, err := store.EditUser(context.Background(), user.ID, storage.NewUser(user.ID, "Petr", "Petrov", "petr@gmail.com", 40).Patch(), 0)
+		require.NoError(t, err)
+		require.Equal(t, 2, first.Version)
+		stale := storage.NewUser(user.ID, "Ivan", "Ivanov", "ivan@gmail.com", 30)
+		stale.Version = 1
+		//when
+		updated, err := store.EditUser(context.Background(), stale.ID, stale.Patch(), stale.Version)
+		//then
+		require.Nil(t, updated)
+		require.ErrorIs(t, err, storage.ErrVersionConflict)
+		require.ErrorIs(t, store.DeleteUser(context.Background(), user.ID, 1), storage.ErrVersionConflict)
+		require.NoError(t, store.DeleteUser(context.Background(), user.ID, 2))
+	})
+
+	t.Run("returns error when user not found", func(t *testing.T) {
+		//given
+		store := New()
+		user := newTestUser("ivan@gmail.com")
+		//when
+		updated, err := store.EditUser(context.Background(), user.ID, user.Patch(), 0)
+		//then
+		require.Nil(t, updated)
+		require.ErrorIs(t, err, storage.ErrUserNotFound)
+	})
+}
+
+func TestStorageDeleteUser(t *testing.T) {
+	t.Run("success delete user", func(t *testing.T) {
+		//given
+		store := New()
+		user := newTestUser("ivan@gmail.com")
+		require.NoError(t, store.CreateUser(context.Background(), user))
+		//when
+		err := store.DeleteUser(context.Background(), user.ID, 0)
+		//then
+		require.NoError(t, err)
+		_, err = store.GetUser(context.Background(), user.ID)
+		require.ErrorIs(t, err, storage.ErrUserNotFound)
+	})
+
+	t.Run("returns error when user not found", func(t *testing.T) {
+		//given
+		store := New()
+		//when
+		err := store.DeleteUser(context.Background(), uuid.New(), 0)
+		//then
+		require.ErrorIs(t, err, storage.ErrUserNotFound)
+	})
+}
+
+func TestStorageSoftDelete(t *testing.T) {
+	t.Run("deleted user is hidden until restored", func(t *testing.T) {
+		//given
+		store := New()
+		user := newTestUser("ivan@gmail.com")
+		require.NoError(t, store.CreateUser(context.Background(), user))
+		require.NoError(t, store.DeleteUser(context.Background(), user.ID, 0))
+		//when
+		_, getErr := store.GetUser(context.Background(), user.ID)
+		_, editErr := store.EditUser(context.Background(), user.ID, user.Patch(), user.Version)
+		deleted, includeErr := store.GetUserIncludingDeleted(context.Background(), user.ID)
+		restored, restoreErr := store.RestoreUser(context.Background(), user.ID)
+		//then
+		require.ErrorIs(t, getErr, storage.ErrUserNotFound)
+		require.ErrorIs(t, editErr, storage.ErrUserNotFound)
+		require.NoError(t, includeErr)
+		require.NotNil(t, deleted.Deleted)
+		require.NoError(t, restoreErr)
+		assert.Nil(t, restored.Deleted)
+		assert.Equal(t, 3, restored.Version)
+		_, err := store.GetUser(context.Background(), user.ID)
+		require.NoError(t, err)
+	})
+
+	t.Run("restore fails for user that is not deleted", func(t *testing.T) {
+		//given
+		store := New()
+		user := newTestUser("ivan@gmail.com")
+		require.NoError(t, store.CreateUser(context.Background(), user))
+		//when
+		restored, err := store.RestoreUser(context.Background(), user.ID)
+		//then
+		require.Nil(t, restored)
+		require.ErrorIs(t, err, storage.ErrUserNotFound)
+	})
+
+	t.Run("purge removes only users deleted before cutoff", func(t *testing.T) {
+		//given
+		store := New()
+		old := newTestUser("old@gmail.com")
+		active := newTestUser("active@gmail.com")
+		require.NoError(t, store.CreateUser(context.Background(), old))
+		require.NoError(t, store.CreateUser(context.Background(), active))
+		require.NoError(t, store.DeleteUser(context.Background(), old.ID, 0))
+		//when
+		purged, err := store.PurgeDeleted(context.Background(), time.Now().Add(time.Minute))
+		//then
+		require.NoError(t, err)
+		assert.Equal(t, int64(1), purged)
+		_, err = store.GetUserIncludingDeleted(context.Background(), old.ID)
+		require.ErrorIs(t, err, storage.ErrUserNotFound)
+		_, err = store.GetUser(context.Background(), active.ID)
+		require.NoError(t, err)
+	})
+}
+
+func TestStorageUserHistory(t *testing.T) {
+	t.Run("records every change newest first", func(t *testing.T) {
+		//given
+		store := New()
+		ctx := storage.WithActor(context.Background(), "admin")
+		user := newTestUser("ivan@gmail.com")
+		require.NoError(t, store.CreateUser(ctx, user))
+		_, err := store.EditUser(ctx, user.ID, storage.NewUser(user.ID, "Ivan", "Ivanov", "petr@gmail.com", 30).Patch(), 0)
+		require.NoError(t, err)
+		require.NoError(t, store.DeleteUser(ctx, user.ID, 0))
+		_, err = store.RestoreUser(ctx, user.ID)
+		require.NoError(t, err)
+		//when
+		entries, err := store.UserHistory(context.Background(), user.ID, 10, 0)
+		page, pageErr := store.UserHistory(context.Background(), user.ID, 2, 1)
+		//then
+		require.NoError(t, err)
+		require.NoError(t, pageErr)
+		require.Len(t, entries, 4)
+		assert.Equal(t, storage.AuditRestore, entries[0].Operation)
+		assert.Equal(t, storage.AuditDelete, entries[1].Operation)
+		assert.Equal(t, storage.AuditEdit, entries[2].Operation)
+		assert.Equal(t, storage.AuditCreate, entries[3].Operation)
+		assert.Equal(t, "admin", entries[0].Actor)
+		assert.Nil(t, entries[3].Before)
+		assert.Contains(t, string(entries[2].Before), "ivan@gmail.com")
+		assert.Contains(t, string(entries[2].After), "petr@gmail.com")
+		require.Len(t, page, 2)
+		assert.Equal(t, storage.AuditDelete, page[0].Operation)
+		assert.Equal(t, storage.AuditEdit, page[1].Operation)
+	})
+}
+
+func TestStorageOutbox(t *testing.T) {
+	t.Run("claims events in order and hides leased events", func(t *testing.T) {
+		//given
+		store := New()
+		user := newTestUser("ivan@gmail.com")
+		require.NoError(t, store.CreateUser(context.Background(), user))
+		require.NoError(t, store.DeleteUser(context.Background(), user.ID, 0))
+		//when
+		events, err := store.ClaimEvents(context.Background(), 10, time.Minute)
+		//then
+		require.NoError(t, err)
+		require.Len(t, events, 2)
+		assert.Equal(t, storage.EventUserCreated, events[0].Type)
+		assert.Equal(t, storage.EventUserDeleted, events[1].Type)
+		assert.Equal(t, user.ID, events[0].UserID)
+		assert.Equal(t, 1, events[0].Attempts)
+
+		again, err := store.ClaimEvents(context.Background(), 10, time.Minute)
+		require.NoError(t, err)
+		assert.Empty(t, again)
+	})
+
+	t.Run("sent events are not claimed again", func(t *testing.T) {
+		//given
+		store := New()
+		require.NoError(t, store.CreateUser(context.Background(), newTestUser("ivan@gmail.com")))
+		events, err := store.ClaimEvents(context.Background(), 10, 0)
+		require.NoError(t, err)
+		require.Len(t, events, 1)
+		//when
+		require.NoError(t, store.MarkEventSent(context.Background(), events[0].ID))
+		//then
+		again, err := store.ClaimEvents(context.Background(), 10, 0)
+		require.NoError(t, err)
+		assert.Empty(t, again)
+	})
+
+	t.Run("drops sent events and keeps ids of later ones", func(t *testing.T) {
+		//given
+		store := New()
+		require.NoError(t, store.CreateUser(context.Background(), newTestUser("ivan@gmail.com")))
+		require.NoError(t, store.CreateUser(context.Background(), newTestUser("petr@gmail.com")))
+		events, err := store.ClaimEvents(context.Background(), 10, 0)
+		require.NoError(t, err)
+		require.Len(t, events, 2)
+		//when
+		require.NoError(t, store.MarkEventSent(context.Background(), events[0].ID))
+		require.NoError(t, store.CreateUser(context.Background(), newTestUser("anna@gmail.com")))
+		//then
+		assert.Len(t, store.outbox, 2)
+		require.Error(t, store.MarkEventSent(context.Background(), events[0].ID))
+		again, err := store.ClaimEvents(context.Background(), 10, 0)
+		require.NoError(t, err)
+		require.Len(t, again, 2)
+		assert.Equal(t, events[1].ID, again[0].ID)
+		assert.Equal(t, events[1].ID+1, again[1].ID)
+	})
+
+	t.Run("retried events are claimed again when due", func(t *testing.T) {
+		//given
+		store := New()
+		require.NoError(t, store.CreateUser(context.Background(), newTestUser("ivan@gmail.com")))
+		events, err := store.ClaimEvents(context.Background(), 10, time.Minute)
+		require.NoError(t, err)
+		require.Len(t, events, 1)
+		//when
+		require.NoError(t, store.RetryEvent(context.Background(), events[0].ID, "broker down", time.Now().Add(-time.Second)))
+		//then
+		again, err := store.ClaimEvents(context.Background(), 10, time.Minute)
+		require.NoError(t, err)
+		require.Len(t, again, 1)
+		assert.Equal(t, 2, again[0].Attempts)
+	})
+
+	t.Run("dead events are not claimed again", func(t *testing.T) {
+		//given
+		store := New()
+		require.NoError(t, store.CreateUser(context.Background(), newTestUser("ivan@gmail.com")))
+		events, err := store.ClaimEvents(context.Background(), 10, 0)
+		require.NoError(t, err)
+		require.Len(t, events, 1)
+		//when
+		require.NoError(t, store.DeadLetterEvent(context.Background(), events[0].ID, "broker down"))
+		//then
+		again, err := store.ClaimEvents(context.Background(), 10, 0)
+		require.NoError(t, err)
+		assert.Empty(t, again)
+	})
+}
+
+func TestStorageTenants(t *testing.T) {
+	t.Run("scopes users to their tenant", func(t *testing.T) {
+		//given
+		store := New()
+		require.NoError(t, store.CreateTenant(context.Background(), &storage.Tenant{ID: "acme", Name: "Acme"}))
+		acme := storage.WithTenant(context.Background(), "acme")
+		user := newTestUser("ivan@gmail.com")
+		require.NoError(t, store.CreateUser(acme, user))
+		//when
+		_, otherErr := store.GetUser(context.Background(), user.ID)
+		_, editErr := store.EditUser(context.Background(), user.ID, storage.NewUser(user.ID, "Petr", "Petrov", "petr@gmail.com", 30).Patch(), 0)
+		saved, err := store.GetUser(acme, user.ID)
+		//then
+		require.ErrorIs(t, otherErr, storage.ErrUserNotFound)
+		require.ErrorIs(t, editErr, storage.ErrUserNotFound)
+		require.NoError(t, err)
+		assert.Equal(t, "acme", saved.Tenant)
+		assert.Equal(t, "acme", user.Tenant)
+	})
+
+	t.Run("allows same email in different tenants", func(t *testing.T) {
+		//given
+		store := New()
+		require.NoError(t, store.CreateTenant(context.Background(), &storage.Tenant{ID: "acme", Name: "Acme"}))
+		require.NoError(t, store.CreateUser(context.Background(), newTestUser("ivan@gmail.com")))
+		//when
+		err := store.CreateUser(storage.WithTenant(context.Background(), "acme"), newTestUser("IVAN@gmail.com"))
+		//then
+		require.NoError(t, err)
+	})
+
+	t.Run("records tenant of events", func(t *testing.T) {
+		//given
+		store := New()
+		require.NoError(t, store.CreateTenant(context.Background(), &storage.Tenant{ID: "acme", Name: "Acme"}))
+		require.NoError(t, store.CreateUser(storage.WithTenant(context.Background(), "acme"), newTestUser("ivan@gmail.com")))
+		//when
+		events, err := store.ClaimEvents(context.Background(), 10, time.Minute)
+		//then
+		require.NoError(t, err)
+		require.Len(t, events, 1)
+		assert.Equal(t, "acme", events[0].Tenant)
+	})
+
+	t.Run("rejects users of unknown tenant", func(t *testing.T) {
+		//given
+		store := New()
+		//when
+		err := store.CreateUser(storage.WithTenant(context.Background(), "acme"), newTestUser("ivan@gmail.com"))
+		//then
+		require.ErrorIs(t, err, storage.ErrConstraintViolation)
+	})
+
+	t.Run("returns tenant exists for duplicate id", func(t *testing.T) {
+		//given
+		store := New()
+		//when
+		err := store.CreateTenant(context.Background(), &storage.Tenant{ID: storage.DefaultTenant, Name: "Other"})
+		//then
+		require.ErrorIs(t, err, storage.ErrTenantExists)
+	})
+
+	t.Run("lists and disables tenants", func(t *testing.T) {
+		//given
+		store := New()
+		require.NoError(t, store.CreateTenant(context.Background(), &storage.Tenant{ID: "acme", Name: "Acme"}))
+		//when
+		disabled, err := store.DisableTenant(context.Background(), "acme")
+		require.NoError(t, err)
+		again, err := store.DisableTenant(context.Background(), "acme")
+		require.NoError(t, err)
+		tenants, listErr := store.ListTenants(context.Background())
+		_, missingErr := store.GetTenant(context.Background(), "missing")
+		//then
+		require.NotNil(t, disabled.Disabled)
+		assert.True(t, disabled.Disabled.Equal(*again.Disabled))
+		require.NoError(t, listErr)
+		require.Len(t, tenants, 2)
+		assert.Equal(t, "acme", tenants[0].ID)
+		assert.Equal(t, storage.DefaultTenant, tenants[1].ID)
+		require.ErrorIs(t, missingErr, storage.ErrTenantNotFound)
+	})
+}
+
+func TestConformance(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) storage.UserStorage {
+		return New()
+	})
+}
@@ -0,0 +1,83 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"test_golang_user_api/internal/storage"
+	"time"
+)
+
+func (s *Storage) CreateTenant(ctx context.Context, tenant *storage.Tenant) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.tenants[tenant.ID]; ok {
+		return fmt.Errorf("failed to insert tenant: %w", storage.ErrTenantExists)
+	}
+
+	tenant.Created = time.Now().UTC()
+	tenant.Disabled = nil
+	s.tenants[tenant.ID] = *tenant
+
+	return nil
+}
+
+func (s *Storage) GetTenant(ctx context.Context, id string) (*storage.Tenant, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	tenant, ok := s.tenants[id]
+	if !ok {
+		return nil, storage.ErrTenantNotFound
+	}
+
+	return &tenant, nil
+}
+
+func (s *Storage) ListTenants(ctx context.Context) ([]storage.Tenant, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	tenants := make([]storage.Tenant, 0, len(s.tenants))
+	for _, tenant := range s.tenants {
+		tenants = append(tenants, tenant)
+	}
+	sort.Slice(tenants, func(i, j int) bool { return tenants[i].ID < tenants[j].ID })
+
+	return tenants, nil
+}
+
+// DisableTenant keeps the time a tenant was first disabled.
+func (s *Storage) DisableTenant(ctx context.Context, id string) (*storage.Tenant, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tenant, ok := s.tenants[id]
+	if !ok {
+		return nil, storage.ErrTenantNotFound
+	}
+	if tenant.Disabled == nil {
+		disabled := time.Now().UTC()
+		tenant.Disabled = &disabled
+		s.tenants[id] = tenant
+	}
+
+	return &tenant, nil
+}
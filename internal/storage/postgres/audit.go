@@ -0,0 +1,96 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+	"github.com/google/uuid"
+	"test_golang_user_api/internal/storage"
+	"time"
+)
+
+func writeAudit(ctx context.Context, tx txn, operation string, before, after *storage.UserDto) error {
+	userID := after.ID
+
+	beforeJSON, err := storage.Snapshot(before)
+	if err != nil {
+		return fmt.Errorf("failed to encode audit snapshot: %w", err)
+	}
+	afterJSON, err := storage.Snapshot(after)
+	if err != nil {
+		return fmt.Errorf("failed to encode audit snapshot: %w", err)
+	}
+
+	query := `INSERT INTO user_audit (user_id, actor, operation, before, after, created, tenant_id)
+	          VALUES ($1, $2, $3, $4, $5, $6, $7)`
+
+	qctx, done := tx.obs.start(ctx, "insert_audit")
+	result, err := tx.ExecContext(qctx, query, userID, storage.ActorFrom(ctx), operation, jsonArg(beforeJSON), jsonArg(afterJSON), time.Now().UTC(), tx.tenant)
+	done(affected(result, err), err)
+	if err != nil {
+		return fmt.Errorf("failed to write audit entry: %w", mapError(err))
+	}
+
+	return nil
+}
+
+// jsonArg passes a snapshot as text so lib/pq does not send it as bytea.
+func jsonArg(raw []byte) any {
+	if raw == nil {
+		return nil
+	}
+	return string(raw)
+}
+
+func (s *Storage) UserHistory(ctx context.Context, id uuid.UUID, limit, offset int) ([]storage.AuditEntry, error) {
+	ctx, cancel := storage.WithTimeout(ctx, s.timeouts.Get)
+	defer cancel()
+
+	var entries []storage.AuditEntry
+	err := s.withRetry(ctx, s.retry.MaxAttempts, func() error {
+		return s.readTx(ctx, func(tx txn) error {
+			var err error
+			entries, err = queryHistory(ctx, tx, id, limit, offset)
+			return err
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
+
+func queryHistory(ctx context.Context, tx txn, id uuid.UUID, limit, offset int) (entries []storage.AuditEntry, err error) {
+	query := `SELECT id, user_id, actor, operation, before, after, created FROM user_audit
+	          WHERE user_id = $1 AND tenant_id = $4
+	          ORDER BY created DESC, id DESC
+	          LIMIT $2 OFFSET $3`
+
+	qctx, done := tx.obs.start(ctx, "user_history")
+	defer func() { done(int64(len(entries)), err) }()
+
+	rows, err := tx.QueryContext(qctx, query, id, limit, offset, tx.tenant)
+	if err != nil {
+		return nil, fmt.Errorf("query failed: %w", mapError(err))
+	}
+	defer rows.Close()
+
+	entries = make([]storage.AuditEntry, 0, limit)
+	for rows.Next() {
+		var entry storage.AuditEntry
+		var before, after []byte
+		if err := rows.Scan(&entry.ID, &entry.UserID, &entry.Actor, &entry.Operation, &before, &after, &entry.Created); err != nil {
+			return nil, fmt.Errorf("failed to scan audit entry: %w", mapError(err))
+		}
+		entry.Created = entry.Created.UTC()
+		entry.Before = before
+		entry.After = after
+		entries = append(entries, entry)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("query failed: %w", mapError(err))
+	}
+
+	return entries, nil
+}
@@ -0,0 +1,404 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"strconv"
+	"strings"
+	"test_golang_user_api/internal/storage"
+)
+
+// insertChunk is how many users go into one INSERT, keeping well below
+// the 65535 parameters a statement may have. COPY is not an option, as
+// postgres does not support COPY FROM into tables with row-level security.
+const insertChunk = 1000
+
+const bulkColumns = 10
+
+// exportPage is how many users ExportUsers reads per query.
+const exportPage = 1000
+
+// ErrForeignID is returned by ImportUsers for users whose id belongs to a
+// user of another tenant: ids are unique across tenants, and users of
+// other tenants are never overwritten.
+var ErrForeignID = errors.New("id taken by a user of another tenant")
+
+// ConflictPolicy decides what ImportUsers does with a user whose id or
+// email is already taken in the tenant.
+type ConflictPolicy int
+
+const (
+	// ConflictFail fails the import with storage.ErrEmailConflict or
+	// storage.ErrConstraintViolation.
+	ConflictFail ConflictPolicy = iota
+	// ConflictSkip keeps the existing user.
+	ConflictSkip
+	// ConflictOverwrite replaces the existing user with the imported one.
+	ConflictOverwrite
+)
+
+// ImportResult counts the users of an import. Skipped includes the users
+// listed in Foreign, whose ids belong to users of other tenants.
+type ImportResult struct {
+	Inserted int64
+	Skipped  int64
+	Foreign  []uuid.UUID
+}
+
+// Checkpoint is how far the import of a file has got: Line is the line of
+// the file up to which users are imported, Key identifies the file within
+// the tenant.
+type Checkpoint struct {
+	Key  string
+	Line int
+}
+
+// InsertUsers inserts users into the tenant of ctx in one transaction,
+// failing on conflicts, see ImportUsers.
+func (s *Storage) InsertUsers(ctx context.Context, users []storage.UserDto) error {
+	_, err := s.ImportUsers(ctx, users, ConflictFail, nil)
+	return err
+}
+
+// ImportUsers inserts users into the tenant of ctx in one transaction,
+// as they are: ids, timestamps, versions and deletion times are kept.
+// Unlike CreateUser it records neither audit entries nor outbox events, so
+// it is meant for loading test data and backups. Users are expected valid
+// and their emails normalized like the API does; backup.Reader does both.
+// It returns the number of
+// users inserted, which is less than len(users) when some are skipped. It
+// is bounded by ctx only, as a large batch may take longer than the
+// configured timeouts.
+//
+// Users whose id belongs to another tenant fail the import with
+// ErrForeignID, except with ConflictSkip, which skips them and lists them
+// in the result.
+//
+// A non-nil checkpoint is saved in the same transaction, so that it is
+// committed together with the users; see ImportCheckpoint.
+func (s *Storage) ImportUsers(ctx context.Context, users []storage.UserDto, policy ConflictPolicy, checkpoint *Checkpoint) (ImportResult, error) {
+	if len(users) == 0 && checkpoint == nil {
+		return ImportResult{}, nil
+	}
+
+	var result ImportResult
+	err := s.inTx(ctx, func(tx txn) error {
+		result = ImportResult{}
+		for start := 0; start < len(users); start += insertChunk {
+			chunk := users[start:min(start+insertChunk, len(users))]
+
+			foreign, err := foreignIDs(ctx, tx, chunk)
+			if err != nil {
+				return err
+			}
+			if len(foreign) > 0 {
+				if policy != ConflictSkip {
+					return fmt.Errorf("%w: %s", ErrForeignID, joinIDs(foreign))
+				}
+				result.Foreign = append(result.Foreign, foreign...)
+				chunk = withoutIDs(chunk, foreign)
+				if len(chunk) == 0 {
+					continue
+				}
+			}
+
+			if policy == ConflictOverwrite {
+				if err := removeConflicting(ctx, tx, chunk); err != nil {
+					return err
+				}
+			}
+
+			query, args := bulkInsertQuery(tx.tenant, chunk)
+			if policy == ConflictSkip {
+				query += ` ON CONFLICT DO NOTHING`
+			}
+
+			qctx, done := tx.obs.start(ctx, "bulk_insert_users")
+			res, err := tx.ExecContext(qctx, query, args...)
+			rows := affected(res, err)
+			done(rows, err)
+			if err != nil {
+				return fmt.Errorf("failed to insert users: %w", mapError(err))
+			}
+			result.Inserted += rows
+		}
+		result.Skipped = int64(len(users)) - result.Inserted
+
+		if checkpoint != nil {
+			return saveCheckpoint(ctx, tx, *checkpoint)
+		}
+		return nil
+	})
+	if err != nil {
+		return ImportResult{}, err
+	}
+
+	return result, nil
+}
+
+const saveCheckpointQuery = `INSERT INTO import_checkpoints (tenant_id, key, line, updated_at) VALUES ($1, $2, $3, now())
+	ON CONFLICT (tenant_id, key) DO UPDATE SET line = EXCLUDED.line, updated_at = EXCLUDED.updated_at`
+
+func saveCheckpoint(ctx context.Context, tx txn, checkpoint Checkpoint) error {
+	qctx, done := tx.obs.start(ctx, "save_import_checkpoint")
+	result, err := tx.ExecContext(qctx, saveCheckpointQuery, tx.tenant, checkpoint.Key, checkpoint.Line)
+	done(affected(result, err), err)
+	if err != nil {
+		return fmt.Errorf("failed to save checkpoint: %w", mapError(err))
+	}
+	return nil
+}
+
+// ImportCheckpoint returns the line up to which the file identified by key
+// is imported into the tenant of ctx, or 0 when there is no checkpoint. It
+// reads the primary, as a replica may lag behind the last batch.
+func (s *Storage) ImportCheckpoint(ctx context.Context, key string) (int, error) {
+	var line int
+	err := s.inTx(ctx, func(tx txn) error {
+		line = 0
+		qctx, done := tx.obs.start(ctx, "get_import_checkpoint")
+		err := tx.QueryRowContext(qctx, `SELECT line FROM import_checkpoints WHERE tenant_id = $1 AND key = $2`, tx.tenant, key).Scan(&line)
+		rows := rowCount(err)
+		if errors.Is(err, sql.ErrNoRows) {
+			err = nil
+		}
+		done(rows, err)
+		if err != nil {
+			return fmt.Errorf("failed to read checkpoint: %w", mapError(err))
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return line, nil
+}
+
+// ClearImportCheckpoint removes the checkpoint of the file identified by
+// key from the tenant of ctx, once its import is complete.
+func (s *Storage) ClearImportCheckpoint(ctx context.Context, key string) error {
+	return s.inTx(ctx, func(tx txn) error {
+		qctx, done := tx.obs.start(ctx, "clear_import_checkpoint")
+		result, err := tx.ExecContext(qctx, `DELETE FROM import_checkpoints WHERE tenant_id = $1 AND key = $2`, tx.tenant, key)
+		done(affected(result, err), err)
+		if err != nil {
+			return fmt.Errorf("failed to clear checkpoint: %w", mapError(err))
+		}
+		return nil
+	})
+}
+
+// foreignIDs returns the ids of users that belong to users of other
+// tenants. Row-level security hides those users from tx, so the check
+// lifts it for the one query.
+func foreignIDs(ctx context.Context, tx txn, users []storage.UserDto) (found []uuid.UUID, err error) {
+	ids := make([]string, len(users))
+	for i, user := range users {
+		ids[i] = user.ID.String()
+	}
+
+	if _, err := tx.ExecContext(ctx, allTenantsQuery, "on"); err != nil {
+		return nil, fmt.Errorf("failed to check ids of other tenants: %w", mapError(err))
+	}
+
+	qctx, done := tx.obs.start(ctx, "foreign_user_ids")
+	rows, err := tx.QueryContext(qctx, foreignIDsQuery, pq.Array(ids), tx.tenant)
+	if err != nil {
+		done(0, err)
+		return nil, fmt.Errorf("failed to check ids of other tenants: %w", mapError(err))
+	}
+	for rows.Next() {
+		var id uuid.UUID
+		if err = rows.Scan(&id); err != nil {
+			break
+		}
+		found = append(found, id)
+	}
+	if err == nil {
+		err = rows.Err()
+	}
+	_ = rows.Close()
+	done(int64(len(found)), err)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check ids of other tenants: %w", mapError(err))
+	}
+
+	if _, err := tx.ExecContext(ctx, allTenantsQuery, "off"); err != nil {
+		return nil, fmt.Errorf("failed to check ids of other tenants: %w", mapError(err))
+	}
+	return found, nil
+}
+
+const allTenantsQuery = `SELECT set_config('app.all_tenants', $1, true)`
+
+const foreignIDsQuery = `SELECT id FROM users WHERE id = ANY($1::uuid[]) AND tenant_id <> $2 ORDER BY id`
+
+func withoutIDs(users []storage.UserDto, ids []uuid.UUID) []storage.UserDto {
+	drop := make(map[uuid.UUID]bool, len(ids))
+	for _, id := range ids {
+		drop[id] = true
+	}
+	kept := make([]storage.UserDto, 0, len(users))
+	for _, user := range users {
+		if !drop[user.ID] {
+			kept = append(kept, user)
+		}
+	}
+	return kept
+}
+
+func joinIDs(ids []uuid.UUID) string {
+	names := make([]string, len(ids))
+	for i, id := range ids {
+		names[i] = id.String()
+	}
+	return strings.Join(names, ", ")
+}
+
+func bulkInsertQuery(tenant string, users []storage.UserDto) (string, []any) {
+	var query strings.Builder
+	query.WriteString(`INSERT INTO users (` + userColumns + `) VALUES `)
+
+	args := make([]any, 0, len(users)*bulkColumns)
+	for i, user := range users {
+		if i > 0 {
+			query.WriteString(", ")
+		}
+		query.WriteString("(")
+		for j := range bulkColumns {
+			if j > 0 {
+				query.WriteString(", ")
+			}
+			query.WriteString("$" + strconv.Itoa(i*bulkColumns+j+1))
+		}
+		query.WriteString(")")
+
+		var deleted any
+		if user.Deleted != nil {
+			deleted = user.Deleted.UTC()
+		}
+		args = append(args, user.ID, tenant, user.Firstname, user.Lastname, user.Email, user.Age,
+			user.Created.UTC(), user.Updated.UTC(), user.Version, deleted)
+	}
+
+	return query.String(), args
+}
+
+// removeConflicting deletes the users sharing an id or email with users,
+// so that they can be inserted in their place. Their history is kept.
+func removeConflicting(ctx context.Context, tx txn, users []storage.UserDto) error {
+	ids := make([]string, len(users))
+	emails := make([]string, len(users))
+	for i, user := range users {
+		ids[i] = user.ID.String()
+		emails[i] = strings.ToLower(user.Email)
+	}
+
+	query := `DELETE FROM users WHERE tenant_id = $1 AND (id = ANY($2::uuid[]) OR lower(email) = ANY($3::text[]))`
+
+	qctx, done := tx.obs.start(ctx, "remove_conflicting_users")
+	result, err := tx.ExecContext(qctx, query, tx.tenant, pq.Array(ids), pq.Array(emails))
+	done(affected(result, err), err)
+	if err != nil {
+		return fmt.Errorf("failed to remove conflicting users: %w", mapError(err))
+	}
+	return nil
+}
+
+const exportQuery = `SELECT ` + userColumns + ` FROM users
+	WHERE tenant_id = $1 AND id > $2
+	ORDER BY id
+	LIMIT $3`
+
+// ExportUsers calls fn with every user of the tenant of ctx, including
+// deleted ones, in order of id. Users are read in pages, each in its own
+// transaction, so users changed during the export may be seen in either
+// state. An error returned by fn stops the export and is returned as is.
+func (s *Storage) ExportUsers(ctx context.Context, fn func(user storage.UserDto) error) error {
+	var after uuid.UUID
+	for {
+		var page []storage.UserDto
+		err := s.withRetry(ctx, s.retry.MaxAttempts, func() error {
+			return s.readTx(ctx, func(tx txn) (err error) {
+				page = page[:0]
+				qctx, done := tx.obs.start(ctx, "export_users")
+				defer func() { done(int64(len(page)), err) }()
+
+				rows, err := tx.QueryContext(qctx, exportQuery, tx.tenant, after, exportPage)
+				if err != nil {
+					return fmt.Errorf("query failed: %w", mapError(err))
+				}
+				defer rows.Close()
+
+				for rows.Next() {
+					user, err := scanUser(rows)
+					if err != nil {
+						return fmt.Errorf("failed to scan user: %w", mapError(err))
+					}
+					page = append(page, *user)
+				}
+
+				if err := rows.Err(); err != nil {
+					return fmt.Errorf("query failed: %w", mapError(err))
+				}
+				return nil
+			})
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, user := range page {
+			if err := fn(user); err != nil {
+				return err
+			}
+		}
+
+		if len(page) < exportPage {
+			return nil
+		}
+		after = page[len(page)-1].ID
+	}
+}
+
+// WipeUsers removes every user of the tenant of ctx, including deleted
+// ones, together with every audit entry and outbox event of the tenant,
+// published, leased or pending: it is meant for starting over with test
+// data, and events and history of users that are gone are of no use.
+// It returns the number of users removed.
+func (s *Storage) WipeUsers(ctx context.Context) (int64, error) {
+	var rows int64
+	err := s.inTx(ctx, func(tx txn) error {
+		for _, table := range []string{"user_audit", "outbox"} {
+			qctx, done := tx.obs.start(ctx, "wipe_"+table)
+			result, err := tx.ExecContext(qctx, `DELETE FROM `+table+` WHERE tenant_id = $1`, tx.tenant)
+			done(affected(result, err), err)
+			if err != nil {
+				return fmt.Errorf("failed to wipe %s: %w", table, mapError(err))
+			}
+		}
+
+		qctx, done := tx.obs.start(ctx, "wipe_users")
+		result, err := tx.ExecContext(qctx, `DELETE FROM users WHERE tenant_id = $1`, tx.tenant)
+		done(affected(result, err), err)
+		if err != nil {
+			return fmt.Errorf("failed to wipe users: %w", mapError(err))
+		}
+
+		rows, err = result.RowsAffected()
+		if err != nil {
+			return fmt.Errorf("failed to get rows affected: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return rows, nil
+}
@@ -0,0 +1,86 @@
+package postgres
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"github.com/lib/pq"
+	"io"
+	"net"
+	"syscall"
+	"test_golang_user_api/internal/storage"
+)
+
+// emailUniqueConstraints name the unique email constraint before and after
+// emails became case-insensitive, and once they became unique per tenant.
+var emailUniqueConstraints = map[string]bool{
+	"users_email_key":              true,
+	"users_email_lower_key":        true,
+	"users_tenant_email_lower_key": true,
+}
+
+// mapError wraps err with the storage sentinel matching its cause, keeping
+// the original error in the chain so callers can still reach *pq.Error.
+func mapError(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	if errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("%w: %w", storage.ErrUserNotFound, err)
+	}
+
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		if sentinel := classifyCode(pqErr); sentinel != nil {
+			return fmt.Errorf("%w: %w", sentinel, err)
+		}
+		return err
+	}
+
+	if isConnectionError(err) {
+		return fmt.Errorf("%w: %w", storage.ErrUnavailable, err)
+	}
+
+	return err
+}
+
+func classifyCode(pqErr *pq.Error) error {
+	switch pqErr.Code {
+	case "23505":
+		if emailUniqueConstraints[pqErr.Constraint] {
+			return storage.ErrEmailConflict
+		}
+		if pqErr.Constraint == "tenants_pkey" {
+			return storage.ErrTenantExists
+		}
+		return storage.ErrConstraintViolation
+	case "23502", "23503", "23514":
+		return storage.ErrConstraintViolation
+	case "40001", "40P01":
+		return storage.ErrSerialization
+	case "53300", "57P01", "57P02", "57P03":
+		return storage.ErrUnavailable
+	}
+
+	if pqErr.Code.Class() == "08" {
+		return storage.ErrUnavailable
+	}
+
+	return nil
+}
+
+func isConnectionError(err error) bool {
+	if errors.Is(err, driver.ErrBadConn) ||
+		errors.Is(err, sql.ErrConnDone) ||
+		errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.ECONNRESET) {
+		return true
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
@@ -0,0 +1,81 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+	"test_golang_user_api/internal/storage"
+	"test_golang_user_api/internal/storage/listsql"
+)
+
+// ListUsers reads the page and the total in one transaction, so that both
+// see the same users.
+func (s *Storage) ListUsers(ctx context.Context, query storage.ListQuery) (*storage.UserPage, error) {
+	if err := query.Validate(); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := storage.WithTimeout(ctx, s.timeouts.Get)
+	defer cancel()
+
+	var page *storage.UserPage
+	err := s.withRetry(ctx, s.retry.MaxAttempts, func() error {
+		return s.readTx(ctx, func(tx txn) error {
+			var err error
+			page, err = listUsers(ctx, tx, query)
+			return err
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return page, nil
+}
+
+func listUsers(ctx context.Context, tx txn, query storage.ListQuery) (page *storage.UserPage, err error) {
+	statement, args := listsql.Select(userColumns, tx.tenant, query, listsql.Dollar)
+
+	users := make([]storage.UserDto, 0, query.Limit+1)
+	qctx, done := tx.obs.start(ctx, "list_users")
+	defer func() { done(int64(len(users)), err) }()
+
+	rows, err := tx.QueryContext(qctx, statement, args...)
+	if err != nil {
+		return nil, fmt.Errorf("query failed: %w", mapError(err))
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan user: %w", mapError(err))
+		}
+		users = append(users, *user)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("query failed: %w", mapError(err))
+	}
+
+	page = storage.NewUserPage(query, users)
+	if query.WithTotal {
+		if page.Total, err = countUsers(ctx, tx, query); err != nil {
+			return nil, err
+		}
+	}
+
+	return page, nil
+}
+
+func countUsers(ctx context.Context, tx txn, query storage.ListQuery) (*int64, error) {
+	statement, args := listsql.Count(tx.tenant, query, listsql.Dollar)
+
+	var total int64
+	qctx, done := tx.obs.start(ctx, "count_users")
+	err := tx.QueryRowContext(qctx, statement, args...).Scan(&total)
+	done(rowCount(err), err)
+	if err != nil {
+		return nil, fmt.Errorf("query failed: %w", mapError(err))
+	}
+	return &total, nil
+}
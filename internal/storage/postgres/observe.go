@@ -0,0 +1,83 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"github.com/go-chi/chi/v5/middleware"
+	"log/slog"
+	"time"
+)
+
+// QueryEvent describes a finished statement. Rows is the number of rows
+// affected or returned, or -1 when unknown.
+type QueryEvent struct {
+	Name     string
+	Duration time.Duration
+	Rows     int64
+	Err      error
+}
+
+// Hook observes every statement run by Storage, e.g. to record metrics or
+// tracing spans. BeforeQuery may return a derived context, which is used for
+// the statement and passed on to AfterQuery.
+type Hook interface {
+	BeforeQuery(ctx context.Context, name string) context.Context
+	AfterQuery(ctx context.Context, event QueryEvent)
+}
+
+// observer times statements, runs hooks and logs statements slower than
+// threshold. A nil observer does nothing.
+type observer struct {
+	log       *slog.Logger
+	threshold time.Duration
+	hooks     []Hook
+}
+
+func (o *observer) start(ctx context.Context, name string) (context.Context, func(rows int64, err error)) {
+	if o == nil {
+		return ctx, func(int64, error) {}
+	}
+
+	for _, hook := range o.hooks {
+		ctx = hook.BeforeQuery(ctx, name)
+	}
+	started := time.Now()
+
+	return ctx, func(rows int64, err error) {
+		event := QueryEvent{Name: name, Duration: time.Since(started), Rows: rows, Err: err}
+
+		for _, hook := range o.hooks {
+			hook.AfterQuery(ctx, event)
+		}
+
+		if o.threshold > 0 && event.Duration >= o.threshold && o.log != nil {
+			o.log.WarnContext(ctx, "slow query",
+				slog.String("statement", name),
+				slog.Duration("duration", event.Duration),
+				slog.Int64("rows", rows),
+				slog.String("request_id", middleware.GetReqID(ctx)),
+				slog.Any("err", err),
+			)
+		}
+	}
+}
+
+// rowCount reports the rows read by a single-row query.
+func rowCount(err error) int64 {
+	if err != nil {
+		return 0
+	}
+	return 1
+}
+
+// affected reports the rows affected by a statement, or -1 when unknown.
+func affected(result sql.Result, err error) int64 {
+	if err != nil || result == nil {
+		return 0
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return -1
+	}
+	return rows
+}
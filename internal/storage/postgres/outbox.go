@@ -0,0 +1,118 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"test_golang_user_api/internal/storage"
+	"time"
+)
+
+// recordChange writes the audit entry and the outbox event of a user change
+// within the change's own transaction.
+func recordChange(ctx context.Context, tx txn, operation string, before, after *storage.UserDto) error {
+	if err := writeAudit(ctx, tx, operation, before, after); err != nil {
+		return err
+	}
+	return writeEvent(ctx, tx, storage.EventType(operation), after)
+}
+
+func writeEvent(ctx context.Context, tx txn, eventType string, user *storage.UserDto) error {
+	payload, err := storage.Snapshot(user)
+	if err != nil {
+		return fmt.Errorf("failed to encode event payload: %w", err)
+	}
+
+	query := `INSERT INTO outbox (event_type, user_id, payload, created, next_attempt_at, tenant_id)
+	          VALUES ($1, $2, $3, $4, $4, $5)`
+
+	qctx, done := tx.obs.start(ctx, "insert_event")
+	result, err := tx.ExecContext(qctx, query, eventType, user.ID, string(payload), time.Now().UTC(), tx.tenant)
+	done(affected(result, err), err)
+	if err != nil {
+		return fmt.Errorf("failed to write outbox event: %w", mapError(err))
+	}
+
+	return nil
+}
+
+// ClaimEvents claims events of every tenant.
+func (s *Storage) ClaimEvents(ctx context.Context, limit int, lease time.Duration) (events []storage.Event, err error) {
+	ctx, cancel := storage.WithTimeout(ctx, s.timeouts.Edit)
+	defer cancel()
+
+	query := `UPDATE outbox SET attempts = attempts + 1, next_attempt_at = $1
+	          WHERE id IN (
+	              SELECT id FROM outbox
+	              WHERE status = 'pending' AND next_attempt_at <= $2
+	              ORDER BY id
+	              LIMIT $3
+	              FOR UPDATE SKIP LOCKED
+	          )
+	          RETURNING id, tenant_id, event_type, user_id, payload, created, attempts`
+
+	err = s.runTx(ctx, txOptions{attempts: 1, allTenants: true}, func(tx txn) (err error) {
+		qctx, done := tx.obs.start(ctx, "claim_events")
+		defer func() { done(int64(len(events)), err) }()
+
+		now := time.Now().UTC()
+		rows, err := tx.QueryContext(qctx, query, now.Add(lease), now, limit)
+		if err != nil {
+			return fmt.Errorf("failed to claim events: %w", mapError(err))
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var event storage.Event
+			var payload []byte
+			if err := rows.Scan(&event.ID, &event.Tenant, &event.Type, &event.UserID, &payload, &event.Created, &event.Attempts); err != nil {
+				return fmt.Errorf("failed to scan event: %w", mapError(err))
+			}
+			event.Created = event.Created.UTC()
+			event.Payload = payload
+			events = append(events, event)
+		}
+
+		if err := rows.Err(); err != nil {
+			return fmt.Errorf("failed to claim events: %w", mapError(err))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(events, func(i, j int) bool { return events[i].ID < events[j].ID })
+
+	return events, nil
+}
+
+func (s *Storage) MarkEventSent(ctx context.Context, id int64) error {
+	return s.updateEvent(ctx, "mark_event_sent", `UPDATE outbox SET status = 'sent', sent_at = $2, last_error = NULL WHERE id = $1`, id, time.Now().UTC())
+}
+
+func (s *Storage) RetryEvent(ctx context.Context, id int64, lastErr string, retryAt time.Time) error {
+	return s.updateEvent(ctx, "retry_event", `UPDATE outbox SET last_error = $2, next_attempt_at = $3 WHERE id = $1`, id, lastErr, retryAt.UTC())
+}
+
+func (s *Storage) DeadLetterEvent(ctx context.Context, id int64, lastErr string) error {
+	return s.updateEvent(ctx, "dead_letter_event", `UPDATE outbox SET status = 'dead', last_error = $2 WHERE id = $1`, id, lastErr)
+}
+
+// updateEvent runs statements that are idempotent, so they are retried.
+// ClaimEvents is not: a lost reply may have leased events already, and the
+// dispatcher polls again anyway.
+func (s *Storage) updateEvent(ctx context.Context, name, query string, args ...any) error {
+	ctx, cancel := storage.WithTimeout(ctx, s.timeouts.Edit)
+	defer cancel()
+
+	return s.runTx(ctx, txOptions{attempts: s.retry.MaxAttempts, allTenants: true}, func(tx txn) error {
+		qctx, done := tx.obs.start(ctx, name)
+		result, err := tx.ExecContext(qctx, query, args...)
+		done(affected(result, err), err)
+		if err != nil {
+			return fmt.Errorf("failed to update event: %w", mapError(err))
+		}
+		return nil
+	})
+}
@@ -1,60 +1,86 @@
 package postgres
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"fmt"
 	"github.com/golang-migrate/migrate/v4"
 	"github.com/golang-migrate/migrate/v4/database/postgres"
-	_ "github.com/golang-migrate/migrate/v4/source/file"
+	"github.com/golang-migrate/migrate/v4/source/iofs"
 	"github.com/google/uuid"
 	_ "github.com/lib/pq"
+	"log/slog"
+	"strings"
 	"test_golang_user_api/internal/config"
+	"test_golang_user_api/internal/database"
+	"test_golang_user_api/internal/storage"
 	"time"
 )
 
-type UserDto struct {
-	ID        uuid.UUID
-	Firstname string
-	Lastname  string
-	Email     string
-	Age       int
-	Created   time.Time
-}
-
-func NewUser(id uuid.UUID, firstname, lastname, email string, age int) *UserDto {
-	return &UserDto{
-		ID:        id,
-		Firstname: firstname,
-		Lastname:  lastname,
-		Email:     email,
-		Age:       age,
-		Created:   time.Now(),
-	}
-}
-
 type Storage struct {
-	db *sql.DB
+	db       *sql.DB
+	replicas *replicaSet
+	timeouts config.Timeouts
+	retry    config.Retry
+	obs      *observer
 }
 
-func New(cfg config.Postgres) (*Storage, error) {
+// New connects to the primary and the configured replicas. Statements
+// slower than cfg.SlowQuery are logged to log, and every statement is
+// reported to hooks.
+func New(log *slog.Logger, cfg config.Postgres, hooks ...Hook) (*Storage, error) {
 	db, err := sql.Open("postgres", buildUri(cfg))
 
 	if err != nil {
 		return nil, fmt.Errorf("failed to configure connection to postgres: %w", err)
 	}
 
+	configurePool(db, cfg.Pool)
+
 	if err := db.Ping(); err != nil {
 		_ = db.Close()
 		return nil, fmt.Errorf("failed to ping postgres: %w", err)
 	}
 
-	if err := runMigrations(db); err != nil {
-		_ = db.Close()
-		return nil, fmt.Errorf("migration failed: %w", err)
+	var replicas []*sql.DB
+	for _, dsn := range cfg.Replicas.DSNs {
+		replica, err := sql.Open("postgres", dsn)
+		if err != nil {
+			_ = db.Close()
+			for _, r := range replicas {
+				_ = r.Close()
+			}
+			return nil, fmt.Errorf("failed to configure connection to postgres replica: %w", err)
+		}
+		configurePool(replica, cfg.Pool)
+		replicas = append(replicas, replica)
+	}
+
+	store := &Storage{
+		db:       db,
+		timeouts: cfg.Timeouts,
+		retry:    cfg.Retry,
+		obs:      &observer{log: log, threshold: cfg.SlowQuery, hooks: hooks},
+	}
+	if len(replicas) > 0 {
+		store.replicas = newReplicaSet(replicas, cfg.Replicas.HealthCheckInterval)
 	}
 
-	return &Storage{db: db}, nil
+	return store, nil
+}
+
+func configurePool(db *sql.DB, cfg config.Pool) {
+	db.SetMaxOpenConns(cfg.MaxOpenConns)
+	db.SetMaxIdleConns(cfg.MaxIdleConns)
+	db.SetConnMaxLifetime(cfg.ConnMaxLifetime)
+	db.SetConnMaxIdleTime(cfg.ConnMaxIdleTime)
+}
+
+// Stats reports connection pool usage of the primary, including how often
+// and how long callers waited for a free connection.
+func (s *Storage) Stats() sql.DBStats {
+	return s.db.Stats()
 }
 
 func buildUri(cfg config.Postgres) string {
@@ -67,19 +93,41 @@ func buildUri(cfg config.Postgres) string {
 	)
 }
 
-func runMigrations(db *sql.DB) error {
-	driver, err := postgres.WithInstance(db, &postgres.Config{})
+// Migrator returns a migrator bound to a dedicated connection of the pool.
+// Closing it releases that connection only.
+func (s *Storage) Migrator(ctx context.Context) (*migrate.Migrate, error) {
+	source, err := iofs.New(database.PostgresMigrations, database.PostgresMigrationsDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open migrations: %w", err)
+	}
+
+	conn, err := s.db.Conn(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to acquire connection: %w", mapError(err))
+	}
+
+	driver, err := postgres.WithConnection(ctx, conn, &postgres.Config{})
 	if err != nil {
-		return fmt.Errorf("failed to create migration driver: %w", err)
+		_ = conn.Close()
+		return nil, fmt.Errorf("failed to create migration driver: %w", err)
 	}
 
-	m, err := migrate.NewWithDatabaseInstance(
-		"file://internal/database/migrations",
-		"postgres", driver)
+	m, err := migrate.NewWithInstance("iofs", source, "postgres", driver)
 	if err != nil {
-		return fmt.Errorf("failed to create migrator: %w", err)
+		_ = driver.Close()
+		return nil, fmt.Errorf("failed to create migrator: %w", err)
 	}
 
+	return m, nil
+}
+
+func (s *Storage) MigrateUp(ctx context.Context) error {
+	m, err := s.Migrator(ctx)
+	if err != nil {
+		return err
+	}
+	defer m.Close()
+
 	err = m.Up()
 	if err != nil && !errors.Is(err, migrate.ErrNoChange) {
 		return fmt.Errorf("migration up error: %w", err)
@@ -88,77 +136,289 @@ func runMigrations(db *sql.DB) error {
 	return nil
 }
 
-func (s *Storage) CreateUser(user *UserDto) error {
-	query := `INSERT INTO users (id, firstname, lastname, email, age, created) 
-	          VALUES ($1, $2, $3, $4, $5, $6)`
+func (s *Storage) Close() error {
+	if s.replicas != nil {
+		return errors.Join(s.replicas.Close(), s.db.Close())
+	}
+	return s.db.Close()
+}
+
+func (s *Storage) CreateUser(ctx context.Context, user *storage.UserDto) error {
+	ctx, cancel := storage.WithTimeout(ctx, s.timeouts.Create)
+	defer cancel()
+
+	return s.inTx(ctx, func(tx txn) error {
+		return createUser(ctx, tx, user)
+	})
+}
 
-	_, err := s.db.Exec(query, user.ID, user.Firstname, user.Lastname, user.Email, user.Age, user.Created)
+func createUser(ctx context.Context, tx txn, user *storage.UserDto) error {
+	query := `INSERT INTO users (id, tenant_id, firstname, lastname, email, age, created, updated_at) 
+	          VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+	          RETURNING ` + userColumns
+
+	qctx, done := tx.obs.start(ctx, "insert_user")
+	created, err := scanUser(tx.QueryRowContext(qctx, query, user.ID, tx.tenant, user.Firstname, user.Lastname, user.Email, user.Age, user.Created.UTC(), user.Updated.UTC()))
+	done(rowCount(err), err)
 	if err != nil {
-		return fmt.Errorf("failed to insert user: %w", err)
+		return fmt.Errorf("failed to insert user: %w", mapError(err))
 	}
+	user.Tenant = created.Tenant
 
-	return nil
+	return recordChange(ctx, tx, storage.AuditCreate, nil, created)
 }
 
-func (s *Storage) GetUser(id uuid.UUID) (*UserDto, error) {
-	query := `SELECT id, firstname, lastname, email, age, created FROM users WHERE id = $1`
+const userColumns = `id, tenant_id, firstname, lastname, email, age, created, updated_at, version, deleted_at`
 
-	var user UserDto
-	err := s.db.QueryRow(query, id).Scan(
+func scanUser(row rowScanner) (*storage.UserDto, error) {
+	var user storage.UserDto
+	var deleted sql.NullTime
+	err := row.Scan(
 		&user.ID,
+		&user.Tenant,
 		&user.Firstname,
 		&user.Lastname,
 		&user.Email,
 		&user.Age,
 		&user.Created,
+		&user.Updated,
+		&user.Version,
+		&deleted,
 	)
+	if err != nil {
+		return nil, err
+	}
+
+	user.Created = user.Created.UTC()
+	user.Updated = user.Updated.UTC()
+	if deleted.Valid {
+		deletedAt := deleted.Time.UTC()
+		user.Deleted = &deletedAt
+	}
+
+	return &user, nil
+}
 
+const (
+	getUserQuery                 = `SELECT ` + userColumns + ` FROM users WHERE id = $1 AND tenant_id = $2 AND deleted_at IS NULL`
+	getUserIncludingDeletedQuery = `SELECT ` + userColumns + ` FROM users WHERE id = $1 AND tenant_id = $2`
+	getUserByEmailQuery          = `SELECT ` + userColumns + ` FROM users WHERE lower(email) = lower($1) AND tenant_id = $2 AND deleted_at IS NULL`
+)
+
+func (s *Storage) GetUser(ctx context.Context, id uuid.UUID) (*storage.UserDto, error) {
+	return s.getUser(ctx, "get_user", getUserQuery, id)
+}
+
+func (s *Storage) GetUserIncludingDeleted(ctx context.Context, id uuid.UUID) (*storage.UserDto, error) {
+	return s.getUser(ctx, "get_user_including_deleted", getUserIncludingDeletedQuery, id)
+}
+
+func (s *Storage) GetUserByEmail(ctx context.Context, email string) (*storage.UserDto, error) {
+	return s.getUser(ctx, "get_user_by_email", getUserByEmailQuery, email)
+}
+
+func (s *Storage) getUser(ctx context.Context, name, query string, key any) (*storage.UserDto, error) {
+	ctx, cancel := storage.WithTimeout(ctx, s.timeouts.Get)
+	defer cancel()
+
+	var user *storage.UserDto
+	err := s.withRetry(ctx, s.retry.MaxAttempts, func() error {
+		return s.readTx(ctx, func(tx txn) error {
+			var err error
+			user, err = queryUser(ctx, tx, name, query, key)
+			return err
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+// queryUser reads the user matching key, the first parameter of query, in
+// the tenant of tx, the second.
+func queryUser(ctx context.Context, tx txn, name, query string, key any) (*storage.UserDto, error) {
+	qctx, done := tx.obs.start(ctx, name)
+	user, err := scanUser(tx.QueryRowContext(qctx, query, key, tx.tenant))
+	done(rowCount(err), err)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
-			return nil, fmt.Errorf("user not found: %w", err)
+			return nil, storage.ErrUserNotFound
 		}
-		return nil, fmt.Errorf("query failed: %w", err)
+		return nil, fmt.Errorf("query failed: %w", mapError(err))
 	}
 
-	return &user, nil
+	return user, nil
+}
+
+// lockUser reads and locks the user row for the rest of tx. Deleted users
+// are only matched when deleted is true, and live users only when false.
+func lockUser(ctx context.Context, tx txn, id uuid.UUID, deleted bool, version int) (*storage.UserDto, error) {
+	query := `SELECT ` + userColumns + ` FROM users WHERE id = $1 AND tenant_id = $2 AND (deleted_at IS NOT NULL) = $3 FOR UPDATE`
+
+	qctx, done := tx.obs.start(ctx, "lock_user")
+	user, err := scanUser(tx.QueryRowContext(qctx, query, id, tx.tenant, deleted))
+	done(rowCount(err), err)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, storage.ErrUserNotFound
+		}
+		return nil, fmt.Errorf("failed to lock user: %w", mapError(err))
+	}
+
+	if version != 0 && user.Version != version {
+		return nil, storage.ErrVersionConflict
+	}
+
+	return user, nil
 }
 
-func (s *Storage) EditUser(user *UserDto) (*UserDto, error) {
-	query := `UPDATE users SET firstname = $1, lastname = $2, email = $3, age = $4 WHERE id = $5`
+func (s *Storage) EditUser(ctx context.Context, id uuid.UUID, patch storage.UserPatch, version int) (*storage.UserDto, error) {
+	ctx, cancel := storage.WithTimeout(ctx, s.timeouts.Edit)
+	defer cancel()
 
-	result, err := s.db.Exec(query, user.Firstname, user.Lastname, user.Email, user.Age, user.ID)
+	var updated *storage.UserDto
+	err := s.inTx(ctx, func(tx txn) error {
+		var err error
+		updated, err = editUser(ctx, tx, id, patch, version)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
 
+	return updated, nil
+}
+
+func editUser(ctx context.Context, tx txn, id uuid.UUID, patch storage.UserPatch, version int) (*storage.UserDto, error) {
+	before, err := lockUser(ctx, tx, id, false, version)
 	if err != nil {
-		return nil, fmt.Errorf("failed to update user: %w", err)
+		return nil, err
+	}
+	if patch.Empty() {
+		return before, nil
 	}
 
-	rows, err := result.RowsAffected()
+	// Only the columns set by the patch are written, the field names
+	// being the column names.
+	fields, args := patch.Values()
+	set := make([]string, len(fields))
+	for i, field := range fields {
+		set[i] = fmt.Sprintf("%s = $%d", field, i+1)
+	}
+	n := len(args)
+	query := fmt.Sprintf(`UPDATE users SET %s, updated_at = $%d, version = version + 1
+	          WHERE id = $%d AND tenant_id = $%d
+	          RETURNING `+userColumns, strings.Join(set, ", "), n+1, n+2, n+3)
 
+	qctx, done := tx.obs.start(ctx, "update_user")
+	updated, err := scanUser(tx.QueryRowContext(qctx, query, append(args, time.Now().UTC(), id, tx.tenant)...))
+	done(rowCount(err), err)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get rows affected: %w", err)
+		return nil, fmt.Errorf("failed to update user: %w", mapError(err))
 	}
 
-	if rows == 0 {
-		return nil, fmt.Errorf("user not found")
+	if err := recordChange(ctx, tx, storage.AuditEdit, before, updated); err != nil {
+		return nil, err
 	}
 
-	return user, nil
+	return updated, nil
+}
+
+func (s *Storage) DeleteUser(ctx context.Context, id uuid.UUID, version int) error {
+	ctx, cancel := storage.WithTimeout(ctx, s.timeouts.Delete)
+	defer cancel()
+
+	return s.inTx(ctx, func(tx txn) error {
+		return deleteUser(ctx, tx, id, version)
+	})
+}
+
+func deleteUser(ctx context.Context, tx txn, id uuid.UUID, version int) error {
+	query := `UPDATE users SET deleted_at = $1, updated_at = $1, version = version + 1
+	          WHERE id = $2 AND tenant_id = $3
+	          RETURNING ` + userColumns
+
+	before, err := lockUser(ctx, tx, id, false, version)
+	if err != nil {
+		return err
+	}
+
+	qctx, done := tx.obs.start(ctx, "delete_user")
+	deleted, err := scanUser(tx.QueryRowContext(qctx, query, time.Now().UTC(), id, tx.tenant))
+	done(rowCount(err), err)
+	if err != nil {
+		return fmt.Errorf("failed to delete user: %w", mapError(err))
+	}
+
+	return recordChange(ctx, tx, storage.AuditDelete, before, deleted)
+}
+
+func (s *Storage) RestoreUser(ctx context.Context, id uuid.UUID) (*storage.UserDto, error) {
+	ctx, cancel := storage.WithTimeout(ctx, s.timeouts.Edit)
+	defer cancel()
+
+	var restored *storage.UserDto
+	err := s.inTx(ctx, func(tx txn) error {
+		var err error
+		restored, err = restoreUser(ctx, tx, id)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return restored, nil
 }
 
-func (s *Storage) DeleteUser(id uuid.UUID) error {
-	query := `DELETE FROM users WHERE id = $1`
-	result, err := s.db.Exec(query, id)
+func restoreUser(ctx context.Context, tx txn, id uuid.UUID) (*storage.UserDto, error) {
+	query := `UPDATE users SET deleted_at = NULL, updated_at = $1, version = version + 1
+	          WHERE id = $2 AND tenant_id = $3
+	          RETURNING ` + userColumns
+
+	before, err := lockUser(ctx, tx, id, true, 0)
 	if err != nil {
-		return fmt.Errorf("failed to delete user: %w", err)
+		return nil, err
 	}
 
-	rows, err := result.RowsAffected()
+	qctx, done := tx.obs.start(ctx, "restore_user")
+	restored, err := scanUser(tx.QueryRowContext(qctx, query, time.Now().UTC(), id, tx.tenant))
+	done(rowCount(err), err)
 	if err != nil {
-		return fmt.Errorf("failed to get rows affected: %w", err)
+		return nil, fmt.Errorf("failed to restore user: %w", mapError(err))
 	}
 
-	if rows == 0 {
-		return fmt.Errorf("user not found")
+	if err := recordChange(ctx, tx, storage.AuditRestore, before, restored); err != nil {
+		return nil, err
 	}
-	return nil
+
+	return restored, nil
+}
+
+// PurgeDeleted removes users of every tenant.
+func (s *Storage) PurgeDeleted(ctx context.Context, before time.Time) (int64, error) {
+	ctx, cancel := storage.WithTimeout(ctx, s.timeouts.Delete)
+	defer cancel()
+
+	var rows int64
+	err := s.runTx(ctx, txOptions{attempts: s.retry.MaxAttempts, allTenants: true}, func(tx txn) error {
+		qctx, done := tx.obs.start(ctx, "purge_users")
+		result, err := tx.ExecContext(qctx, `DELETE FROM users WHERE deleted_at < $1`, before)
+		done(affected(result, err), err)
+		if err != nil {
+			return fmt.Errorf("failed to purge users: %w", mapError(err))
+		}
+
+		rows, err = result.RowsAffected()
+		if err != nil {
+			return fmt.Errorf("failed to get rows affected: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return rows, nil
 }
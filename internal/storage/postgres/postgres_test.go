@@ -1,13 +1,27 @@
 package postgres
 
 import (
+	"bytes"
+	"context"
 	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/go-chi/chi/v5/middleware"
 	"github.com/google/uuid"
+	"github.com/ilyakaznacheev/cleanenv"
+	"github.com/lib/pq"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"log/slog"
+	"os"
 	"regexp"
+	"syscall"
+	"test_golang_user_api/internal/config"
+	"test_golang_user_api/internal/storage"
+	"test_golang_user_api/internal/storage/storagetest"
 	"testing"
 	"time"
 )
@@ -21,189 +35,1726 @@ func newTestStorage(t *testing.T) (*Storage, sqlmock.Sqlmock, func()) {
 	return &Storage{db: db}, mock, cleanup
 }
 
+// expectBegin expects a transaction scoped to the default tenant.
+func expectBegin(mock sqlmock.Sqlmock) {
+	expectScopedBegin(mock, storage.DefaultTenant, "off")
+}
+
+func expectScopedBegin(mock sqlmock.Sqlmock, tenant, allTenants string) {
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta(scopeQuery)).WithArgs(tenant, allTenants).WillReturnResult(sqlmock.NewResult(0, 0))
+}
+
+// expectNoForeign expects the check of an import chunk for ids of other
+// tenants, finding none.
+func expectNoForeign(mock sqlmock.Sqlmock) {
+	expectForeign(mock)
+}
+
+func expectForeign(mock sqlmock.Sqlmock, ids ...uuid.UUID) {
+	rows := sqlmock.NewRows([]string{"id"})
+	for _, id := range ids {
+		rows.AddRow(id.String())
+	}
+	mock.ExpectExec(regexp.QuoteMeta(allTenantsQuery)).WithArgs("on").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(regexp.QuoteMeta(foreignIDsQuery)).WillReturnRows(rows)
+	mock.ExpectExec(regexp.QuoteMeta(allTenantsQuery)).WithArgs("off").WillReturnResult(sqlmock.NewResult(0, 0))
+}
+
+// expectRead expects query in a read-only transaction of the default
+// tenant; callers expect the commit or rollback ending it.
+func expectRead(mock sqlmock.Sqlmock, query string, args ...driver.Value) *sqlmock.ExpectedQuery {
+	expectBegin(mock)
+	return mock.ExpectQuery(query).WithArgs(args...)
+}
+
+func TestStorageStats(t *testing.T) {
+	t.Run("reports configured pool limits", func(t *testing.T) {
+		//given
+		store, _, cleanup := newTestStorage(t)
+		defer cleanup()
+
+		configurePool(store.db, config.Pool{MaxOpenConns: 7, MaxIdleConns: 3})
+		//when
+		stats := store.Stats()
+		//then
+		assert.Equal(t, 7, stats.MaxOpenConnections)
+	})
+}
+
+func userRows(users ...*storage.UserDto) *sqlmock.Rows {
+	rows := sqlmock.NewRows([]string{"id", "tenant_id", "firstname", "lastname", "email", "age", "created", "updated_at", "version", "deleted_at"})
+	for _, user := range users {
+		var deleted driver.Value
+		if user.Deleted != nil {
+			deleted = *user.Deleted
+		}
+		rows.AddRow(user.ID, user.Tenant, user.Firstname, user.Lastname, user.Email, user.Age, user.Created, user.Updated, user.Version, deleted)
+	}
+	return rows
+}
+
+func newUser() *storage.UserDto {
+	now := time.Now().UTC()
+	return &storage.UserDto{
+		ID:        uuid.New(),
+		Tenant:    storage.DefaultTenant,
+		Firstname: "Ivan",
+		Lastname:  "Ivanov",
+		Email:     "ivan@gmail.com",
+		Age:       30,
+		Created:   now,
+		Updated:   now,
+		Version:   1,
+	}
+}
+
+var (
+	insertQuery = regexp.QuoteMeta(`INSERT INTO users (id, tenant_id, firstname, lastname, email, age, created, updated_at)`)
+	lockQuery   = regexp.QuoteMeta(`SELECT id, tenant_id, firstname, lastname, email, age, created, updated_at, version, deleted_at FROM users WHERE id = $1 AND tenant_id = $2 AND (deleted_at IS NOT NULL) = $3 FOR UPDATE`)
+	auditQuery  = regexp.QuoteMeta(`INSERT INTO user_audit (user_id, actor, operation, before, after, created, tenant_id)`)
+	outboxQuery = regexp.QuoteMeta(`INSERT INTO outbox (event_type, user_id, payload, created, next_attempt_at, tenant_id)`)
+)
+
 func TestStorageCreateUser(t *testing.T) {
 	t.Run("success save user to db", func(t *testing.T) {
 		//given
-		storage, mock, cleanup := newTestStorage(t)
+		store, mock, cleanup := newTestStorage(t)
 		defer cleanup()
 
-		user := &UserDto{
-			ID:        uuid.New(),
-			Firstname: "Ivan",
-			Lastname:  "Ivanov",
-			Email:     "ivan@gmail.com",
-			Age:       30,
-			Created:   time.Now(),
-		}
+		user := newUser()
+		ctx := storage.WithActor(context.Background(), "admin")
+
+		expectBegin(mock)
+		mock.ExpectQuery(insertQuery).
+			WithArgs(user.ID, storage.DefaultTenant, user.Firstname, user.Lastname, user.Email, user.Age, user.Created, user.Updated).
+			WillReturnRows(userRows(user))
+		mock.ExpectExec(auditQuery).
+			WithArgs(user.ID, "admin", storage.AuditCreate, nil, sqlmock.AnyArg(), sqlmock.AnyArg(), storage.DefaultTenant).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec(outboxQuery).
+			WithArgs(storage.EventUserCreated, user.ID, sqlmock.AnyArg(), sqlmock.AnyArg(), storage.DefaultTenant).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectCommit()
+		//when
+		err := store.CreateUser(ctx, user)
+		//then
+		require.NoError(t, err)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("scopes user to tenant of context", func(t *testing.T) {
+		//given
+		store, mock, cleanup := newTestStorage(t)
+		defer cleanup()
 
-		mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO users (id, firstname, lastname, email, age, created)`)).
-			WithArgs(user.ID, user.Firstname, user.Lastname, user.Email, user.Age, user.Created).
+		user := newUser()
+		user.Tenant = "acme"
+		ctx := storage.WithTenant(context.Background(), "acme")
+
+		expectScopedBegin(mock, "acme", "off")
+		mock.ExpectQuery(insertQuery).
+			WithArgs(user.ID, "acme", user.Firstname, user.Lastname, user.Email, user.Age, user.Created, user.Updated).
+			WillReturnRows(userRows(user))
+		mock.ExpectExec(auditQuery).
+			WithArgs(user.ID, storage.SystemActor, storage.AuditCreate, nil, sqlmock.AnyArg(), sqlmock.AnyArg(), "acme").
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec(outboxQuery).
+			WithArgs(storage.EventUserCreated, user.ID, sqlmock.AnyArg(), sqlmock.AnyArg(), "acme").
 			WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectCommit()
 		//when
-		err := storage.CreateUser(user)
+		err := store.CreateUser(ctx, user)
 		//then
 		require.NoError(t, err)
+		assert.Equal(t, "acme", user.Tenant)
 		assert.NoError(t, mock.ExpectationsWereMet())
 	})
 
 	t.Run("returns error when insert fails", func(t *testing.T) {
 		//given
-		storage, mock, cleanup := newTestStorage(t)
+		store, mock, cleanup := newTestStorage(t)
 		defer cleanup()
 
-		user := &UserDto{
-			ID:        uuid.New(),
-			Firstname: "Ivan",
-			Lastname:  "Ivanov",
-			Email:     "ivan@gmail.com",
-			Age:       30,
-			Created:   time.Now(),
-		}
+		user := newUser()
 
-		mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO users (id, firstname, lastname, email, age, created)`)).
-			WithArgs(user.ID, user.Firstname, user.Lastname, user.Email, user.Age, user.Created).
+		expectBegin(mock)
+		mock.ExpectQuery(insertQuery).
+			WithArgs(user.ID, storage.DefaultTenant, user.Firstname, user.Lastname, user.Email, user.Age, user.Created, user.Updated).
 			WillReturnError(fmt.Errorf("insert error"))
+		mock.ExpectRollback()
 
 		//when
-		err := storage.CreateUser(user)
+		err := store.CreateUser(context.Background(), user)
 
 		//then
 		require.Error(t, err)
 		require.Contains(t, err.Error(), "failed to insert user")
 		require.NoError(t, mock.ExpectationsWereMet())
 	})
+
+	t.Run("returns email conflict on unique violation", func(t *testing.T) {
+		//given
+		store, mock, cleanup := newTestStorage(t)
+		defer cleanup()
+
+		user := newUser()
+
+		expectBegin(mock)
+		mock.ExpectQuery(insertQuery).
+			WithArgs(user.ID, storage.DefaultTenant, user.Firstname, user.Lastname, user.Email, user.Age, user.Created, user.Updated).
+			WillReturnError(&pq.Error{Code: "23505", Constraint: "users_email_key"})
+		mock.ExpectRollback()
+
+		//when
+		err := store.CreateUser(context.Background(), user)
+
+		//then
+		require.ErrorIs(t, err, storage.ErrEmailConflict)
+		var pqErr *pq.Error
+		require.ErrorAs(t, err, &pqErr)
+	})
+
+	t.Run("rolls back user when audit fails", func(t *testing.T) {
+		//given
+		store, mock, cleanup := newTestStorage(t)
+		defer cleanup()
+
+		user := newUser()
+
+		expectBegin(mock)
+		mock.ExpectQuery(insertQuery).
+			WithArgs(user.ID, storage.DefaultTenant, user.Firstname, user.Lastname, user.Email, user.Age, user.Created, user.Updated).
+			WillReturnRows(userRows(user))
+		mock.ExpectExec(auditQuery).
+			WillReturnError(fmt.Errorf("audit error"))
+		mock.ExpectRollback()
+
+		//when
+		err := store.CreateUser(context.Background(), user)
+
+		//then
+		require.ErrorContains(t, err, "failed to write audit entry")
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestMapError(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		expected error
+	}{
+		{"no rows", sql.ErrNoRows, storage.ErrUserNotFound},
+		{"email unique violation", &pq.Error{Code: "23505", Constraint: "users_email_key"}, storage.ErrEmailConflict},
+		{"case-insensitive email unique violation", &pq.Error{Code: "23505", Constraint: "users_email_lower_key"}, storage.ErrEmailConflict},
+		{"tenant email unique violation", &pq.Error{Code: "23505", Constraint: "users_tenant_email_lower_key"}, storage.ErrEmailConflict},
+		{"tenant primary key violation", &pq.Error{Code: "23505", Constraint: "tenants_pkey"}, storage.ErrTenantExists},
+		{"primary key violation", &pq.Error{Code: "23505", Constraint: "users_pkey"}, storage.ErrConstraintViolation},
+		{"check violation", &pq.Error{Code: "23514"}, storage.ErrConstraintViolation},
+		{"serialization failure", &pq.Error{Code: "40001"}, storage.ErrSerialization},
+		{"deadlock", &pq.Error{Code: "40P01"}, storage.ErrSerialization},
+		{"admin shutdown", &pq.Error{Code: "57P01"}, storage.ErrUnavailable},
+		{"connection exception", &pq.Error{Code: "08006"}, storage.ErrUnavailable},
+		{"bad connection", driver.ErrBadConn, storage.ErrUnavailable},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			//when
+			err := mapError(fmt.Errorf("wrapped: %w", tt.err))
+			//then
+			require.ErrorIs(t, err, tt.expected)
+			require.ErrorIs(t, err, tt.err)
+		})
+	}
 }
 
 func TestStorageGetUser(t *testing.T) {
 	t.Run("success save user to db", func(t *testing.T) {
 		//given
-		storage, mock, cleanup := newTestStorage(t)
+		store, mock, cleanup := newTestStorage(t)
 		defer cleanup()
 
 		id := uuid.New()
 		created := time.Now()
 
-		mock.ExpectQuery(regexp.QuoteMeta(`SELECT id, firstname, lastname, email, age, created FROM users WHERE id = $1`)).
-			WithArgs(id).
-			WillReturnRows(sqlmock.NewRows([]string{"id", "firstname", "lastname", "email", "age", "created"}).
-				AddRow(id, "Ivan", "Ivanov", "ivan@gmail.com", 30, created))
+		expectRead(mock, getQuery, id, storage.DefaultTenant).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "tenant_id", "firstname", "lastname", "email", "age", "created", "updated_at", "version", "deleted_at"}).
+				AddRow(id, storage.DefaultTenant, "Ivan", "Ivanov", "ivan@gmail.com", 30, created, created, 2, nil))
+		mock.ExpectCommit()
 
 		//when
-		user, err := storage.GetUser(id)
+		user, err := store.GetUser(context.Background(), id)
 		//then
 		require.NoError(t, err)
 		assert.Equal(t, "Ivan", user.Firstname)
 		assert.Equal(t, "Ivanov", user.Lastname)
 		assert.Equal(t, "ivan@gmail.com", user.Email)
 		assert.Equal(t, 30, user.Age)
+		assert.Equal(t, 2, user.Version)
+	})
+
+	t.Run("returns timestamps in UTC", func(t *testing.T) {
+		//given
+		store, mock, cleanup := newTestStorage(t)
+		defer cleanup()
+
+		user := newUser()
+		zone := time.FixedZone("MSK", 3*60*60)
+		user.Created = user.Created.In(zone)
+		user.Updated = user.Updated.In(zone)
+		expectRead(mock, getQuery, user.ID, storage.DefaultTenant).WillReturnRows(userRows(user))
+		mock.ExpectCommit()
+		//when
+		got, err := store.GetUser(context.Background(), user.ID)
+		//then
+		require.NoError(t, err)
+		assert.Equal(t, time.UTC, got.Created.Location())
+		assert.Equal(t, time.UTC, got.Updated.Location())
+		assert.True(t, user.Created.Equal(got.Created))
 	})
 
 	t.Run("returns error when user not found", func(t *testing.T) {
 		// given
-		storage, mock, cleanup := newTestStorage(t)
+		store, mock, cleanup := newTestStorage(t)
 		defer cleanup()
 
 		id := uuid.New()
 
-		mock.ExpectQuery(regexp.QuoteMeta(`SELECT id, firstname, lastname, email, age, created FROM users WHERE id = $1`)).
-			WithArgs(id).
+		expectRead(mock, getQuery, id, storage.DefaultTenant).
 			WillReturnError(sql.ErrNoRows)
+		mock.ExpectRollback()
 
 		// when
-		user, err := storage.GetUser(id)
+		user, err := store.GetUser(context.Background(), id)
 		// then
 		require.Nil(t, user)
 		require.Error(t, err)
 		require.Contains(t, err.Error(), "user not found")
 	})
 
+	t.Run("cancels query after configured timeout", func(t *testing.T) {
+		// given
+		store, mock, cleanup := newTestStorage(t)
+		defer cleanup()
+		store.timeouts = config.Timeouts{Get: 10 * time.Millisecond}
+
+		id := uuid.New()
+
+		expectRead(mock, getQuery, id, storage.DefaultTenant).
+			WillDelayFor(time.Second).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "tenant_id", "firstname", "lastname", "email", "age", "created", "updated_at", "version", "deleted_at"}))
+
+		// when
+		started := time.Now()
+		user, err := store.GetUser(context.Background(), id)
+		// then
+		require.Nil(t, user)
+		require.Error(t, err)
+		assert.Less(t, time.Since(started), 500*time.Millisecond)
+	})
+}
+
+func TestStorageGetUserByEmail(t *testing.T) {
+	t.Run("matches email ignoring case", func(t *testing.T) {
+		//given
+		store, mock, cleanup := newTestStorage(t)
+		defer cleanup()
+
+		user := newUser()
+
+		expectRead(mock, regexp.QuoteMeta(`WHERE lower(email) = lower($1) AND tenant_id = $2 AND deleted_at IS NULL`), "IVAN@gmail.com", storage.DefaultTenant).
+			WillReturnRows(userRows(user))
+		mock.ExpectCommit()
+
+		//when
+		got, err := store.GetUserByEmail(context.Background(), "IVAN@gmail.com")
+		//then
+		require.NoError(t, err)
+		assert.Equal(t, user.ID, got.ID)
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("returns not found for unknown email", func(t *testing.T) {
+		//given
+		store, mock, cleanup := newTestStorage(t)
+		defer cleanup()
+
+		expectRead(mock, regexp.QuoteMeta(`WHERE lower(email) = lower($1)`), "ivan@gmail.com", storage.DefaultTenant).
+			WillReturnError(sql.ErrNoRows)
+		mock.ExpectRollback()
+
+		//when
+		_, err := store.GetUserByEmail(context.Background(), "ivan@gmail.com")
+		//then
+		require.ErrorIs(t, err, storage.ErrUserNotFound)
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
 }
 
 func TestStorageEditUser(t *testing.T) {
+	editQuery := regexp.QuoteMeta(`UPDATE users SET firstname = $1, lastname = $2, email = $3, age = $4, updated_at = $5, version = version + 1`)
+
 	t.Run("success edit user to db", func(t *testing.T) {
 		//given
-		storage, mock, cleanup := newTestStorage(t)
+		store, mock, cleanup := newTestStorage(t)
 		defer cleanup()
 
-		user := &UserDto{
-			ID:        uuid.New(),
-			Firstname: "Ivan",
-			Lastname:  "Ivanov",
-			Email:     "ivan@gmail.com",
-			Age:       30,
-		}
+		before := newUser()
+		user := *before
+		user.Email = "petr@gmail.com"
+		user.Version = 0
+		after := user
+		after.Version = 2
 
-		mock.ExpectExec(regexp.QuoteMeta(`UPDATE users SET firstname = $1, lastname = $2, email = $3, age = $4 WHERE id = $5`)).
-			WithArgs(user.Firstname, user.Lastname, user.Email, user.Age, user.ID).
-			WillReturnResult(sqlmock.NewResult(0, 1))
+		expectBegin(mock)
+		mock.ExpectQuery(lockQuery).WithArgs(user.ID, storage.DefaultTenant, false).WillReturnRows(userRows(before))
+		mock.ExpectQuery(editQuery).
+			WithArgs(user.Firstname, user.Lastname, user.Email, user.Age, sqlmock.AnyArg(), user.ID, storage.DefaultTenant).
+			WillReturnRows(userRows(&after))
+		mock.ExpectExec(auditQuery).
+			WithArgs(user.ID, storage.SystemActor, storage.AuditEdit, sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), storage.DefaultTenant).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec(outboxQuery).
+			WithArgs(storage.EventUserUpdated, user.ID, sqlmock.AnyArg(), sqlmock.AnyArg(), storage.DefaultTenant).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectCommit()
+
+		//when
+		updated, err := store.EditUser(context.Background(), user.ID, user.Patch(), user.Version)
+		//then
+		require.NoError(t, err)
+		assert.Equal(t, "petr@gmail.com", updated.Email)
+		assert.Equal(t, 2, updated.Version)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("updates only patched columns", func(t *testing.T) {
+		//given
+		store, mock, cleanup := newTestStorage(t)
+		defer cleanup()
+
+		before := newUser()
+		after := *before
+		after.Age = 31
+		after.Version = 2
+		age := 31
+
+		expectBegin(mock)
+		mock.ExpectQuery(lockQuery).WithArgs(before.ID, storage.DefaultTenant, false).WillReturnRows(userRows(before))
+		mock.ExpectQuery(regexp.QuoteMeta(`UPDATE users SET age = $1, updated_at = $2, version = version + 1`)).
+			WithArgs(31, sqlmock.AnyArg(), before.ID, storage.DefaultTenant).
+			WillReturnRows(userRows(&after))
+		mock.ExpectExec(auditQuery).
+			WithArgs(before.ID, storage.SystemActor, storage.AuditEdit, sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), storage.DefaultTenant).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec(outboxQuery).
+			WithArgs(storage.EventUserUpdated, before.ID, sqlmock.AnyArg(), sqlmock.AnyArg(), storage.DefaultTenant).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectCommit()
 
 		//when
-		updated, err := storage.EditUser(user)
+		updated, err := store.EditUser(context.Background(), before.ID, storage.UserPatch{Age: &age}, 1)
 		//then
 		require.NoError(t, err)
-		assert.Equal(t, user, updated)
+		assert.Equal(t, 31, updated.Age)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("returns user unchanged for empty patch", func(t *testing.T) {
+		//given
+		store, mock, cleanup := newTestStorage(t)
+		defer cleanup()
+
+		user := newUser()
+
+		expectBegin(mock)
+		mock.ExpectQuery(lockQuery).WithArgs(user.ID, storage.DefaultTenant, false).WillReturnRows(userRows(user))
+		mock.ExpectCommit()
+
+		//when
+		updated, err := store.EditUser(context.Background(), user.ID, storage.UserPatch{}, 0)
+		//then
+		require.NoError(t, err)
+		assert.Equal(t, user.Version, updated.Version)
+		assert.NoError(t, mock.ExpectationsWereMet())
 	})
 
-	t.Run("returns error when no rows updated", func(t *testing.T) {
+	t.Run("returns error when user not found", func(t *testing.T) {
 		// given
-		storage, mock, cleanup := newTestStorage(t)
+		store, mock, cleanup := newTestStorage(t)
 		defer cleanup()
 
-		user := &UserDto{
-			ID:        uuid.New(),
-			Firstname: "Ivan",
-			Lastname:  "Ivanov",
-			Email:     "ivan@gmail.com",
-			Age:       30,
-		}
+		user := newUser()
 
-		mock.ExpectExec(regexp.QuoteMeta(`UPDATE users SET firstname = $1, lastname = $2, email = $3, age = $4 WHERE id = $5`)).
-			WithArgs(user.Firstname, user.Lastname, user.Email, user.Age, user.ID).
-			WillReturnResult(sqlmock.NewResult(0, 0))
+		expectBegin(mock)
+		mock.ExpectQuery(lockQuery).WithArgs(user.ID, storage.DefaultTenant, false).WillReturnError(sql.ErrNoRows)
+		mock.ExpectRollback()
 
 		// when
-		updated, err := storage.EditUser(user)
+		updated, err := store.EditUser(context.Background(), user.ID, user.Patch(), user.Version)
 		// then
 		require.Nil(t, updated)
 		require.Error(t, err)
 		require.Contains(t, err.Error(), "user not found")
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("returns version conflict when stored version differs", func(t *testing.T) {
+		// given
+		store, mock, cleanup := newTestStorage(t)
+		defer cleanup()
+
+		stored := newUser()
+		stored.Version = 2
+		user := *stored
+		user.Version = 3
+
+		expectBegin(mock)
+		mock.ExpectQuery(lockQuery).WithArgs(user.ID, storage.DefaultTenant, false).WillReturnRows(userRows(stored))
+		mock.ExpectRollback()
+
+		// when
+		updated, err := store.EditUser(context.Background(), user.ID, user.Patch(), user.Version)
+		// then
+		require.Nil(t, updated)
+		require.ErrorIs(t, err, storage.ErrVersionConflict)
+		require.NoError(t, mock.ExpectationsWereMet())
 	})
 }
 
 func TestStorageDeleteUser(t *testing.T) {
+	deleteQuery := regexp.QuoteMeta(`UPDATE users SET deleted_at = $1, updated_at = $1, version = version + 1`)
+
 	t.Run("success delete user to db", func(t *testing.T) {
 		//given
-		storage, mock, cleanup := newTestStorage(t)
+		store, mock, cleanup := newTestStorage(t)
 		defer cleanup()
 
-		id := uuid.New()
+		user := newUser()
+		deleted := *user
+		deletedAt := time.Now()
+		deleted.Deleted = &deletedAt
+		deleted.Version = 2
 
-		mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM users WHERE id = $1`)).
-			WithArgs(id).
-			WillReturnResult(sqlmock.NewResult(0, 1))
+		expectBegin(mock)
+		mock.ExpectQuery(lockQuery).WithArgs(user.ID, storage.DefaultTenant, false).WillReturnRows(userRows(user))
+		mock.ExpectQuery(deleteQuery).
+			WithArgs(sqlmock.AnyArg(), user.ID, storage.DefaultTenant).
+			WillReturnRows(userRows(&deleted))
+		mock.ExpectExec(auditQuery).
+			WithArgs(user.ID, storage.SystemActor, storage.AuditDelete, sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), storage.DefaultTenant).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec(outboxQuery).
+			WithArgs(storage.EventUserDeleted, user.ID, sqlmock.AnyArg(), sqlmock.AnyArg(), storage.DefaultTenant).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectCommit()
 
 		//when
-		err := storage.DeleteUser(id)
+		err := store.DeleteUser(context.Background(), user.ID, 0)
 		//then
 		require.NoError(t, err)
 		assert.NoError(t, mock.ExpectationsWereMet())
 	})
 
-	t.Run("returns error when no rows deleted", func(t *testing.T) {
+	t.Run("returns error when user not found", func(t *testing.T) {
 		// given
-		storage, mock, cleanup := newTestStorage(t)
+		store, mock, cleanup := newTestStorage(t)
 		defer cleanup()
 
 		id := uuid.New()
 
-		mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM users WHERE id = $1`)).
-			WithArgs(id).
-			WillReturnResult(sqlmock.NewResult(0, 0))
+		expectBegin(mock)
+		mock.ExpectQuery(lockQuery).WithArgs(id, storage.DefaultTenant, false).WillReturnError(sql.ErrNoRows)
+		mock.ExpectRollback()
 
 		// when
-		err := storage.DeleteUser(id)
+		err := store.DeleteUser(context.Background(), id, 0)
 		// then
 		require.Error(t, err)
 		require.Contains(t, err.Error(), "user not found")
 	})
+
+	t.Run("returns version conflict when stored version differs", func(t *testing.T) {
+		// given
+		store, mock, cleanup := newTestStorage(t)
+		defer cleanup()
+
+		user := newUser()
+
+		expectBegin(mock)
+		mock.ExpectQuery(lockQuery).WithArgs(user.ID, storage.DefaultTenant, false).WillReturnRows(userRows(user))
+		mock.ExpectRollback()
+
+		// when
+		err := store.DeleteUser(context.Background(), user.ID, 2)
+		// then
+		require.ErrorIs(t, err, storage.ErrVersionConflict)
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestStorageRestoreUser(t *testing.T) {
+	restoreQuery := regexp.QuoteMeta(`UPDATE users SET deleted_at = NULL, updated_at = $1, version = version + 1`)
+
+	t.Run("success restore user", func(t *testing.T) {
+		//given
+		store, mock, cleanup := newTestStorage(t)
+		defer cleanup()
+
+		deleted := newUser()
+		deletedAt := time.Now()
+		deleted.Deleted = &deletedAt
+		deleted.Version = 2
+		restored := *deleted
+		restored.Deleted = nil
+		restored.Version = 3
+
+		expectBegin(mock)
+		mock.ExpectQuery(lockQuery).WithArgs(deleted.ID, storage.DefaultTenant, true).WillReturnRows(userRows(deleted))
+		mock.ExpectQuery(restoreQuery).WithArgs(sqlmock.AnyArg(), deleted.ID, storage.DefaultTenant).WillReturnRows(userRows(&restored))
+		mock.ExpectExec(auditQuery).
+			WithArgs(deleted.ID, storage.SystemActor, storage.AuditRestore, sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), storage.DefaultTenant).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec(outboxQuery).
+			WithArgs(storage.EventUserRestored, deleted.ID, sqlmock.AnyArg(), sqlmock.AnyArg(), storage.DefaultTenant).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectCommit()
+
+		//when
+		user, err := store.RestoreUser(context.Background(), deleted.ID)
+		//then
+		require.NoError(t, err)
+		assert.Nil(t, user.Deleted)
+		assert.Equal(t, 3, user.Version)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("returns error when no deleted user", func(t *testing.T) {
+		//given
+		store, mock, cleanup := newTestStorage(t)
+		defer cleanup()
+
+		id := uuid.New()
+
+		expectBegin(mock)
+		mock.ExpectQuery(lockQuery).WithArgs(id, storage.DefaultTenant, true).WillReturnError(sql.ErrNoRows)
+		mock.ExpectRollback()
+
+		//when
+		user, err := store.RestoreUser(context.Background(), id)
+		//then
+		require.Nil(t, user)
+		require.ErrorIs(t, err, storage.ErrUserNotFound)
+	})
+}
+
+func TestStoragePurgeDeleted(t *testing.T) {
+	t.Run("hard deletes users deleted before cutoff", func(t *testing.T) {
+		//given
+		store, mock, cleanup := newTestStorage(t)
+		defer cleanup()
+
+		before := time.Now()
+
+		expectScopedBegin(mock, storage.DefaultTenant, "on")
+		mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM users WHERE deleted_at < $1`)).
+			WithArgs(before).
+			WillReturnResult(sqlmock.NewResult(0, 4))
+		mock.ExpectCommit()
+
+		//when
+		purged, err := store.PurgeDeleted(context.Background(), before)
+		//then
+		require.NoError(t, err)
+		assert.Equal(t, int64(4), purged)
+	})
+}
+
+func TestStorageInsertUsers(t *testing.T) {
+	t.Run("inserts users in chunks into tenant", func(t *testing.T) {
+		//given
+		store, mock, cleanup := newTestStorage(t)
+		defer cleanup()
+
+		ctx := storage.WithTenant(context.Background(), "acme")
+		users := make([]storage.UserDto, insertChunk+1)
+		for i := range users {
+			users[i] = *storage.NewUser(uuid.New(), "Ivan", "Ivanov", fmt.Sprintf("ivan%d@gmail.com", i), 30)
+		}
+		last := users[insertChunk]
+
+		expectScopedBegin(mock, "acme", "off")
+		expectNoForeign(mock)
+		mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO users (` + userColumns + `) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10), ($11,`)).
+			WillReturnResult(sqlmock.NewResult(0, insertChunk))
+		expectNoForeign(mock)
+		mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO users (`+userColumns+`) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)`)).
+			WithArgs(last.ID, "acme", "Ivan", "Ivanov", last.Email, 30, last.Created, last.Updated, 1, nil).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		//when
+		err := store.InsertUsers(ctx, users)
+		//then
+		require.NoError(t, err)
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("rolls back all chunks on conflict", func(t *testing.T) {
+		//given
+		store, mock, cleanup := newTestStorage(t)
+		defer cleanup()
+
+		users := []storage.UserDto{*storage.NewUser(uuid.New(), "Ivan", "Ivanov", "ivan@gmail.com", 30)}
+
+		expectBegin(mock)
+		expectNoForeign(mock)
+		mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO users`)).
+			WillReturnError(&pq.Error{Code: "23505", Constraint: "users_tenant_email_lower_key"})
+		mock.ExpectRollback()
+
+		//when
+		err := store.InsertUsers(context.Background(), users)
+		//then
+		require.ErrorIs(t, err, storage.ErrEmailConflict)
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("does nothing without users", func(t *testing.T) {
+		//given
+		store, mock, cleanup := newTestStorage(t)
+		defer cleanup()
+		//when
+		err := store.InsertUsers(context.Background(), nil)
+		//then
+		require.NoError(t, err)
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestStorageImportUsers(t *testing.T) {
+	t.Run("skips conflicting users", func(t *testing.T) {
+		//given
+		store, mock, cleanup := newTestStorage(t)
+		defer cleanup()
+
+		users := []storage.UserDto{
+			*storage.NewUser(uuid.New(), "Ivan", "Ivanov", "ivan@gmail.com", 30),
+			*storage.NewUser(uuid.New(), "Petr", "Petrov", "petr@gmail.com", 31),
+		}
+
+		expectBegin(mock)
+		expectNoForeign(mock)
+		mock.ExpectExec(regexp.QuoteMeta(`($11, $12, $13, $14, $15, $16, $17, $18, $19, $20) ON CONFLICT DO NOTHING`)).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		//when
+		result, err := store.ImportUsers(context.Background(), users, ConflictSkip, nil)
+		//then
+		require.NoError(t, err)
+		assert.Equal(t, ImportResult{Inserted: 1, Skipped: 1}, result)
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("replaces users with same id or email", func(t *testing.T) {
+		//given
+		store, mock, cleanup := newTestStorage(t)
+		defer cleanup()
+
+		user := *storage.NewUser(uuid.New(), "Ivan", "Ivanov", "Ivan@gmail.com", 30)
+
+		expectBegin(mock)
+		expectNoForeign(mock)
+		mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM users WHERE tenant_id = $1 AND (id = ANY($2::uuid[]) OR lower(email) = ANY($3::text[]))`)).
+			WithArgs(storage.DefaultTenant, pq.Array([]string{user.ID.String()}), pq.Array([]string{"ivan@gmail.com"})).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO users`)).
+			WithArgs(user.ID, storage.DefaultTenant, "Ivan", "Ivanov", "Ivan@gmail.com", 30, user.Created, user.Updated, 1, nil).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		//when
+		result, err := store.ImportUsers(context.Background(), []storage.UserDto{user}, ConflictOverwrite, nil)
+		//then
+		require.NoError(t, err)
+		assert.Equal(t, ImportResult{Inserted: 1}, result)
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("skips and reports ids of other tenants", func(t *testing.T) {
+		//given
+		store, mock, cleanup := newTestStorage(t)
+		defer cleanup()
+
+		foreign := *storage.NewUser(uuid.New(), "Ivan", "Ivanov", "ivan@gmail.com", 30)
+		user := *storage.NewUser(uuid.New(), "Petr", "Petrov", "petr@gmail.com", 31)
+
+		expectBegin(mock)
+		expectForeign(mock, foreign.ID)
+		mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO users`)).
+			WithArgs(user.ID, storage.DefaultTenant, "Petr", "Petrov", "petr@gmail.com", 31, user.Created, user.Updated, 1, nil).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		//when
+		result, err := store.ImportUsers(context.Background(), []storage.UserDto{foreign, user}, ConflictSkip, nil)
+		//then
+		require.NoError(t, err)
+		assert.Equal(t, ImportResult{Inserted: 1, Skipped: 1, Foreign: []uuid.UUID{foreign.ID}}, result)
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("fails on ids of other tenants instead of overwriting", func(t *testing.T) {
+		//given
+		store, mock, cleanup := newTestStorage(t)
+		defer cleanup()
+
+		foreign := *storage.NewUser(uuid.New(), "Ivan", "Ivanov", "ivan@gmail.com", 30)
+
+		expectBegin(mock)
+		expectForeign(mock, foreign.ID)
+		mock.ExpectRollback()
+
+		//when
+		_, err := store.ImportUsers(context.Background(), []storage.UserDto{foreign}, ConflictOverwrite, nil)
+		//then
+		require.ErrorIs(t, err, ErrForeignID)
+		assert.ErrorContains(t, err, foreign.ID.String())
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestStorageImportCheckpoint(t *testing.T) {
+	t.Run("saves checkpoint in transaction of batch", func(t *testing.T) {
+		//given
+		store, mock, cleanup := newTestStorage(t)
+		defer cleanup()
+
+		ctx := storage.WithTenant(context.Background(), "acme")
+		user := *storage.NewUser(uuid.New(), "Ivan", "Ivanov", "ivan@gmail.com", 30)
+
+		expectScopedBegin(mock, "acme", "off")
+		expectNoForeign(mock)
+		mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO users`)).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(regexp.QuoteMeta(saveCheckpointQuery)).
+			WithArgs("acme", "/tmp/users.ndjson", 1).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		//when
+		_, err := store.ImportUsers(ctx, []storage.UserDto{user}, ConflictFail, &Checkpoint{Key: "/tmp/users.ndjson", Line: 1})
+		//then
+		require.NoError(t, err)
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("does not save checkpoint of failed batch", func(t *testing.T) {
+		//given
+		store, mock, cleanup := newTestStorage(t)
+		defer cleanup()
+
+		user := *storage.NewUser(uuid.New(), "Ivan", "Ivanov", "ivan@gmail.com", 30)
+
+		expectBegin(mock)
+		expectNoForeign(mock)
+		mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO users`)).
+			WillReturnError(&pq.Error{Code: "23505", Constraint: "users_tenant_email_lower_key"})
+		mock.ExpectRollback()
+
+		//when
+		_, err := store.ImportUsers(context.Background(), []storage.UserDto{user}, ConflictFail, &Checkpoint{Key: "/tmp/users.ndjson", Line: 1})
+		//then
+		require.ErrorIs(t, err, storage.ErrEmailConflict)
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("reads checkpoint of tenant", func(t *testing.T) {
+		//given
+		store, mock, cleanup := newTestStorage(t)
+		defer cleanup()
+
+		ctx := storage.WithTenant(context.Background(), "acme")
+
+		expectScopedBegin(mock, "acme", "off")
+		mock.ExpectQuery(regexp.QuoteMeta(`SELECT line FROM import_checkpoints WHERE tenant_id = $1 AND key = $2`)).
+			WithArgs("acme", "/tmp/users.ndjson").
+			WillReturnRows(sqlmock.NewRows([]string{"line"}).AddRow(2000))
+		mock.ExpectCommit()
+
+		//when
+		line, err := store.ImportCheckpoint(ctx, "/tmp/users.ndjson")
+		//then
+		require.NoError(t, err)
+		assert.Equal(t, 2000, line)
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("starts from beginning without checkpoint", func(t *testing.T) {
+		//given
+		store, mock, cleanup := newTestStorage(t)
+		defer cleanup()
+
+		expectBegin(mock)
+		mock.ExpectQuery(regexp.QuoteMeta(`SELECT line FROM import_checkpoints`)).
+			WillReturnRows(sqlmock.NewRows([]string{"line"}))
+		mock.ExpectCommit()
+
+		//when
+		line, err := store.ImportCheckpoint(context.Background(), "/tmp/users.ndjson")
+		//then
+		require.NoError(t, err)
+		assert.Equal(t, 0, line)
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestStorageExportUsers(t *testing.T) {
+	t.Run("pages through users of tenant by id", func(t *testing.T) {
+		//given
+		store, mock, cleanup := newTestStorage(t)
+		defer cleanup()
+
+		ctx := storage.WithTenant(context.Background(), "acme")
+		first := make([]*storage.UserDto, exportPage)
+		for i := range first {
+			first[i] = storage.NewUser(uuid.New(), "Ivan", "Ivanov", fmt.Sprintf("ivan%d@gmail.com", i), 30)
+			first[i].Tenant = "acme"
+		}
+		deleted := time.Now().UTC()
+		last := storage.NewUser(uuid.New(), "Petr", "Petrov", "petr@gmail.com", 31)
+		last.Tenant = "acme"
+		last.Deleted = &deleted
+
+		expectScopedBegin(mock, "acme", "off")
+		mock.ExpectQuery(regexp.QuoteMeta(exportQuery)).
+			WithArgs("acme", uuid.Nil, exportPage).
+			WillReturnRows(userRows(first...))
+		mock.ExpectCommit()
+		expectScopedBegin(mock, "acme", "off")
+		mock.ExpectQuery(regexp.QuoteMeta(exportQuery)).
+			WithArgs("acme", first[exportPage-1].ID, exportPage).
+			WillReturnRows(userRows(last))
+		mock.ExpectCommit()
+
+		//when
+		var exported []storage.UserDto
+		err := store.ExportUsers(ctx, func(user storage.UserDto) error {
+			exported = append(exported, user)
+			return nil
+		})
+		//then
+		require.NoError(t, err)
+		require.Len(t, exported, exportPage+1)
+		assert.Equal(t, last.ID, exported[exportPage].ID)
+		assert.Equal(t, &deleted, exported[exportPage].Deleted)
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("stops on callback error", func(t *testing.T) {
+		//given
+		store, mock, cleanup := newTestStorage(t)
+		defer cleanup()
+
+		user := storage.NewUser(uuid.New(), "Ivan", "Ivanov", "ivan@gmail.com", 30)
+		user.Tenant = storage.DefaultTenant
+		stop := errors.New("stop")
+
+		expectRead(mock, regexp.QuoteMeta(exportQuery), storage.DefaultTenant, uuid.Nil, exportPage).
+			WillReturnRows(userRows(user))
+		mock.ExpectCommit()
+
+		//when
+		err := store.ExportUsers(context.Background(), func(storage.UserDto) error { return stop })
+		//then
+		require.ErrorIs(t, err, stop)
+	})
+}
+
+func TestStorageWipeUsers(t *testing.T) {
+	t.Run("removes users of tenant with all their history and events", func(t *testing.T) {
+		//given
+		store, mock, cleanup := newTestStorage(t)
+		defer cleanup()
+
+		ctx := storage.WithTenant(context.Background(), "acme")
+
+		expectScopedBegin(mock, "acme", "off")
+		mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM user_audit WHERE tenant_id = $1`) + `$`).
+			WithArgs("acme").
+			WillReturnResult(sqlmock.NewResult(0, 9))
+		mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM outbox WHERE tenant_id = $1`) + `$`).
+			WithArgs("acme").
+			WillReturnResult(sqlmock.NewResult(0, 9))
+		mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM users WHERE tenant_id = $1`)).
+			WithArgs("acme").
+			WillReturnResult(sqlmock.NewResult(0, 3))
+		mock.ExpectCommit()
+
+		//when
+		wiped, err := store.WipeUsers(ctx)
+		//then
+		require.NoError(t, err)
+		assert.Equal(t, int64(3), wiped)
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("keeps history and events when removing users fails", func(t *testing.T) {
+		//given
+		store, mock, cleanup := newTestStorage(t)
+		defer cleanup()
+
+		expectBegin(mock)
+		mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM user_audit`)).WillReturnResult(sqlmock.NewResult(0, 9))
+		mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM outbox`)).WillReturnResult(sqlmock.NewResult(0, 9))
+		mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM users`)).WillReturnError(&pq.Error{Code: "57014"})
+		mock.ExpectRollback()
+
+		//when
+		_, err := store.WipeUsers(context.Background())
+		//then
+		require.Error(t, err)
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestStorageListUsers(t *testing.T) {
+	listQuery := regexp.QuoteMeta(`SELECT ` + userColumns + ` FROM users WHERE tenant_id = $1 AND deleted_at IS NULL`)
+
+	t.Run("reads page after cursor with total", func(t *testing.T) {
+		//given
+		store, mock, cleanup := newTestStorage(t)
+		defer cleanup()
+
+		cursor := storage.Sort(nil).CursorOf(*storage.NewUser(uuid.New(), "Anna", "Smith", "anna@gmail.com", 20))
+		first := storage.NewUser(uuid.New(), "Ivan", "Ivanov", "ivan@gmail.com", 30)
+		second := storage.NewUser(uuid.New(), "Petr", "Petrov", "petr@gmail.com", 31)
+
+		expectRead(mock, listQuery+regexp.QuoteMeta(` AND (created, id) > ($2, $3) ORDER BY created, id LIMIT $4`),
+			storage.DefaultTenant, cursor.Values[0], cursor.Values[1], 2).
+			WillReturnRows(userRows(first, second))
+		mock.ExpectQuery(regexp.QuoteMeta(`SELECT count(*) FROM users WHERE tenant_id = $1 AND deleted_at IS NULL`)).
+			WithArgs(storage.DefaultTenant).
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(7))
+		mock.ExpectCommit()
+
+		//when
+		page, err := store.ListUsers(context.Background(), storage.ListQuery{Limit: 1, After: &cursor, WithTotal: true})
+		//then
+		require.NoError(t, err)
+		require.Len(t, page.Users, 1)
+		assert.Equal(t, first.ID, page.Users[0].ID)
+		assert.Equal(t, first.ID, page.Next.Values[1])
+		assert.Equal(t, first.ID, page.Prev.Values[1])
+		assert.Equal(t, int64(7), *page.Total)
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("reads page before cursor in reverse", func(t *testing.T) {
+		//given
+		store, mock, cleanup := newTestStorage(t)
+		defer cleanup()
+
+		cursor := storage.Sort(nil).CursorOf(*storage.NewUser(uuid.New(), "Anna", "Smith", "anna@gmail.com", 20))
+		newer := storage.NewUser(uuid.New(), "Ivan", "Ivanov", "ivan@gmail.com", 30)
+		older := storage.NewUser(uuid.New(), "Petr", "Petrov", "petr@gmail.com", 31)
+
+		expectRead(mock, listQuery+regexp.QuoteMeta(` AND (created, id) < ($2, $3) ORDER BY created DESC, id DESC LIMIT $4`),
+			storage.DefaultTenant, cursor.Values[0], cursor.Values[1], 3).
+			WillReturnRows(userRows(newer, older))
+		mock.ExpectCommit()
+
+		//when
+		page, err := store.ListUsers(context.Background(), storage.ListQuery{Limit: 2, Before: &cursor})
+		//then
+		require.NoError(t, err)
+		require.Len(t, page.Users, 2)
+		assert.Equal(t, older.ID, page.Users[0].ID)
+		assert.Equal(t, newer.ID, page.Next.Values[1])
+		assert.Nil(t, page.Prev)
+		assert.Nil(t, page.Total)
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("compiles filters and mixed sort into parameters", func(t *testing.T) {
+		//given
+		store, mock, cleanup := newTestStorage(t)
+		defer cleanup()
+
+		domain, err := storage.ParseCondition("email", "domain", "Пример.рф")
+		require.NoError(t, err)
+		prefix, err := storage.ParseCondition("lastname", "prefix", "O'Br_")
+		require.NoError(t, err)
+		minAge, err := storage.ParseCondition("age", "gte", "18")
+		require.NoError(t, err)
+		from, err := storage.ParseCondition("created", "gte", "2024-05-06")
+		require.NoError(t, err)
+		sort, err := storage.ParseSort("-age,lastname")
+		require.NoError(t, err)
+		cursor := sort.CursorOf(*storage.NewUser(uuid.New(), "Anna", "Smith", "anna@gmail.com", 20))
+
+		expectRead(mock, listQuery+regexp.QuoteMeta(
+			` AND lower(email) LIKE lower($2) ESCAPE '\'`+
+				` AND lower(lastname) LIKE lower($3) ESCAPE '\'`+
+				` AND age >= $4`+
+				` AND created >= $5`+
+				` AND ((age < $6) OR (age = $7 AND lastname > $8) OR (age = $9 AND lastname = $10 AND id > $11))`+
+				` ORDER BY age DESC, lastname, id LIMIT $12`),
+			storage.DefaultTenant, `%@xn--e1afmkfd.xn--p1ai`, `O'Br\_%`, 18, time.Date(2024, 5, 6, 0, 0, 0, 0, time.UTC),
+			20, 20, "Smith", 20, "Smith", cursor.Values[2], 11).
+			WillReturnRows(userRows())
+		mock.ExpectCommit()
+
+		//when
+		page, err := store.ListUsers(context.Background(), storage.ListQuery{
+			Limit:  10,
+			Filter: []storage.Condition{domain, prefix, minAge, from},
+			Sort:   sort,
+			After:  &cursor,
+		})
+		//then
+		require.NoError(t, err)
+		assert.Empty(t, page.Users)
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestStorageSearchUsers(t *testing.T) {
+	t.Run("ranks by similarity and highlights matches", func(t *testing.T) {
+		//given
+		store, mock, cleanup := newTestStorage(t)
+		defer cleanup()
+
+		best := newUser()
+		other := newUser()
+		other.Firstname, other.Lastname, other.Email = "Ivana", "Petrova", "ivana@gmail.com"
+
+		expectBegin(mock)
+		mock.ExpectExec(regexp.QuoteMeta(`SELECT set_config('pg_trgm.word_similarity_threshold', $1, true)`)).
+			WithArgs("0.3").
+			WillReturnResult(sqlmock.NewResult(0, 0))
+		rows := sqlmock.NewRows([]string{"id", "tenant_id", "firstname", "lastname", "email", "age", "created", "updated_at", "version", "deleted_at", "score"})
+		for i, user := range []*storage.UserDto{best, other} {
+			rows.AddRow(user.ID, user.Tenant, user.Firstname, user.Lastname, user.Email, user.Age, user.Created, user.Updated, user.Version, nil, 0.9-float64(i)/2)
+		}
+		mock.ExpectQuery(regexp.QuoteMeta(searchQuery)).
+			WithArgs(storage.DefaultTenant, "ivan ivnov", 10).
+			WillReturnRows(rows)
+		mock.ExpectCommit()
+
+		//when
+		results, err := store.SearchUsers(context.Background(), "Ivan Ivnov", 10)
+		//then
+		require.NoError(t, err)
+		require.Len(t, results, 2)
+		assert.Equal(t, best.ID, results[0].User.ID)
+		assert.InDelta(t, 0.9, results[0].Score, 1e-9)
+		assert.Equal(t, map[storage.Field]string{
+			storage.FieldFirstname: "<em>Ivan</em>",
+			storage.FieldLastname:  "<em>Ivanov</em>",
+			storage.FieldEmail:     "<em>ivan</em>@gmail.com",
+		}, results[0].Highlights)
+		assert.Equal(t, "<em>Ivana</em>", results[1].Highlights[storage.FieldFirstname])
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestStorageUserHistory(t *testing.T) {
+	t.Run("returns audit entries page", func(t *testing.T) {
+		//given
+		store, mock, cleanup := newTestStorage(t)
+		defer cleanup()
+
+		id := uuid.New()
+		created := time.Now()
+
+		expectRead(mock, regexp.QuoteMeta(`SELECT id, user_id, actor, operation, before, after, created FROM user_audit`), id, 10, 20, storage.DefaultTenant).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "actor", "operation", "before", "after", "created"}).
+				AddRow(2, id, "admin", storage.AuditEdit, []byte(`{"Age":30}`), []byte(`{"Age":31}`), created).
+				AddRow(1, id, "admin", storage.AuditCreate, nil, []byte(`{"Age":30}`), created))
+		mock.ExpectCommit()
+
+		//when
+		entries, err := store.UserHistory(context.Background(), id, 10, 20)
+		//then
+		require.NoError(t, err)
+		require.Len(t, entries, 2)
+		assert.Equal(t, storage.AuditEdit, entries[0].Operation)
+		assert.JSONEq(t, `{"Age":31}`, string(entries[0].After))
+		assert.Nil(t, entries[1].Before)
+	})
+}
+
+var getQuery = regexp.QuoteMeta(`SELECT id, tenant_id, firstname, lastname, email, age, created, updated_at, version, deleted_at FROM users WHERE id = $1 AND tenant_id = $2 AND deleted_at IS NULL`)
+
+func newTestReplicas(t *testing.T, store *Storage, n int) []sqlmock.Sqlmock {
+	var dbs []*sql.DB
+	var mocks []sqlmock.Sqlmock
+	for i := 0; i < n; i++ {
+		db, mock, err := sqlmock.New(sqlmock.MonitorPingsOption(true))
+		require.NoError(t, err)
+		mock.ExpectPing()
+		dbs = append(dbs, db)
+		mocks = append(mocks, mock)
+	}
+	store.replicas = newReplicaSet(dbs, 0)
+	t.Cleanup(func() {
+		_ = store.replicas.Close()
+	})
+	return mocks
+}
+
+func TestStorageReplicas(t *testing.T) {
+	t.Run("reads from replicas round-robin", func(t *testing.T) {
+		//given
+		store, primary, cleanup := newTestStorage(t)
+		defer cleanup()
+		replicas := newTestReplicas(t, store, 2)
+
+		user := newUser()
+		for _, replica := range replicas {
+			expectRead(replica, getQuery, user.ID, storage.DefaultTenant).WillReturnRows(userRows(user))
+			replica.ExpectCommit()
+		}
+		//when
+		_, err1 := store.GetUser(context.Background(), user.ID)
+		_, err2 := store.GetUser(context.Background(), user.ID)
+		//then
+		require.NoError(t, err1)
+		require.NoError(t, err2)
+		for _, replica := range replicas {
+			assert.NoError(t, replica.ExpectationsWereMet())
+		}
+		assert.NoError(t, primary.ExpectationsWereMet())
+	})
+
+	t.Run("reads from primary when asked", func(t *testing.T) {
+		//given
+		store, primary, cleanup := newTestStorage(t)
+		defer cleanup()
+		replicas := newTestReplicas(t, store, 1)
+
+		user := newUser()
+		expectRead(primary, getQuery, user.ID, storage.DefaultTenant).WillReturnRows(userRows(user))
+		primary.ExpectCommit()
+		//when
+		_, err := store.GetUser(storage.WithPrimary(context.Background()), user.ID)
+		//then
+		require.NoError(t, err)
+		assert.NoError(t, primary.ExpectationsWereMet())
+		assert.NoError(t, replicas[0].ExpectationsWereMet())
+	})
+
+	t.Run("skips replicas failing health check", func(t *testing.T) {
+		//given
+		store, primary, cleanup := newTestStorage(t)
+		defer cleanup()
+		replicas := newTestReplicas(t, store, 1)
+
+		replicas[0].ExpectPing().WillReturnError(driver.ErrBadConn)
+		store.replicas.check()
+
+		user := newUser()
+		expectRead(primary, getQuery, user.ID, storage.DefaultTenant).WillReturnRows(userRows(user))
+		primary.ExpectCommit()
+		//when
+		_, err := store.GetUser(context.Background(), user.ID)
+		//then
+		require.NoError(t, err)
+		assert.NoError(t, primary.ExpectationsWereMet())
+	})
+
+	t.Run("falls back to primary when replica is unavailable", func(t *testing.T) {
+		//given
+		store, primary, cleanup := newTestStorage(t)
+		defer cleanup()
+		replicas := newTestReplicas(t, store, 1)
+
+		user := newUser()
+		expectRead(replicas[0], getQuery, user.ID, storage.DefaultTenant).WillReturnError(syscall.ECONNRESET)
+		replicas[0].ExpectRollback()
+		expectRead(primary, getQuery, user.ID, storage.DefaultTenant).WillReturnRows(userRows(user))
+		primary.ExpectCommit()
+		//when
+		got, err := store.GetUser(context.Background(), user.ID)
+		//then
+		require.NoError(t, err)
+		assert.Equal(t, user.ID, got.ID)
+		assert.False(t, store.replicas.replicas[0].healthy.Load())
+		assert.NoError(t, primary.ExpectationsWereMet())
+	})
+
+	t.Run("does not fall back when user is missing on replica", func(t *testing.T) {
+		//given
+		store, primary, cleanup := newTestStorage(t)
+		defer cleanup()
+		replicas := newTestReplicas(t, store, 1)
+
+		id := uuid.New()
+		expectRead(replicas[0], getQuery, id, storage.DefaultTenant).WillReturnError(sql.ErrNoRows)
+		replicas[0].ExpectRollback()
+		//when
+		_, err := store.GetUser(context.Background(), id)
+		//then
+		require.ErrorIs(t, err, storage.ErrUserNotFound)
+		assert.NoError(t, primary.ExpectationsWereMet())
+	})
+}
+
+func TestStorageWithTx(t *testing.T) {
+	t.Run("commits several changes at once", func(t *testing.T) {
+		//given
+		store, mock, cleanup := newTestStorage(t)
+		defer cleanup()
+
+		user := newUser()
+		expectBegin(mock)
+		mock.ExpectQuery(getQuery).WithArgs(user.ID, storage.DefaultTenant).WillReturnError(sql.ErrNoRows)
+		mock.ExpectQuery(insertQuery).WillReturnRows(userRows(user))
+		mock.ExpectExec(auditQuery).WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec(outboxQuery).WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec(regexp.QuoteMeta(`UPDATE counters SET users = users + 1`)).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+		//when
+		err := store.WithTx(context.Background(), func(tx Tx) error {
+			if _, err := tx.GetUser(context.Background(), user.ID); !errors.Is(err, storage.ErrUserNotFound) {
+				return err
+			}
+			if err := tx.CreateUser(context.Background(), user); err != nil {
+				return err
+			}
+			_, err := tx.ExecContext(context.Background(), `UPDATE counters SET users = users + 1`)
+			return err
+		}, WithIsolation(sql.LevelSerializable))
+		//then
+		require.NoError(t, err)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("rolls back when fn fails", func(t *testing.T) {
+		//given
+		store, mock, cleanup := newTestStorage(t)
+		defer cleanup()
+
+		failure := errors.New("boom")
+		expectBegin(mock)
+		mock.ExpectRollback()
+		//when
+		err := store.WithTx(context.Background(), func(tx Tx) error {
+			return failure
+		})
+		//then
+		require.ErrorIs(t, err, failure)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("rolls back and repanics when fn panics", func(t *testing.T) {
+		//given
+		store, mock, cleanup := newTestStorage(t)
+		defer cleanup()
+
+		expectBegin(mock)
+		mock.ExpectRollback()
+		//when
+		call := func() {
+			_ = store.WithTx(context.Background(), func(tx Tx) error {
+				panic("boom")
+			})
+		}
+		//then
+		assert.PanicsWithValue(t, "boom", call)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("retries serialization failures", func(t *testing.T) {
+		//given
+		store, mock, cleanup := newTestStorage(t)
+		defer cleanup()
+
+		expectBegin(mock)
+		mock.ExpectCommit().WillReturnError(&pq.Error{Code: "40001"})
+		expectBegin(mock)
+		mock.ExpectCommit()
+		calls := 0
+		//when
+		err := store.WithTx(context.Background(), func(tx Tx) error {
+			calls++
+			return nil
+		}, WithRetries(3))
+		//then
+		require.NoError(t, err)
+		assert.Equal(t, 2, calls)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("gives up after configured retries", func(t *testing.T) {
+		//given
+		store, mock, cleanup := newTestStorage(t)
+		defer cleanup()
+
+		expectBegin(mock)
+		mock.ExpectRollback()
+		calls := 0
+		//when
+		err := store.WithTx(context.Background(), func(tx Tx) error {
+			calls++
+			return mapError(&pq.Error{Code: "40P01"})
+		}, WithRetries(0))
+		//then
+		require.ErrorIs(t, err, storage.ErrSerialization)
+		assert.Equal(t, 1, calls)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestStorageRetry(t *testing.T) {
+	retry := config.Retry{MaxAttempts: 3, InitialBackoff: time.Millisecond, MaxBackoff: 2 * time.Millisecond}
+
+	t.Run("retries reads failing with transient errors", func(t *testing.T) {
+		//given
+		store, mock, cleanup := newTestStorage(t)
+		defer cleanup()
+		store.retry = retry
+
+		user := newUser()
+		expectRead(mock, getQuery, user.ID, storage.DefaultTenant).WillReturnError(syscall.ECONNRESET)
+		mock.ExpectRollback()
+		expectRead(mock, getQuery, user.ID, storage.DefaultTenant).WillReturnError(&pq.Error{Code: "57P01"})
+		mock.ExpectRollback()
+		expectRead(mock, getQuery, user.ID, storage.DefaultTenant).WillReturnRows(userRows(user))
+		mock.ExpectCommit()
+		//when
+		got, err := store.GetUser(context.Background(), user.ID)
+		//then
+		require.NoError(t, err)
+		assert.Equal(t, user.ID, got.ID)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("gives up after max attempts", func(t *testing.T) {
+		//given
+		store, mock, cleanup := newTestStorage(t)
+		defer cleanup()
+		store.retry = retry
+
+		id := uuid.New()
+		for i := 0; i < retry.MaxAttempts; i++ {
+			expectRead(mock, getQuery, id, storage.DefaultTenant).WillReturnError(syscall.ECONNRESET)
+			mock.ExpectRollback()
+		}
+		//when
+		_, err := store.GetUser(context.Background(), id)
+		//then
+		require.ErrorIs(t, err, storage.ErrUnavailable)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("does not retry permanent errors", func(t *testing.T) {
+		//given
+		store, mock, cleanup := newTestStorage(t)
+		defer cleanup()
+		store.retry = retry
+
+		user := newUser()
+		expectBegin(mock)
+		mock.ExpectQuery(insertQuery).WillReturnError(&pq.Error{Code: "23505", Constraint: "users_email_key"})
+		mock.ExpectRollback()
+		//when
+		err := store.CreateUser(context.Background(), user)
+		//then
+		require.ErrorIs(t, err, storage.ErrEmailConflict)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("retries writes that did not commit", func(t *testing.T) {
+		//given
+		store, mock, cleanup := newTestStorage(t)
+		defer cleanup()
+		store.retry = retry
+
+		user := newUser()
+		expectBegin(mock)
+		mock.ExpectQuery(insertQuery).WillReturnError(&pq.Error{Code: "40P01"})
+		mock.ExpectRollback()
+		expectBegin(mock)
+		mock.ExpectQuery(insertQuery).WillReturnRows(userRows(user))
+		mock.ExpectExec(auditQuery).WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec(outboxQuery).WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectCommit()
+		//when
+		err := store.CreateUser(context.Background(), user)
+		//then
+		require.NoError(t, err)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("does not retry commits with unknown outcome", func(t *testing.T) {
+		//given
+		store, mock, cleanup := newTestStorage(t)
+		defer cleanup()
+		store.retry = retry
+
+		user := newUser()
+		expectBegin(mock)
+		mock.ExpectQuery(insertQuery).WillReturnRows(userRows(user))
+		mock.ExpectExec(auditQuery).WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec(outboxQuery).WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectCommit().WillReturnError(syscall.ECONNRESET)
+		//when
+		err := store.CreateUser(context.Background(), user)
+		//then
+		require.ErrorIs(t, err, storage.ErrUnavailable)
+		require.ErrorIs(t, err, errCommitUnknown)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("backoff is capped", func(t *testing.T) {
+		//given
+		store := &Storage{retry: config.Retry{InitialBackoff: 100 * time.Millisecond, MaxBackoff: time.Second}}
+		//then
+		assert.LessOrEqual(t, store.backoff(1), 100*time.Millisecond)
+		assert.GreaterOrEqual(t, store.backoff(1), 50*time.Millisecond)
+		assert.LessOrEqual(t, store.backoff(10), time.Second)
+		assert.GreaterOrEqual(t, store.backoff(10), 500*time.Millisecond)
+	})
+}
+
+type hookKey struct{}
+
+type recordingHook struct {
+	events []QueryEvent
+	traced []bool
+}
+
+func (h *recordingHook) BeforeQuery(ctx context.Context, name string) context.Context {
+	return context.WithValue(ctx, hookKey{}, name)
+}
+
+func (h *recordingHook) AfterQuery(ctx context.Context, event QueryEvent) {
+	h.events = append(h.events, event)
+	h.traced = append(h.traced, ctx.Value(hookKey{}) == event.Name)
+}
+
+func TestStorageObserver(t *testing.T) {
+	t.Run("reports every statement to hooks", func(t *testing.T) {
+		//given
+		store, mock, cleanup := newTestStorage(t)
+		defer cleanup()
+		hook := &recordingHook{}
+		store.obs = &observer{hooks: []Hook{hook}}
+
+		user := newUser()
+		expectBegin(mock)
+		mock.ExpectQuery(insertQuery).WillReturnRows(userRows(user))
+		mock.ExpectExec(auditQuery).WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec(outboxQuery).WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectCommit()
+		expectRead(mock, getQuery, user.ID, storage.DefaultTenant).WillReturnError(sql.ErrNoRows)
+		mock.ExpectRollback()
+		//when
+		require.NoError(t, store.CreateUser(context.Background(), user))
+		_, err := store.GetUser(context.Background(), user.ID)
+		//then
+		require.ErrorIs(t, err, storage.ErrUserNotFound)
+		require.Len(t, hook.events, 4)
+		assert.Equal(t, "insert_user", hook.events[0].Name)
+		assert.Equal(t, int64(1), hook.events[0].Rows)
+		assert.Equal(t, "insert_audit", hook.events[1].Name)
+		assert.Equal(t, "insert_event", hook.events[2].Name)
+		assert.Equal(t, "get_user", hook.events[3].Name)
+		assert.Equal(t, int64(0), hook.events[3].Rows)
+		assert.ErrorIs(t, hook.events[3].Err, sql.ErrNoRows)
+		assert.Equal(t, []bool{true, true, true, true}, hook.traced)
+	})
+
+	t.Run("logs slow statements", func(t *testing.T) {
+		//given
+		store, mock, cleanup := newTestStorage(t)
+		defer cleanup()
+		var buf bytes.Buffer
+		store.obs = &observer{log: slog.New(slog.NewJSONHandler(&buf, nil)), threshold: 10 * time.Millisecond}
+
+		user := newUser()
+		expectRead(mock, getQuery, user.ID, storage.DefaultTenant).WillDelayFor(20 * time.Millisecond).WillReturnRows(userRows(user))
+		mock.ExpectCommit()
+		ctx := context.WithValue(context.Background(), middleware.RequestIDKey, "req-1")
+		//when
+		_, err := store.GetUser(ctx, user.ID)
+		//then
+		require.NoError(t, err)
+		var entry map[string]any
+		require.NoError(t, json.Unmarshal(buf.Bytes(), &entry))
+		assert.Equal(t, "slow query", entry["msg"])
+		assert.Equal(t, "WARN", entry["level"])
+		assert.Equal(t, "get_user", entry["statement"])
+		assert.Equal(t, "req-1", entry["request_id"])
+		assert.EqualValues(t, 1, entry["rows"])
+	})
+
+	t.Run("does not log fast statements", func(t *testing.T) {
+		//given
+		store, mock, cleanup := newTestStorage(t)
+		defer cleanup()
+		var buf bytes.Buffer
+		store.obs = &observer{log: slog.New(slog.NewJSONHandler(&buf, nil)), threshold: time.Minute}
+
+		user := newUser()
+		expectRead(mock, getQuery, user.ID, storage.DefaultTenant).WillReturnRows(userRows(user))
+		mock.ExpectCommit()
+		//when
+		_, err := store.GetUser(context.Background(), user.ID)
+		//then
+		require.NoError(t, err)
+		assert.Empty(t, buf.String())
+	})
+}
+
+func tenantRows(tenants ...storage.Tenant) *sqlmock.Rows {
+	rows := sqlmock.NewRows([]string{"id", "name", "created", "disabled_at"})
+	for _, tenant := range tenants {
+		var disabled driver.Value
+		if tenant.Disabled != nil {
+			disabled = *tenant.Disabled
+		}
+		rows.AddRow(tenant.ID, tenant.Name, tenant.Created, disabled)
+	}
+	return rows
+}
+
+func TestStorageTenants(t *testing.T) {
+	insertTenantQuery := regexp.QuoteMeta(`INSERT INTO tenants (id, name, created) VALUES ($1, $2, $3) RETURNING id, name, created, disabled_at`)
+	getTenantQuery := regexp.QuoteMeta(`SELECT id, name, created, disabled_at FROM tenants WHERE id = $1`)
+
+	t.Run("creates tenant", func(t *testing.T) {
+		//given
+		store, mock, cleanup := newTestStorage(t)
+		defer cleanup()
+
+		created := time.Now().UTC()
+		expectBegin(mock)
+		mock.ExpectQuery(insertTenantQuery).
+			WithArgs("acme", "Acme", sqlmock.AnyArg()).
+			WillReturnRows(tenantRows(storage.Tenant{ID: "acme", Name: "Acme", Created: created}))
+		mock.ExpectCommit()
+		tenant := &storage.Tenant{ID: "acme", Name: "Acme"}
+		//when
+		err := store.CreateTenant(context.Background(), tenant)
+		//then
+		require.NoError(t, err)
+		assert.True(t, created.Equal(tenant.Created))
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("returns tenant exists on duplicate id", func(t *testing.T) {
+		//given
+		store, mock, cleanup := newTestStorage(t)
+		defer cleanup()
+
+		expectBegin(mock)
+		mock.ExpectQuery(insertTenantQuery).
+			WillReturnError(&pq.Error{Code: "23505", Constraint: "tenants_pkey"})
+		mock.ExpectRollback()
+		//when
+		err := store.CreateTenant(context.Background(), &storage.Tenant{ID: "acme", Name: "Acme"})
+		//then
+		require.ErrorIs(t, err, storage.ErrTenantExists)
+	})
+
+	t.Run("does not retry tenant creation with unknown commit outcome", func(t *testing.T) {
+		//given
+		store, mock, cleanup := newTestStorage(t)
+		defer cleanup()
+		store.retry = config.Retry{MaxAttempts: 3}
+
+		expectBegin(mock)
+		mock.ExpectQuery(insertTenantQuery).
+			WillReturnRows(tenantRows(storage.Tenant{ID: "acme", Name: "Acme", Created: time.Now().UTC()}))
+		mock.ExpectCommit().WillReturnError(syscall.ECONNRESET)
+		//when
+		err := store.CreateTenant(context.Background(), &storage.Tenant{ID: "acme", Name: "Acme"})
+		//then
+		require.ErrorIs(t, err, errCommitUnknown)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("returns tenant not found", func(t *testing.T) {
+		//given
+		store, mock, cleanup := newTestStorage(t)
+		defer cleanup()
+
+		mock.ExpectQuery(getTenantQuery).WithArgs("acme").WillReturnError(sql.ErrNoRows)
+		//when
+		tenant, err := store.GetTenant(context.Background(), "acme")
+		//then
+		require.Nil(t, tenant)
+		require.ErrorIs(t, err, storage.ErrTenantNotFound)
+	})
+
+	t.Run("lists tenants", func(t *testing.T) {
+		//given
+		store, mock, cleanup := newTestStorage(t)
+		defer cleanup()
+
+		now := time.Now().UTC()
+		mock.ExpectQuery(regexp.QuoteMeta(`SELECT id, name, created, disabled_at FROM tenants ORDER BY id`)).
+			WillReturnRows(tenantRows(
+				storage.Tenant{ID: "acme", Name: "Acme", Created: now, Disabled: &now},
+				storage.Tenant{ID: storage.DefaultTenant, Name: "Default", Created: now},
+			))
+		//when
+		tenants, err := store.ListTenants(context.Background())
+		//then
+		require.NoError(t, err)
+		require.Len(t, tenants, 2)
+		assert.NotNil(t, tenants[0].Disabled)
+		assert.Nil(t, tenants[1].Disabled)
+	})
+
+	t.Run("disables tenant", func(t *testing.T) {
+		//given
+		store, mock, cleanup := newTestStorage(t)
+		defer cleanup()
+
+		now := time.Now().UTC()
+		mock.ExpectQuery(regexp.QuoteMeta(`UPDATE tenants SET disabled_at = COALESCE(disabled_at, $2)`)).
+			WithArgs("acme", sqlmock.AnyArg()).
+			WillReturnRows(tenantRows(storage.Tenant{ID: "acme", Name: "Acme", Created: now, Disabled: &now}))
+		//when
+		tenant, err := store.DisableTenant(context.Background(), "acme")
+		//then
+		require.NoError(t, err)
+		require.NotNil(t, tenant.Disabled)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+// TestConformance runs against the database named by the POSTGRES_*
+// variables and empties its tables, so it only runs when
+// POSTGRES_CONFORMANCE=true.
+func TestConformance(t *testing.T) {
+	if os.Getenv("POSTGRES_CONFORMANCE") != "true" {
+		t.Skip("set POSTGRES_CONFORMANCE=true to run against a real database")
+	}
+
+	var cfg config.Postgres
+	require.NoError(t, cleanenv.ReadEnv(&cfg))
+
+	storagetest.Run(t, func(t *testing.T) storage.UserStorage {
+		store, err := New(slog.Default(), cfg)
+		require.NoError(t, err)
+		t.Cleanup(func() {
+			_ = store.Close()
+		})
+		require.NoError(t, store.MigrateUp(context.Background()))
+
+		_, err = store.db.Exec(`TRUNCATE users, user_audit, outbox`)
+		require.NoError(t, err)
+		_, err = store.db.Exec(`DELETE FROM tenants WHERE id <> $1`, storage.DefaultTenant)
+		require.NoError(t, err)
+
+		return store
+	})
 }
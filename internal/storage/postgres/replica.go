@@ -0,0 +1,119 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"test_golang_user_api/internal/storage"
+	"time"
+)
+
+type replica struct {
+	db      *sql.DB
+	healthy atomic.Bool
+}
+
+// replicaSet hands out healthy replicas round-robin. Replicas are pinged
+// every interval, and a replica failing a query is skipped until it answers
+// a ping again.
+type replicaSet struct {
+	replicas []*replica
+	next     atomic.Uint64
+	stop     chan struct{}
+	done     sync.WaitGroup
+}
+
+func newReplicaSet(dbs []*sql.DB, interval time.Duration) *replicaSet {
+	set := &replicaSet{stop: make(chan struct{})}
+	for _, db := range dbs {
+		r := &replica{db: db}
+		r.healthy.Store(true)
+		set.replicas = append(set.replicas, r)
+	}
+
+	set.check()
+
+	if interval > 0 && len(set.replicas) > 0 {
+		set.done.Add(1)
+		go set.run(interval)
+	}
+
+	return set
+}
+
+func (s *replicaSet) run(interval time.Duration) {
+	defer s.done.Done()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+			s.check()
+		}
+	}
+}
+
+func (s *replicaSet) check() {
+	for _, r := range s.replicas {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		r.healthy.Store(r.db.PingContext(ctx) == nil)
+		cancel()
+	}
+}
+
+// pick returns the next healthy replica, or nil when none is available.
+func (s *replicaSet) pick() *replica {
+	n := len(s.replicas)
+	if n == 0 {
+		return nil
+	}
+
+	start := s.next.Add(1)
+	for i := 0; i < n; i++ {
+		r := s.replicas[(start+uint64(i))%uint64(n)]
+		if r.healthy.Load() {
+			return r
+		}
+	}
+
+	return nil
+}
+
+func (s *replicaSet) Close() error {
+	close(s.stop)
+	s.done.Wait()
+
+	var errs []error
+	for _, r := range s.replicas {
+		errs = append(errs, r.db.Close())
+	}
+	return errors.Join(errs...)
+}
+
+// read runs fn against a replica unless ctx asks for the primary or no
+// replica is healthy. A replica that turns out to be unreachable is marked
+// down and fn is retried on the primary.
+func (s *Storage) read(ctx context.Context, fn func(db *sql.DB) error) error {
+	if s.replicas == nil || storage.PrimaryFrom(ctx) {
+		return fn(s.db)
+	}
+
+	r := s.replicas.pick()
+	if r == nil {
+		return fn(s.db)
+	}
+
+	err := fn(r.db)
+	if errors.Is(err, storage.ErrUnavailable) && ctx.Err() == nil {
+		r.healthy.Store(false)
+		return fn(s.db)
+	}
+
+	return err
+}
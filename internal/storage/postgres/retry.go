@@ -0,0 +1,68 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"test_golang_user_api/internal/storage"
+	"time"
+)
+
+// errCommitUnknown marks a commit that failed in a way that leaves its
+// outcome unknown, e.g. the connection dropped after COMMIT was sent. Such
+// a transaction may have been applied, so it is never retried.
+var errCommitUnknown = errors.New("commit outcome unknown")
+
+// retryable reports whether err is transient and the failed call is known
+// to have had no effect.
+func retryable(err error) bool {
+	if errors.Is(err, errCommitUnknown) {
+		return false
+	}
+	return errors.Is(err, storage.ErrUnavailable) || errors.Is(err, storage.ErrSerialization)
+}
+
+// withRetry calls fn up to attempts times while it fails with a retryable
+// error, backing off between calls. Only operations that are safe to repeat
+// go through here: reads, transactions that did not commit and idempotent
+// single statements.
+func (s *Storage) withRetry(ctx context.Context, attempts int, fn func() error) error {
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil || attempt >= attempts || !retryable(err) {
+			return err
+		}
+
+		if sleep(ctx, s.backoff(attempt)) != nil {
+			return err
+		}
+	}
+}
+
+// backoff grows exponentially from InitialBackoff up to MaxBackoff, with
+// jitter so clients failing together do not retry in lockstep.
+func (s *Storage) backoff(attempt int) time.Duration {
+	delay := s.retry.InitialBackoff
+	for i := 1; i < attempt && (s.retry.MaxBackoff <= 0 || delay < s.retry.MaxBackoff); i++ {
+		delay *= 2
+	}
+	if s.retry.MaxBackoff > 0 && delay > s.retry.MaxBackoff {
+		delay = s.retry.MaxBackoff
+	}
+	if delay <= 0 {
+		return 0
+	}
+	return delay/2 + rand.N(delay/2+1)
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,88 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"test_golang_user_api/internal/storage"
+	"test_golang_user_api/internal/storage/fuzzy"
+)
+
+// searchDocument must match the expression of users_search_trgm_idx for
+// the index to be used.
+const searchDocument = `lower(firstname || ' ' || lastname || ' ' || email)`
+
+// searchQuery matches users by trigrams, which tolerates typos, or by the
+// words of the search column; whole-word matches rank higher.
+const searchQuery = `SELECT ` + userColumns + `,
+       word_similarity($2, ` + searchDocument + `) + ts_rank(search, plainto_tsquery('simple', $2)) AS score
+  FROM users
+ WHERE tenant_id = $1 AND deleted_at IS NULL
+   AND ($2 <% ` + searchDocument + ` OR search @@ plainto_tsquery('simple', $2))
+ ORDER BY score DESC, id
+ LIMIT $3`
+
+func (s *Storage) SearchUsers(ctx context.Context, query string, limit int) ([]storage.SearchResult, error) {
+	ctx, cancel := storage.WithTimeout(ctx, s.timeouts.Get)
+	defer cancel()
+
+	var results []storage.SearchResult
+	err := s.withRetry(ctx, s.retry.MaxAttempts, func() error {
+		return s.readTx(ctx, func(tx txn) error {
+			var err error
+			results, err = searchUsers(ctx, tx, query, limit)
+			return err
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range results {
+		results[i].Highlights = fuzzy.Highlight(query, results[i].User)
+	}
+	return results, nil
+}
+
+// scoredRow scans the score after the user columns.
+type scoredRow struct {
+	rowScanner
+	score *float64
+}
+
+func (r scoredRow) Scan(dest ...any) error {
+	return r.rowScanner.Scan(append(dest, r.score)...)
+}
+
+func searchUsers(ctx context.Context, tx txn, query string, limit int) (results []storage.SearchResult, err error) {
+	threshold := strconv.FormatFloat(fuzzy.Threshold, 'f', -1, 64)
+	if _, err := tx.ExecContext(ctx, `SELECT set_config('pg_trgm.word_similarity_threshold', $1, true)`, threshold); err != nil {
+		return nil, fmt.Errorf("failed to set similarity threshold: %w", mapError(err))
+	}
+
+	results = make([]storage.SearchResult, 0, limit)
+	qctx, done := tx.obs.start(ctx, "search_users")
+	defer func() { done(int64(len(results)), err) }()
+
+	rows, err := tx.QueryContext(qctx, searchQuery, tx.tenant, strings.ToLower(query), limit)
+	if err != nil {
+		return nil, fmt.Errorf("query failed: %w", mapError(err))
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var score float64
+		user, err := scanUser(scoredRow{rowScanner: rows, score: &score})
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan user: %w", mapError(err))
+		}
+		results = append(results, storage.SearchResult{User: *user, Score: score})
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("query failed: %w", mapError(err))
+	}
+
+	return results, nil
+}
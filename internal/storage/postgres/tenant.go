@@ -0,0 +1,139 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"test_golang_user_api/internal/storage"
+	"time"
+)
+
+const tenantColumns = `id, name, created, disabled_at`
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanTenant(row rowScanner) (*storage.Tenant, error) {
+	var tenant storage.Tenant
+	var disabled sql.NullTime
+	if err := row.Scan(&tenant.ID, &tenant.Name, &tenant.Created, &disabled); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, storage.ErrTenantNotFound
+		}
+		return nil, err
+	}
+	tenant.Created = tenant.Created.UTC()
+	if disabled.Valid {
+		at := disabled.Time.UTC()
+		tenant.Disabled = &at
+	}
+	return &tenant, nil
+}
+
+// CreateTenant inserts the tenant in a transaction, so that it is only
+// retried when known not to have committed: a retry after a lost commit
+// would fail with ErrTenantExists for the tenant it just created.
+func (s *Storage) CreateTenant(ctx context.Context, tenant *storage.Tenant) error {
+	ctx, cancel := storage.WithTimeout(ctx, s.timeouts.Create)
+	defer cancel()
+
+	query := `INSERT INTO tenants (id, name, created) VALUES ($1, $2, $3) RETURNING ` + tenantColumns
+
+	return s.inTx(ctx, func(tx txn) error {
+		qctx, done := tx.obs.start(ctx, "insert_tenant")
+		created, err := scanTenant(tx.QueryRowContext(qctx, query, tenant.ID, tenant.Name, time.Now().UTC()))
+		done(rowCount(err), err)
+		if err != nil {
+			return fmt.Errorf("failed to insert tenant: %w", mapError(err))
+		}
+		*tenant = *created
+		return nil
+	})
+}
+
+func (s *Storage) GetTenant(ctx context.Context, id string) (*storage.Tenant, error) {
+	ctx, cancel := storage.WithTimeout(ctx, s.timeouts.Get)
+	defer cancel()
+
+	var tenant *storage.Tenant
+	err := s.withRetry(ctx, s.retry.MaxAttempts, func() error {
+		qctx, done := s.obs.start(ctx, "get_tenant")
+		var err error
+		tenant, err = scanTenant(s.db.QueryRowContext(qctx, `SELECT `+tenantColumns+` FROM tenants WHERE id = $1`, id))
+		done(rowCount(err), err)
+		if err != nil && !errors.Is(err, storage.ErrTenantNotFound) {
+			return fmt.Errorf("query failed: %w", mapError(err))
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return tenant, nil
+}
+
+func (s *Storage) ListTenants(ctx context.Context) ([]storage.Tenant, error) {
+	ctx, cancel := storage.WithTimeout(ctx, s.timeouts.Get)
+	defer cancel()
+
+	var tenants []storage.Tenant
+	err := s.withRetry(ctx, s.retry.MaxAttempts, func() (err error) {
+		qctx, done := s.obs.start(ctx, "list_tenants")
+		defer func() { done(int64(len(tenants)), err) }()
+
+		rows, err := s.db.QueryContext(qctx, `SELECT `+tenantColumns+` FROM tenants ORDER BY id`)
+		if err != nil {
+			return fmt.Errorf("query failed: %w", mapError(err))
+		}
+		defer rows.Close()
+
+		tenants = tenants[:0]
+		for rows.Next() {
+			tenant, err := scanTenant(rows)
+			if err != nil {
+				return fmt.Errorf("failed to scan tenant: %w", mapError(err))
+			}
+			tenants = append(tenants, *tenant)
+		}
+
+		if err := rows.Err(); err != nil {
+			return fmt.Errorf("query failed: %w", mapError(err))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return tenants, nil
+}
+
+// DisableTenant keeps the time a tenant was first disabled.
+func (s *Storage) DisableTenant(ctx context.Context, id string) (*storage.Tenant, error) {
+	ctx, cancel := storage.WithTimeout(ctx, s.timeouts.Edit)
+	defer cancel()
+
+	query := `UPDATE tenants SET disabled_at = COALESCE(disabled_at, $2)
+	          WHERE id = $1
+	          RETURNING ` + tenantColumns
+
+	var tenant *storage.Tenant
+	err := s.withRetry(ctx, s.retry.MaxAttempts, func() error {
+		qctx, done := s.obs.start(ctx, "disable_tenant")
+		var err error
+		tenant, err = scanTenant(s.db.QueryRowContext(qctx, query, id, time.Now().UTC()))
+		done(rowCount(err), err)
+		if err != nil && !errors.Is(err, storage.ErrTenantNotFound) {
+			return fmt.Errorf("failed to disable tenant: %w", mapError(err))
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return tenant, nil
+}
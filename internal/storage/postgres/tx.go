@@ -0,0 +1,175 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"test_golang_user_api/internal/storage"
+)
+
+// Tx is a unit of work started by WithTx. The user methods behave like
+// their Storage counterparts, but run inside the transaction and only take
+// effect when it commits. ExecContext, QueryContext and QueryRowContext run
+// arbitrary statements in the same transaction.
+type Tx interface {
+	CreateUser(ctx context.Context, user *storage.UserDto) error
+	GetUser(ctx context.Context, id uuid.UUID) (*storage.UserDto, error)
+	GetUserIncludingDeleted(ctx context.Context, id uuid.UUID) (*storage.UserDto, error)
+	GetUserByEmail(ctx context.Context, email string) (*storage.UserDto, error)
+	EditUser(ctx context.Context, id uuid.UUID, patch storage.UserPatch, version int) (*storage.UserDto, error)
+	DeleteUser(ctx context.Context, id uuid.UUID, version int) error
+	RestoreUser(ctx context.Context, id uuid.UUID) (*storage.UserDto, error)
+	UserHistory(ctx context.Context, id uuid.UUID, limit, offset int) ([]storage.AuditEntry, error)
+
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+type txn struct {
+	*sql.Tx
+	obs    *observer
+	tenant string
+}
+
+func (t txn) CreateUser(ctx context.Context, user *storage.UserDto) error {
+	return createUser(ctx, t, user)
+}
+
+func (t txn) GetUser(ctx context.Context, id uuid.UUID) (*storage.UserDto, error) {
+	return queryUser(ctx, t, "get_user", getUserQuery, id)
+}
+
+func (t txn) GetUserIncludingDeleted(ctx context.Context, id uuid.UUID) (*storage.UserDto, error) {
+	return queryUser(ctx, t, "get_user_including_deleted", getUserIncludingDeletedQuery, id)
+}
+
+func (t txn) GetUserByEmail(ctx context.Context, email string) (*storage.UserDto, error) {
+	return queryUser(ctx, t, "get_user_by_email", getUserByEmailQuery, email)
+}
+
+func (t txn) EditUser(ctx context.Context, id uuid.UUID, patch storage.UserPatch, version int) (*storage.UserDto, error) {
+	return editUser(ctx, t, id, patch, version)
+}
+
+func (t txn) DeleteUser(ctx context.Context, id uuid.UUID, version int) error {
+	return deleteUser(ctx, t, id, version)
+}
+
+func (t txn) RestoreUser(ctx context.Context, id uuid.UUID) (*storage.UserDto, error) {
+	return restoreUser(ctx, t, id)
+}
+
+func (t txn) UserHistory(ctx context.Context, id uuid.UUID, limit, offset int) ([]storage.AuditEntry, error) {
+	return queryHistory(ctx, t, id, limit, offset)
+}
+
+type txOptions struct {
+	isolation  sql.IsolationLevel
+	readOnly   bool
+	attempts   int
+	allTenants bool
+}
+
+type TxOption func(*txOptions)
+
+// WithIsolation sets the isolation level; the server default (read
+// committed) is used otherwise.
+func WithIsolation(level sql.IsolationLevel) TxOption {
+	return func(o *txOptions) {
+		o.isolation = level
+	}
+}
+
+func WithReadOnly() TxOption {
+	return func(o *txOptions) {
+		o.readOnly = true
+	}
+}
+
+// WithRetries sets how many times a transaction failing with a transient
+// error is run again, overriding config.Retry. Zero disables retries.
+func WithRetries(n int) TxOption {
+	return func(o *txOptions) {
+		o.attempts = n + 1
+	}
+}
+
+// WithTx runs fn in a transaction on the primary, scoped to the tenant of
+// ctx like every other statement of Storage, and commits it when fn
+// returns nil. The transaction is rolled back when fn returns an error or
+// panics. Serialization failures, deadlocks and lost connections roll back
+// and run fn again from scratch as configured by config.Retry, so fn must
+// not have side effects outside of tx.
+func (s *Storage) WithTx(ctx context.Context, fn func(tx Tx) error, opts ...TxOption) error {
+	options := txOptions{attempts: s.retry.MaxAttempts}
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	return s.runTx(ctx, options, func(tx txn) error {
+		return fn(tx)
+	})
+}
+
+func (s *Storage) inTx(ctx context.Context, fn func(tx txn) error) error {
+	return s.runTx(ctx, txOptions{attempts: s.retry.MaxAttempts}, fn)
+}
+
+func (s *Storage) runTx(ctx context.Context, options txOptions, fn func(tx txn) error) error {
+	return s.withRetry(ctx, options.attempts, func() error {
+		return s.attemptTx(ctx, s.db, options, fn)
+	})
+}
+
+// readTx runs fn in a read-only transaction on a replica or the primary,
+// see read.
+func (s *Storage) readTx(ctx context.Context, fn func(tx txn) error) error {
+	return s.read(ctx, func(db *sql.DB) error {
+		return s.attemptTx(ctx, db, txOptions{readOnly: true}, fn)
+	})
+}
+
+// scopeQuery limits the transaction to a tenant through the row-level
+// security policies of the tenant tables.
+const scopeQuery = `SELECT set_config('app.tenant_id', $1, true), set_config('app.all_tenants', $2, true)`
+
+func (s *Storage) attemptTx(ctx context.Context, db *sql.DB, options txOptions, fn func(tx txn) error) (err error) {
+	tx, err := db.BeginTx(ctx, &sql.TxOptions{Isolation: options.isolation, ReadOnly: options.readOnly})
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", mapError(err))
+	}
+
+	tenant, allTenants := storage.TenantFrom(ctx), "off"
+	if options.allTenants {
+		allTenants = "on"
+	}
+	if _, err := tx.ExecContext(ctx, scopeQuery, tenant, allTenants); err != nil {
+		_ = tx.Rollback()
+		return fmt.Errorf("failed to set tenant: %w", mapError(err))
+	}
+
+	defer func() {
+		if p := recover(); p != nil {
+			_ = tx.Rollback()
+			panic(p)
+		}
+	}()
+
+	if err := fn(txn{Tx: tx, obs: s.obs, tenant: tenant}); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		err = mapError(err)
+		if errors.Is(err, storage.ErrUnavailable) {
+			return fmt.Errorf("failed to commit transaction: %w: %w", errCommitUnknown, err)
+		}
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,11 @@
+package storage
+
+// SearchResult is a live user matching a search. Higher scores match
+// better; scores are only comparable within one search. Highlights holds
+// the searched fields that match, HTML-escaped, with the matching words
+// wrapped in <em> tags.
+type SearchResult struct {
+	User       UserDto
+	Score      float64
+	Highlights map[Field]string
+}
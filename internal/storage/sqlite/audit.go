@@ -0,0 +1,76 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"github.com/google/uuid"
+	"test_golang_user_api/internal/storage"
+	"time"
+)
+
+func writeAudit(ctx context.Context, tx *sql.Tx, operation string, before, after *storage.UserDto) error {
+	userID := after.ID
+
+	beforeJSON, err := storage.Snapshot(before)
+	if err != nil {
+		return fmt.Errorf("failed to encode audit snapshot: %w", err)
+	}
+	afterJSON, err := storage.Snapshot(after)
+	if err != nil {
+		return fmt.Errorf("failed to encode audit snapshot: %w", err)
+	}
+
+	query := `INSERT INTO user_audit (user_id, actor, operation, before, after, created, tenant_id)
+	          VALUES (?, ?, ?, ?, ?, ?, ?)`
+
+	_, err = tx.ExecContext(ctx, query, userID, storage.ActorFrom(ctx), operation, jsonArg(beforeJSON), jsonArg(afterJSON), time.Now().UTC(), after.Tenant)
+	if err != nil {
+		return fmt.Errorf("failed to write audit entry: %w", mapError(err))
+	}
+
+	return nil
+}
+
+// jsonArg stores a snapshot as TEXT rather than BLOB.
+func jsonArg(raw []byte) any {
+	if raw == nil {
+		return nil
+	}
+	return string(raw)
+}
+
+func (s *Storage) UserHistory(ctx context.Context, id uuid.UUID, limit, offset int) ([]storage.AuditEntry, error) {
+	ctx, cancel := storage.WithTimeout(ctx, s.timeouts.Get)
+	defer cancel()
+
+	query := `SELECT id, user_id, actor, operation, before, after, created FROM user_audit
+	          WHERE user_id = ? AND tenant_id = ?
+	          ORDER BY created DESC, id DESC
+	          LIMIT ? OFFSET ?`
+
+	rows, err := s.db.QueryContext(ctx, query, id, storage.TenantFrom(ctx), limit, offset)
+	if err != nil {
+		return nil, fmt.Errorf("query failed: %w", mapError(err))
+	}
+	defer rows.Close()
+
+	entries := make([]storage.AuditEntry, 0, limit)
+	for rows.Next() {
+		var entry storage.AuditEntry
+		var before, after []byte
+		if err := rows.Scan(&entry.ID, &entry.UserID, &entry.Actor, &entry.Operation, &before, &after, &entry.Created); err != nil {
+			return nil, fmt.Errorf("failed to scan audit entry: %w", mapError(err))
+		}
+		entry.Created = entry.Created.UTC()
+		entry.Before = before
+		entry.After = after
+		entries = append(entries, entry)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("query failed: %w", mapError(err))
+	}
+
+	return entries, nil
+}
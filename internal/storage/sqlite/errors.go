@@ -0,0 +1,42 @@
+package sqlite
+
+import (
+	"errors"
+	"fmt"
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+	"strings"
+	"test_golang_user_api/internal/storage"
+)
+
+// mapError wraps err with the storage sentinel matching its SQLite result
+// code, keeping the original error in the chain.
+func mapError(err error) error {
+	var sqliteErr *sqlite.Error
+	if !errors.As(err, &sqliteErr) {
+		return err
+	}
+
+	switch sqliteErr.Code() {
+	case sqlite3.SQLITE_CONSTRAINT_UNIQUE:
+		if msg := sqliteErr.Error(); strings.Contains(msg, "users.email") || strings.Contains(msg, "email_lower_key") {
+			return fmt.Errorf("%w: %w", storage.ErrEmailConflict, err)
+		}
+		return fmt.Errorf("%w: %w", storage.ErrConstraintViolation, err)
+	case sqlite3.SQLITE_CONSTRAINT_PRIMARYKEY:
+		if strings.Contains(sqliteErr.Error(), "tenants.id") {
+			return fmt.Errorf("%w: %w", storage.ErrTenantExists, err)
+		}
+		return fmt.Errorf("%w: %w", storage.ErrConstraintViolation, err)
+	case sqlite3.SQLITE_CONSTRAINT_CHECK,
+		sqlite3.SQLITE_CONSTRAINT_NOTNULL,
+		sqlite3.SQLITE_CONSTRAINT_FOREIGNKEY:
+		return fmt.Errorf("%w: %w", storage.ErrConstraintViolation, err)
+	case sqlite3.SQLITE_BUSY, sqlite3.SQLITE_LOCKED:
+		return fmt.Errorf("%w: %w", storage.ErrSerialization, err)
+	case sqlite3.SQLITE_CANTOPEN, sqlite3.SQLITE_IOERR:
+		return fmt.Errorf("%w: %w", storage.ErrUnavailable, err)
+	}
+
+	return err
+}
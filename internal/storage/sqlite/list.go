@@ -0,0 +1,114 @@
+package sqlite
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"test_golang_user_api/internal/storage"
+	"test_golang_user_api/internal/storage/fuzzy"
+	"test_golang_user_api/internal/storage/listsql"
+)
+
+func (s *Storage) ListUsers(ctx context.Context, query storage.ListQuery) (*storage.UserPage, error) {
+	if err := query.Validate(); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := storage.WithTimeout(ctx, s.timeouts.Get)
+	defer cancel()
+
+	tenant := storage.TenantFrom(ctx)
+	statement, args := listsql.Select(userColumns, tenant, query, listsql.Question)
+	rows, err := s.db.QueryContext(ctx, statement, args...)
+	if err != nil {
+		return nil, fmt.Errorf("query failed: %w", mapError(err))
+	}
+	defer rows.Close()
+
+	users := make([]storage.UserDto, 0, query.Limit+1)
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan user: %w", mapError(err))
+		}
+		users = append(users, *user)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("query failed: %w", mapError(err))
+	}
+
+	page := storage.NewUserPage(query, users)
+	if query.WithTotal {
+		statement, args := listsql.Count(tenant, query, listsql.Question)
+
+		var total int64
+		err := s.db.QueryRowContext(ctx, statement, args...).Scan(&total)
+		if err != nil {
+			return nil, fmt.Errorf("query failed: %w", mapError(err))
+		}
+		page.Total = &total
+	}
+
+	return page, nil
+}
+
+// searchCandidates bounds how many users matching trigrams of the query
+// are ranked in Go, so that a common query does not score every user of a
+// large tenant. The candidates sharing the most trigrams are kept.
+const searchCandidates = 500
+
+const searchQuery = `SELECT ` + userColumns + `
+  FROM users_search
+  JOIN users_search_rows r ON r.rowid = users_search.rowid
+  JOIN users ON users.id = r.user_id
+ WHERE users_search MATCH ? AND users.tenant_id = ? AND users.deleted_at IS NULL
+ ORDER BY users_search.rank
+ LIMIT ?`
+
+// SearchUsers finds candidates in the trigram index of users_search and
+// ranks them in Go, as SQLite has no trigram similarity.
+func (s *Storage) SearchUsers(ctx context.Context, query string, limit int) ([]storage.SearchResult, error) {
+	match := matchTrigrams(query)
+	if match == "" {
+		return []storage.SearchResult{}, nil
+	}
+
+	ctx, cancel := storage.WithTimeout(ctx, s.timeouts.Get)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, searchQuery, match, storage.TenantFrom(ctx), searchCandidates)
+	if err != nil {
+		return nil, fmt.Errorf("query failed: %w", mapError(err))
+	}
+	defer rows.Close()
+
+	var users []storage.UserDto
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan user: %w", mapError(err))
+		}
+		users = append(users, *user)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("query failed: %w", mapError(err))
+	}
+
+	return fuzzy.Rank(query, users, limit), nil
+}
+
+// matchTrigrams is an FTS5 query for documents sharing any trigram with
+// query. The trigrams of two spaces and a letter, which pg_trgm makes for
+// the start of every word, are left out: the index only has words padded
+// with a single space.
+func matchTrigrams(query string) string {
+	var phrases []string
+	for _, trigram := range fuzzy.Trigrams(query) {
+		if !strings.HasPrefix(trigram, "  ") {
+			phrases = append(phrases, `"`+trigram+`"`)
+		}
+	}
+	return strings.Join(phrases, " OR ")
+}
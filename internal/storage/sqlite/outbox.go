@@ -0,0 +1,100 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"test_golang_user_api/internal/storage"
+	"time"
+)
+
+// recordChange writes the audit entry and the outbox event of a user change
+// within the change's own transaction.
+func recordChange(ctx context.Context, tx *sql.Tx, operation string, before, after *storage.UserDto) error {
+	if err := writeAudit(ctx, tx, operation, before, after); err != nil {
+		return err
+	}
+	return writeEvent(ctx, tx, storage.EventType(operation), after)
+}
+
+func writeEvent(ctx context.Context, tx *sql.Tx, eventType string, user *storage.UserDto) error {
+	payload, err := storage.Snapshot(user)
+	if err != nil {
+		return fmt.Errorf("failed to encode event payload: %w", err)
+	}
+
+	query := `INSERT INTO outbox (event_type, user_id, payload, created, next_attempt_at, tenant_id)
+	          VALUES (?1, ?2, ?3, ?4, ?4, ?5)`
+
+	_, err = tx.ExecContext(ctx, query, eventType, user.ID, string(payload), time.Now().UTC(), user.Tenant)
+	if err != nil {
+		return fmt.Errorf("failed to write outbox event: %w", mapError(err))
+	}
+
+	return nil
+}
+
+func (s *Storage) ClaimEvents(ctx context.Context, limit int, lease time.Duration) ([]storage.Event, error) {
+	ctx, cancel := storage.WithTimeout(ctx, s.timeouts.Edit)
+	defer cancel()
+
+	query := `UPDATE outbox SET attempts = attempts + 1, next_attempt_at = ?1
+	          WHERE id IN (
+	              SELECT id FROM outbox
+	              WHERE status = 'pending' AND next_attempt_at <= ?2
+	              ORDER BY id
+	              LIMIT ?3
+	          )
+	          RETURNING id, tenant_id, event_type, user_id, payload, created, attempts`
+
+	now := time.Now().UTC()
+	rows, err := s.db.QueryContext(ctx, query, now.Add(lease), now, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim events: %w", mapError(err))
+	}
+	defer rows.Close()
+
+	var events []storage.Event
+	for rows.Next() {
+		var event storage.Event
+		var payload []byte
+		if err := rows.Scan(&event.ID, &event.Tenant, &event.Type, &event.UserID, &payload, &event.Created, &event.Attempts); err != nil {
+			return nil, fmt.Errorf("failed to scan event: %w", mapError(err))
+		}
+		event.Created = event.Created.UTC()
+		event.Payload = payload
+		events = append(events, event)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to claim events: %w", mapError(err))
+	}
+
+	sort.Slice(events, func(i, j int) bool { return events[i].ID < events[j].ID })
+
+	return events, nil
+}
+
+func (s *Storage) MarkEventSent(ctx context.Context, id int64) error {
+	return s.updateEvent(ctx, `UPDATE outbox SET status = 'sent', sent_at = ?2, last_error = NULL WHERE id = ?1`, id, time.Now().UTC())
+}
+
+func (s *Storage) RetryEvent(ctx context.Context, id int64, lastErr string, retryAt time.Time) error {
+	return s.updateEvent(ctx, `UPDATE outbox SET last_error = ?2, next_attempt_at = ?3 WHERE id = ?1`, id, lastErr, retryAt.UTC())
+}
+
+func (s *Storage) DeadLetterEvent(ctx context.Context, id int64, lastErr string) error {
+	return s.updateEvent(ctx, `UPDATE outbox SET status = 'dead', last_error = ?2 WHERE id = ?1`, id, lastErr)
+}
+
+func (s *Storage) updateEvent(ctx context.Context, query string, args ...any) error {
+	ctx, cancel := storage.WithTimeout(ctx, s.timeouts.Edit)
+	defer cancel()
+
+	if _, err := s.db.ExecContext(ctx, query, args...); err != nil {
+		return fmt.Errorf("failed to update event: %w", mapError(err))
+	}
+
+	return nil
+}
@@ -0,0 +1,312 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/golang-migrate/migrate/v4"
+	"github.com/golang-migrate/migrate/v4/database/sqlite"
+	"github.com/golang-migrate/migrate/v4/source/iofs"
+	"github.com/google/uuid"
+	_ "modernc.org/sqlite"
+	"strings"
+	"test_golang_user_api/internal/config"
+	"test_golang_user_api/internal/database"
+	"test_golang_user_api/internal/storage"
+	"time"
+)
+
+type Storage struct {
+	db       *sql.DB
+	timeouts config.SqliteTimeouts
+}
+
+func New(cfg config.Sqlite) (*Storage, error) {
+	db, err := sql.Open("sqlite", buildUri(cfg))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open sqlite database: %w", err)
+	}
+
+	// SQLite allows a single writer; one connection avoids SQLITE_BUSY between
+	// our own goroutines and keeps ":memory:" databases shared.
+	db.SetMaxOpenConns(1)
+
+	if err := db.Ping(); err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("failed to ping sqlite: %w", err)
+	}
+
+	return &Storage{db: db, timeouts: cfg.Timeouts}, nil
+}
+
+func buildUri(cfg config.Sqlite) string {
+	return fmt.Sprintf("file:%s?_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)&_time_format=sqlite", cfg.Path)
+}
+
+// Migrator returns a migrator bound to the storage database. Closing it
+// closes the storage as well.
+func (s *Storage) Migrator() (*migrate.Migrate, error) {
+	source, err := iofs.New(database.SqliteMigrations, database.SqliteMigrationsDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open migrations: %w", err)
+	}
+
+	driver, err := sqlite.WithInstance(s.db, &sqlite.Config{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create migration driver: %w", err)
+	}
+
+	m, err := migrate.NewWithInstance("iofs", source, "sqlite", driver)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create migrator: %w", err)
+	}
+
+	return m, nil
+}
+
+func (s *Storage) MigrateUp() error {
+	m, err := s.Migrator()
+	if err != nil {
+		return err
+	}
+
+	err = m.Up()
+	if err != nil && !errors.Is(err, migrate.ErrNoChange) {
+		return fmt.Errorf("migration up error: %w", err)
+	}
+
+	return nil
+}
+
+func (s *Storage) Close() error {
+	return s.db.Close()
+}
+
+func (s *Storage) CreateUser(ctx context.Context, user *storage.UserDto) error {
+	ctx, cancel := storage.WithTimeout(ctx, s.timeouts.Create)
+	defer cancel()
+
+	query := `INSERT INTO users (id, tenant_id, firstname, lastname, email, age, created, updated_at) 
+	          VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	          RETURNING ` + userColumns
+
+	return s.inTx(ctx, func(tx *sql.Tx) error {
+		created, err := scanUser(tx.QueryRowContext(ctx, query, user.ID, storage.TenantFrom(ctx), user.Firstname, user.Lastname, user.Email, user.Age, user.Created.UTC(), user.Updated.UTC()))
+		if err != nil {
+			return fmt.Errorf("failed to insert user: %w", mapError(err))
+		}
+		user.Tenant = created.Tenant
+
+		return recordChange(ctx, tx, storage.AuditCreate, nil, created)
+	})
+}
+
+const userColumns = `id, tenant_id, firstname, lastname, email, age, created, updated_at, version, deleted_at`
+
+func scanUser(row rowScanner) (*storage.UserDto, error) {
+	var user storage.UserDto
+	var deleted sql.NullTime
+	err := row.Scan(
+		&user.ID,
+		&user.Tenant,
+		&user.Firstname,
+		&user.Lastname,
+		&user.Email,
+		&user.Age,
+		&user.Created,
+		&user.Updated,
+		&user.Version,
+		&deleted,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	user.Created = user.Created.UTC()
+	user.Updated = user.Updated.UTC()
+	if deleted.Valid {
+		deletedAt := deleted.Time.UTC()
+		user.Deleted = &deletedAt
+	}
+
+	return &user, nil
+}
+
+func (s *Storage) GetUser(ctx context.Context, id uuid.UUID) (*storage.UserDto, error) {
+	return s.getUser(ctx, `SELECT `+userColumns+` FROM users WHERE id = ? AND tenant_id = ? AND deleted_at IS NULL`, id)
+}
+
+func (s *Storage) GetUserIncludingDeleted(ctx context.Context, id uuid.UUID) (*storage.UserDto, error) {
+	return s.getUser(ctx, `SELECT `+userColumns+` FROM users WHERE id = ? AND tenant_id = ?`, id)
+}
+
+func (s *Storage) GetUserByEmail(ctx context.Context, email string) (*storage.UserDto, error) {
+	return s.getUser(ctx, `SELECT `+userColumns+` FROM users WHERE lower(email) = lower(?) AND tenant_id = ? AND deleted_at IS NULL`, email)
+}
+
+// getUser reads the user matching key, the first parameter of query, in
+// the tenant of ctx, the second.
+func (s *Storage) getUser(ctx context.Context, query string, key any) (*storage.UserDto, error) {
+	ctx, cancel := storage.WithTimeout(ctx, s.timeouts.Get)
+	defer cancel()
+
+	user, err := scanUser(s.db.QueryRowContext(ctx, query, key, storage.TenantFrom(ctx)))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, storage.ErrUserNotFound
+		}
+		return nil, fmt.Errorf("query failed: %w", mapError(err))
+	}
+
+	return user, nil
+}
+
+// lockUser reads the user row of the ctx tenant inside tx; the single
+// connection serializes writers. Deleted users are only matched when deleted
+// is true, and live users only when false.
+func lockUser(ctx context.Context, tx *sql.Tx, id uuid.UUID, deleted bool, version int) (*storage.UserDto, error) {
+	query := `SELECT ` + userColumns + ` FROM users WHERE id = ? AND tenant_id = ? AND (deleted_at IS NOT NULL) = ?`
+
+	user, err := scanUser(tx.QueryRowContext(ctx, query, id, storage.TenantFrom(ctx), deleted))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, storage.ErrUserNotFound
+		}
+		return nil, fmt.Errorf("failed to lock user: %w", mapError(err))
+	}
+
+	if version != 0 && user.Version != version {
+		return nil, storage.ErrVersionConflict
+	}
+
+	return user, nil
+}
+
+func (s *Storage) EditUser(ctx context.Context, id uuid.UUID, patch storage.UserPatch, version int) (*storage.UserDto, error) {
+	ctx, cancel := storage.WithTimeout(ctx, s.timeouts.Edit)
+	defer cancel()
+
+	// Only the columns set by the patch are written, the field names
+	// being the column names.
+	fields, args := patch.Values()
+	set := make([]string, len(fields))
+	for i, field := range fields {
+		set[i] = string(field) + " = ?"
+	}
+	query := `UPDATE users SET ` + strings.Join(set, ", ") + `, updated_at = ?, version = version + 1
+	          WHERE id = ? AND tenant_id = ?
+	          RETURNING ` + userColumns
+
+	var updated *storage.UserDto
+	err := s.inTx(ctx, func(tx *sql.Tx) error {
+		before, err := lockUser(ctx, tx, id, false, version)
+		if err != nil {
+			return err
+		}
+		if patch.Empty() {
+			updated = before
+			return nil
+		}
+
+		updated, err = scanUser(tx.QueryRowContext(ctx, query, append(args, time.Now().UTC(), id, before.Tenant)...))
+		if err != nil {
+			return fmt.Errorf("failed to update user: %w", mapError(err))
+		}
+
+		return recordChange(ctx, tx, storage.AuditEdit, before, updated)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return updated, nil
+}
+
+func (s *Storage) DeleteUser(ctx context.Context, id uuid.UUID, version int) error {
+	ctx, cancel := storage.WithTimeout(ctx, s.timeouts.Delete)
+	defer cancel()
+
+	query := `UPDATE users SET deleted_at = ?1, updated_at = ?1, version = version + 1
+	          WHERE id = ?2 AND tenant_id = ?3
+	          RETURNING ` + userColumns
+
+	return s.inTx(ctx, func(tx *sql.Tx) error {
+		before, err := lockUser(ctx, tx, id, false, version)
+		if err != nil {
+			return err
+		}
+
+		deleted, err := scanUser(tx.QueryRowContext(ctx, query, time.Now().UTC(), id, before.Tenant))
+		if err != nil {
+			return fmt.Errorf("failed to delete user: %w", mapError(err))
+		}
+
+		return recordChange(ctx, tx, storage.AuditDelete, before, deleted)
+	})
+}
+
+func (s *Storage) RestoreUser(ctx context.Context, id uuid.UUID) (*storage.UserDto, error) {
+	ctx, cancel := storage.WithTimeout(ctx, s.timeouts.Edit)
+	defer cancel()
+
+	query := `UPDATE users SET deleted_at = NULL, updated_at = ?, version = version + 1
+	          WHERE id = ? AND tenant_id = ?
+	          RETURNING ` + userColumns
+
+	var restored *storage.UserDto
+	err := s.inTx(ctx, func(tx *sql.Tx) error {
+		before, err := lockUser(ctx, tx, id, true, 0)
+		if err != nil {
+			return err
+		}
+
+		restored, err = scanUser(tx.QueryRowContext(ctx, query, time.Now().UTC(), id, before.Tenant))
+		if err != nil {
+			return fmt.Errorf("failed to restore user: %w", mapError(err))
+		}
+
+		return recordChange(ctx, tx, storage.AuditRestore, before, restored)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return restored, nil
+}
+
+// PurgeDeleted removes users of every tenant.
+func (s *Storage) PurgeDeleted(ctx context.Context, before time.Time) (int64, error) {
+	ctx, cancel := storage.WithTimeout(ctx, s.timeouts.Delete)
+	defer cancel()
+
+	result, err := s.db.ExecContext(ctx, `DELETE FROM users WHERE deleted_at < ?`, before.UTC())
+	if err != nil {
+		return 0, fmt.Errorf("failed to purge users: %w", mapError(err))
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get rows affected: %w", err)
+	}
+
+	return rows, nil
+}
+
+func (s *Storage) inTx(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", mapError(err))
+	}
+
+	if err := fn(tx); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", mapError(err))
+	}
+
+	return nil
+}
@@ -0,0 +1,109 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"test_golang_user_api/internal/storage"
+	"time"
+)
+
+const tenantColumns = `id, name, created, disabled_at`
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanTenant(row rowScanner) (*storage.Tenant, error) {
+	var tenant storage.Tenant
+	var disabled sql.NullTime
+	if err := row.Scan(&tenant.ID, &tenant.Name, &tenant.Created, &disabled); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, storage.ErrTenantNotFound
+		}
+		return nil, err
+	}
+	tenant.Created = tenant.Created.UTC()
+	if disabled.Valid {
+		at := disabled.Time.UTC()
+		tenant.Disabled = &at
+	}
+	return &tenant, nil
+}
+
+func (s *Storage) CreateTenant(ctx context.Context, tenant *storage.Tenant) error {
+	ctx, cancel := storage.WithTimeout(ctx, s.timeouts.Create)
+	defer cancel()
+
+	query := `INSERT INTO tenants (id, name, created) VALUES (?, ?, ?) RETURNING ` + tenantColumns
+
+	created, err := scanTenant(s.db.QueryRowContext(ctx, query, tenant.ID, tenant.Name, time.Now().UTC()))
+	if err != nil {
+		return fmt.Errorf("failed to insert tenant: %w", mapError(err))
+	}
+	*tenant = *created
+
+	return nil
+}
+
+func (s *Storage) GetTenant(ctx context.Context, id string) (*storage.Tenant, error) {
+	ctx, cancel := storage.WithTimeout(ctx, s.timeouts.Get)
+	defer cancel()
+
+	tenant, err := scanTenant(s.db.QueryRowContext(ctx, `SELECT `+tenantColumns+` FROM tenants WHERE id = ?`, id))
+	if err != nil {
+		if errors.Is(err, storage.ErrTenantNotFound) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("query failed: %w", mapError(err))
+	}
+
+	return tenant, nil
+}
+
+func (s *Storage) ListTenants(ctx context.Context) ([]storage.Tenant, error) {
+	ctx, cancel := storage.WithTimeout(ctx, s.timeouts.Get)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, `SELECT `+tenantColumns+` FROM tenants ORDER BY id`)
+	if err != nil {
+		return nil, fmt.Errorf("query failed: %w", mapError(err))
+	}
+	defer rows.Close()
+
+	var tenants []storage.Tenant
+	for rows.Next() {
+		tenant, err := scanTenant(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan tenant: %w", mapError(err))
+		}
+		tenants = append(tenants, *tenant)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("query failed: %w", mapError(err))
+	}
+
+	return tenants, nil
+}
+
+// DisableTenant keeps the time a tenant was first disabled.
+func (s *Storage) DisableTenant(ctx context.Context, id string) (*storage.Tenant, error) {
+	ctx, cancel := storage.WithTimeout(ctx, s.timeouts.Edit)
+	defer cancel()
+
+	query := `UPDATE tenants SET disabled_at = COALESCE(disabled_at, ?2)
+	          WHERE id = ?1
+	          RETURNING ` + tenantColumns
+
+	tenant, err := scanTenant(s.db.QueryRowContext(ctx, query, id, time.Now().UTC()))
+	if err != nil {
+		if errors.Is(err, storage.ErrTenantNotFound) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("failed to disable tenant: %w", mapError(err))
+	}
+
+	return tenant, nil
+}
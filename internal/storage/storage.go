@@ -0,0 +1,259 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/google/uuid"
+	"time"
+)
+
+var (
+	ErrUserNotFound        = errors.New("user not found")
+	ErrEmailConflict       = errors.New("email already exists")
+	ErrConstraintViolation = errors.New("constraint violation")
+	ErrUnavailable         = errors.New("storage unavailable")
+	ErrSerialization       = errors.New("serialization failure")
+	ErrVersionConflict     = errors.New("user version mismatch")
+)
+
+// UserDto is a stored user. Its timestamps are always in UTC: Updated is
+// the time of the last create, edit, delete or restore. Tenant is set by
+// the store from the context the user was created with.
+type UserDto struct {
+	ID        uuid.UUID
+	Tenant    string
+	Firstname string
+	Lastname  string
+	Email     string
+	Age       int
+	Created   time.Time
+	Updated   time.Time
+	Version   int
+	Deleted   *time.Time
+}
+
+func NewUser(id uuid.UUID, firstname, lastname, email string, age int) *UserDto {
+	now := time.Now().UTC()
+	return &UserDto{
+		ID:        id,
+		Firstname: firstname,
+		Lastname:  lastname,
+		Email:     email,
+		Age:       age,
+		Created:   now,
+		Updated:   now,
+		Version:   1,
+	}
+}
+
+// UserPatch changes the editable fields of a user that are set and keeps
+// the others as stored.
+type UserPatch struct {
+	Firstname *string
+	Lastname  *string
+	Email     *string
+	Age       *int
+}
+
+// Patch returns the patch replacing every editable field with that of
+// user.
+func (u *UserDto) Patch() UserPatch {
+	return UserPatch{Firstname: &u.Firstname, Lastname: &u.Lastname, Email: &u.Email, Age: &u.Age}
+}
+
+// Values returns the fields set by the patch and their new values.
+func (p UserPatch) Values() ([]Field, []any) {
+	var fields []Field
+	var values []any
+	if p.Firstname != nil {
+		fields, values = append(fields, FieldFirstname), append(values, *p.Firstname)
+	}
+	if p.Lastname != nil {
+		fields, values = append(fields, FieldLastname), append(values, *p.Lastname)
+	}
+	if p.Email != nil {
+		fields, values = append(fields, FieldEmail), append(values, *p.Email)
+	}
+	if p.Age != nil {
+		fields, values = append(fields, FieldAge), append(values, *p.Age)
+	}
+	return fields, values
+}
+
+func (p UserPatch) Empty() bool {
+	fields, _ := p.Values()
+	return len(fields) == 0
+}
+
+// Apply returns user with the fields set by the patch replaced.
+func (p UserPatch) Apply(user UserDto) UserDto {
+	if p.Firstname != nil {
+		user.Firstname = *p.Firstname
+	}
+	if p.Lastname != nil {
+		user.Lastname = *p.Lastname
+	}
+	if p.Email != nil {
+		user.Email = *p.Email
+	}
+	if p.Age != nil {
+		user.Age = *p.Age
+	}
+	return user
+}
+
+const (
+	AuditCreate  = "create"
+	AuditEdit    = "edit"
+	AuditDelete  = "delete"
+	AuditRestore = "restore"
+)
+
+// AuditEntry records a single change of a user. Before is empty for
+// creations; After holds the row as written by the change.
+type AuditEntry struct {
+	ID        int64
+	UserID    uuid.UUID
+	Actor     string
+	Operation string
+	Before    json.RawMessage
+	After     json.RawMessage
+	Created   time.Time
+}
+
+// Snapshot encodes user for an audit entry; a nil user yields nil.
+func Snapshot(user *UserDto) (json.RawMessage, error) {
+	if user == nil {
+		return nil, nil
+	}
+	return json.Marshal(user)
+}
+
+const (
+	EventUserCreated  = "UserCreated"
+	EventUserUpdated  = "UserUpdated"
+	EventUserDeleted  = "UserDeleted"
+	EventUserRestored = "UserRestored"
+)
+
+// EventType returns the lifecycle event emitted for an audited operation.
+func EventType(operation string) string {
+	switch operation {
+	case AuditCreate:
+		return EventUserCreated
+	case AuditDelete:
+		return EventUserDeleted
+	case AuditRestore:
+		return EventUserRestored
+	default:
+		return EventUserUpdated
+	}
+}
+
+// Event is a user lifecycle event stored in the outbox. Payload is the user
+// as written by the change.
+type Event struct {
+	ID       int64
+	Tenant   string
+	Type     string
+	UserID   uuid.UUID
+	Payload  json.RawMessage
+	Created  time.Time
+	Attempts int
+}
+
+// Outbox hands out lifecycle events written together with user changes.
+// ClaimEvents leases up to limit due events for the given duration so that
+// concurrent dispatchers do not deliver the same event twice; an event whose
+// lease expires unacknowledged is handed out again.
+type Outbox interface {
+	ClaimEvents(ctx context.Context, limit int, lease time.Duration) ([]Event, error)
+	MarkEventSent(ctx context.Context, id int64) error
+	RetryEvent(ctx context.Context, id int64, lastErr string, retryAt time.Time) error
+	DeadLetterEvent(ctx context.Context, id int64, lastErr string) error
+}
+
+const SystemActor = "system"
+
+type actorKey struct{}
+
+func WithActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, actorKey{}, actor)
+}
+
+// ActorFrom returns the actor recorded in ctx, or SystemActor for changes
+// not made on behalf of a request.
+func ActorFrom(ctx context.Context) string {
+	if actor, ok := ctx.Value(actorKey{}).(string); ok && actor != "" {
+		return actor
+	}
+	return SystemActor
+}
+
+type primaryKey struct{}
+
+// WithPrimary asks stores with read replicas to serve reads made with ctx
+// from the primary, e.g. to read a change the same client just made.
+func WithPrimary(ctx context.Context) context.Context {
+	return context.WithValue(ctx, primaryKey{}, true)
+}
+
+func PrimaryFrom(ctx context.Context) bool {
+	primary, _ := ctx.Value(primaryKey{}).(bool)
+	return primary
+}
+
+// UserStorage is implemented by every user store. EditUser and DeleteUser
+// expect version to match the stored row and fail with ErrVersionConflict
+// otherwise; a zero version skips the check. EditUser only writes the
+// fields set by the patch, and leaves the user as it is, version included,
+// when none are.
+//
+// DeleteUser only marks the user as deleted: such users are invisible to
+// GetUser and EditUser until RestoreUser, and are removed for good by
+// PurgeDeleted once deleted before the given time.
+//
+// GetUserByEmail finds the live user of the tenant with email, ignoring
+// case like the uniqueness of emails does; email is expected normalized
+// like stored ones.
+//
+// ListUsers pages through the live users of the tenant with keyset
+// pagination, see ListQuery; it fails with ErrInvalidCursor when both
+// After and Before are set.
+//
+// SearchUsers returns up to limit live users of the tenant whose names or
+// email resemble query, typos included, best matches first.
+//
+// Every create, edit, delete and restore is recorded atomically with the
+// change itself, listed newest first by UserHistory and published as an
+// Event through the Outbox.
+//
+// Users belong to the tenant of the context they are created with and are
+// only visible to that tenant; see WithTenant. PurgeDeleted and the Outbox
+// work across tenants.
+type UserStorage interface {
+	Outbox
+	TenantStorage
+
+	CreateUser(ctx context.Context, user *UserDto) error
+	GetUser(ctx context.Context, id uuid.UUID) (*UserDto, error)
+	GetUserIncludingDeleted(ctx context.Context, id uuid.UUID) (*UserDto, error)
+	GetUserByEmail(ctx context.Context, email string) (*UserDto, error)
+	ListUsers(ctx context.Context, query ListQuery) (*UserPage, error)
+	SearchUsers(ctx context.Context, query string, limit int) ([]SearchResult, error)
+	EditUser(ctx context.Context, id uuid.UUID, patch UserPatch, version int) (*UserDto, error)
+	DeleteUser(ctx context.Context, id uuid.UUID, version int) error
+	RestoreUser(ctx context.Context, id uuid.UUID) (*UserDto, error)
+	PurgeDeleted(ctx context.Context, before time.Time) (int64, error)
+	UserHistory(ctx context.Context, id uuid.UUID, limit, offset int) ([]AuditEntry, error)
+}
+
+// WithTimeout bounds ctx by the configured statement timeout; a zero timeout
+// leaves the deadline to the caller.
+func WithTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
+}
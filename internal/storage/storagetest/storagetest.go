@@ -0,0 +1,936 @@
+// Package storagetest checks that a storage.UserStorage implementation
+// honours the contract the handlers rely on. Call Run from a test of the
+// implementation:
+//
+//	func TestConformance(t *testing.T) {
+//		storagetest.Run(t, func(t *testing.T) storage.UserStorage {
+//			return New()
+//		})
+//	}
+package storagetest
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"slices"
+	"sync"
+	"test_golang_user_api/internal/storage"
+	"testing"
+	"time"
+)
+
+// Factory returns an empty store for a single subtest. Stores are not shared
+// between subtests, so the factory may clean up with t.Cleanup.
+type Factory func(t *testing.T) storage.UserStorage
+
+// Run runs the conformance suite against the stores made by factory.
+func Run(t *testing.T, factory Factory) {
+	t.Run("Create", func(t *testing.T) { testCreate(t, factory) })
+	t.Run("Get", func(t *testing.T) { testGet(t, factory) })
+	t.Run("Edit", func(t *testing.T) { testEdit(t, factory) })
+	t.Run("Delete", func(t *testing.T) { testDelete(t, factory) })
+	t.Run("List", func(t *testing.T) { testList(t, factory) })
+	t.Run("Search", func(t *testing.T) { testSearch(t, factory) })
+	t.Run("History", func(t *testing.T) { testHistory(t, factory) })
+	t.Run("Outbox", func(t *testing.T) { testOutbox(t, factory) })
+	t.Run("Tenants", func(t *testing.T) { testTenants(t, factory) })
+	t.Run("Concurrency", func(t *testing.T) { testConcurrency(t, factory) })
+}
+
+func newUser(email string) *storage.UserDto {
+	return storage.NewUser(uuid.New(), "Ivan", "Ivanov", email, 30)
+}
+
+func create(t *testing.T, store storage.UserStorage, email string) *storage.UserDto {
+	t.Helper()
+	user := newUser(email)
+	require.NoError(t, store.CreateUser(context.Background(), user))
+	return user
+}
+
+func testCreate(t *testing.T, factory Factory) {
+	t.Run("round-trips user", func(t *testing.T) {
+		//given
+		store := factory(t)
+		user := newUser("ivan@gmail.com")
+		//when
+		err := store.CreateUser(context.Background(), user)
+		//then
+		require.NoError(t, err)
+		got, err := store.GetUser(context.Background(), user.ID)
+		require.NoError(t, err)
+		assert.Equal(t, user.ID, got.ID)
+		assert.Equal(t, storage.DefaultTenant, got.Tenant)
+		assert.Equal(t, "Ivan", got.Firstname)
+		assert.Equal(t, "Ivanov", got.Lastname)
+		assert.Equal(t, "ivan@gmail.com", got.Email)
+		assert.Equal(t, 30, got.Age)
+		assert.Equal(t, 1, got.Version)
+		assert.Nil(t, got.Deleted)
+		assert.Equal(t, time.UTC, got.Created.Location())
+		assert.True(t, user.Created.Equal(got.Created))
+		assert.True(t, user.Updated.Equal(got.Updated))
+	})
+
+	t.Run("rejects duplicate email", func(t *testing.T) {
+		//given
+		store := factory(t)
+		create(t, store, "ivan@gmail.com")
+		//when
+		err := store.CreateUser(context.Background(), newUser("ivan@gmail.com"))
+		//then
+		require.ErrorIs(t, err, storage.ErrEmailConflict)
+	})
+
+	t.Run("rejects duplicate email differing in case", func(t *testing.T) {
+		//given
+		store := factory(t)
+		create(t, store, "ivan@gmail.com")
+		//when
+		err := store.CreateUser(context.Background(), newUser("IVAN@gmail.com"))
+		//then
+		require.ErrorIs(t, err, storage.ErrEmailConflict)
+	})
+
+	t.Run("rejects duplicate id", func(t *testing.T) {
+		//given
+		store := factory(t)
+		user := create(t, store, "ivan@gmail.com")
+		//when
+		err := store.CreateUser(context.Background(), storage.NewUser(user.ID, "Petr", "Petrov", "petr@gmail.com", 30))
+		//then
+		require.ErrorIs(t, err, storage.ErrConstraintViolation)
+	})
+
+	t.Run("rejects negative age", func(t *testing.T) {
+		//given
+		store := factory(t)
+		user := newUser("ivan@gmail.com")
+		user.Age = -1
+		//when
+		err := store.CreateUser(context.Background(), user)
+		//then
+		require.ErrorIs(t, err, storage.ErrConstraintViolation)
+		_, err = store.GetUser(context.Background(), user.ID)
+		require.ErrorIs(t, err, storage.ErrUserNotFound)
+	})
+}
+
+func testGet(t *testing.T, factory Factory) {
+	t.Run("returns not found for unknown user", func(t *testing.T) {
+		//given
+		store := factory(t)
+		//when
+		user, err := store.GetUser(context.Background(), uuid.New())
+		//then
+		require.Nil(t, user)
+		require.ErrorIs(t, err, storage.ErrUserNotFound)
+	})
+
+	t.Run("returns not found for unknown user including deleted", func(t *testing.T) {
+		//given
+		store := factory(t)
+		//when
+		user, err := store.GetUserIncludingDeleted(context.Background(), uuid.New())
+		//then
+		require.Nil(t, user)
+		require.ErrorIs(t, err, storage.ErrUserNotFound)
+	})
+
+	t.Run("finds user by email ignoring case", func(t *testing.T) {
+		//given
+		store := factory(t)
+		user := create(t, store, "Ivan@gmail.com")
+		//when
+		got, err := store.GetUserByEmail(context.Background(), "ivan@GMAIL.com")
+		//then
+		require.NoError(t, err)
+		assert.Equal(t, user.ID, got.ID)
+		assert.Equal(t, "Ivan@gmail.com", got.Email)
+	})
+
+	t.Run("does not find deleted user or user of another tenant by email", func(t *testing.T) {
+		//given
+		store := factory(t)
+		deleted := create(t, store, "ivan@gmail.com")
+		require.NoError(t, store.DeleteUser(context.Background(), deleted.ID, 0))
+		require.NoError(t, store.CreateTenant(context.Background(), &storage.Tenant{ID: "acme", Name: "Acme"}))
+		require.NoError(t, store.CreateUser(storage.WithTenant(context.Background(), "acme"), newUser("petr@gmail.com")))
+		//when
+		_, deletedErr := store.GetUserByEmail(context.Background(), "ivan@gmail.com")
+		_, foreignErr := store.GetUserByEmail(context.Background(), "petr@gmail.com")
+		//then
+		require.ErrorIs(t, deletedErr, storage.ErrUserNotFound)
+		require.ErrorIs(t, foreignErr, storage.ErrUserNotFound)
+	})
+}
+
+func testEdit(t *testing.T, factory Factory) {
+	t.Run("updates user and bumps version", func(t *testing.T) {
+		//given
+		store := factory(t)
+		user := create(t, store, "ivan@gmail.com")
+		//when
+		updated, err := store.EditUser(context.Background(), user.ID, storage.NewUser(user.ID, "Petr", "Petrov", "petr@gmail.com", 31).Patch(), 0)
+		//then
+		require.NoError(t, err)
+		assert.Equal(t, 2, updated.Version)
+		assert.False(t, updated.Updated.Before(user.Updated))
+		assert.True(t, user.Created.Equal(updated.Created))
+		got, err := store.GetUser(context.Background(), user.ID)
+		require.NoError(t, err)
+		assert.Equal(t, "Petr", got.Firstname)
+		assert.Equal(t, "Petrov", got.Lastname)
+		assert.Equal(t, "petr@gmail.com", got.Email)
+		assert.Equal(t, 31, got.Age)
+		assert.Equal(t, 2, got.Version)
+	})
+
+	t.Run("changes only patched fields", func(t *testing.T) {
+		//given
+		store := factory(t)
+		user := create(t, store, "ivan@gmail.com")
+		lastname := "Petrov"
+		//when
+		updated, err := store.EditUser(context.Background(), user.ID, storage.UserPatch{Lastname: &lastname}, 1)
+		//then
+		require.NoError(t, err)
+		assert.Equal(t, 2, updated.Version)
+		got, err := store.GetUser(context.Background(), user.ID)
+		require.NoError(t, err)
+		assert.Equal(t, user.Firstname, got.Firstname)
+		assert.Equal(t, "Petrov", got.Lastname)
+		assert.Equal(t, user.Email, got.Email)
+		assert.Equal(t, user.Age, got.Age)
+	})
+
+	t.Run("leaves user unchanged for empty patch", func(t *testing.T) {
+		//given
+		store := factory(t)
+		user := create(t, store, "ivan@gmail.com")
+		//when
+		updated, err := store.EditUser(context.Background(), user.ID, storage.UserPatch{}, 1)
+		//then
+		require.NoError(t, err)
+		assert.Equal(t, 1, updated.Version)
+		assert.Equal(t, user.Email, updated.Email)
+		history, err := store.UserHistory(context.Background(), user.ID, 10, 0)
+		require.NoError(t, err)
+		assert.Len(t, history, 1)
+	})
+
+	t.Run("checks expected version", func(t *testing.T) {
+		//given
+		store := factory(t)
+		user := create(t, store, "ivan@gmail.com")
+		edit := storage.NewUser(user.ID, "Petr", "Petrov", "petr@gmail.com", 31)
+		edit.Version = 2
+		//when
+		updated, err := store.EditUser(context.Background(), edit.ID, edit.Patch(), edit.Version)
+		//then
+		require.Nil(t, updated)
+		require.ErrorIs(t, err, storage.ErrVersionConflict)
+		got, err := store.GetUser(context.Background(), user.ID)
+		require.NoError(t, err)
+		assert.Equal(t, "ivan@gmail.com", got.Email)
+	})
+
+	t.Run("returns not found for unknown user", func(t *testing.T) {
+		//given
+		store := factory(t)
+		user := newUser("ivan@gmail.com")
+		//when
+		updated, err := store.EditUser(context.Background(), user.ID, user.Patch(), 0)
+		//then
+		require.Nil(t, updated)
+		require.ErrorIs(t, err, storage.ErrUserNotFound)
+	})
+
+	t.Run("rejects email of another user", func(t *testing.T) {
+		//given
+		store := factory(t)
+		create(t, store, "ivan@gmail.com")
+		user := create(t, store, "petr@gmail.com")
+		//when
+		_, err := store.EditUser(context.Background(), user.ID, storage.NewUser(user.ID, "Petr", "Petrov", "Ivan@gmail.com", 30).Patch(), 0)
+		//then
+		require.ErrorIs(t, err, storage.ErrEmailConflict)
+	})
+
+	t.Run("allows changing case of own email", func(t *testing.T) {
+		//given
+		store := factory(t)
+		user := create(t, store, "ivan@gmail.com")
+		//when
+		updated, err := store.EditUser(context.Background(), user.ID, storage.NewUser(user.ID, "Ivan", "Ivanov", "Ivan@gmail.com", 30).Patch(), 0)
+		//then
+		require.NoError(t, err)
+		assert.Equal(t, "Ivan@gmail.com", updated.Email)
+	})
+
+	t.Run("rejects negative age", func(t *testing.T) {
+		//given
+		store := factory(t)
+		user := create(t, store, "ivan@gmail.com")
+		//when
+		_, err := store.EditUser(context.Background(), user.ID, storage.NewUser(user.ID, "Ivan", "Ivanov", "ivan@gmail.com", -1).Patch(), 0)
+		//then
+		require.ErrorIs(t, err, storage.ErrConstraintViolation)
+	})
+}
+
+func testDelete(t *testing.T, factory Factory) {
+	t.Run("soft deletes user", func(t *testing.T) {
+		//given
+		store := factory(t)
+		user := create(t, store, "ivan@gmail.com")
+		//when
+		err := store.DeleteUser(context.Background(), user.ID, 0)
+		//then
+		require.NoError(t, err)
+		_, err = store.GetUser(context.Background(), user.ID)
+		require.ErrorIs(t, err, storage.ErrUserNotFound)
+		deleted, err := store.GetUserIncludingDeleted(context.Background(), user.ID)
+		require.NoError(t, err)
+		require.NotNil(t, deleted.Deleted)
+		assert.Equal(t, 2, deleted.Version)
+		_, err = store.EditUser(context.Background(), user.ID, storage.NewUser(user.ID, "Petr", "Petrov", "petr@gmail.com", 30).Patch(), 0)
+		require.ErrorIs(t, err, storage.ErrUserNotFound)
+	})
+
+	t.Run("returns not found for deleted or unknown user", func(t *testing.T) {
+		//given
+		store := factory(t)
+		user := create(t, store, "ivan@gmail.com")
+		require.NoError(t, store.DeleteUser(context.Background(), user.ID, 0))
+		//when
+		again := store.DeleteUser(context.Background(), user.ID, 0)
+		unknown := store.DeleteUser(context.Background(), uuid.New(), 0)
+		//then
+		require.ErrorIs(t, again, storage.ErrUserNotFound)
+		require.ErrorIs(t, unknown, storage.ErrUserNotFound)
+	})
+
+	t.Run("checks expected version", func(t *testing.T) {
+		//given
+		store := factory(t)
+		user := create(t, store, "ivan@gmail.com")
+		//when
+		err := store.DeleteUser(context.Background(), user.ID, 2)
+		//then
+		require.ErrorIs(t, err, storage.ErrVersionConflict)
+		_, err = store.GetUser(context.Background(), user.ID)
+		require.NoError(t, err)
+	})
+
+	t.Run("restores deleted user", func(t *testing.T) {
+		//given
+		store := factory(t)
+		user := create(t, store, "ivan@gmail.com")
+		require.NoError(t, store.DeleteUser(context.Background(), user.ID, 0))
+		//when
+		restored, err := store.RestoreUser(context.Background(), user.ID)
+		//then
+		require.NoError(t, err)
+		assert.Nil(t, restored.Deleted)
+		assert.Equal(t, 3, restored.Version)
+		_, err = store.GetUser(context.Background(), user.ID)
+		require.NoError(t, err)
+		_, err = store.RestoreUser(context.Background(), user.ID)
+		require.ErrorIs(t, err, storage.ErrUserNotFound)
+	})
+
+	t.Run("purges users deleted before cutoff", func(t *testing.T) {
+		//given
+		store := factory(t)
+		deleted := create(t, store, "ivan@gmail.com")
+		kept := create(t, store, "petr@gmail.com")
+		require.NoError(t, store.DeleteUser(context.Background(), deleted.ID, 0))
+		//when
+		purged, err := store.PurgeDeleted(context.Background(), time.Now().Add(time.Minute))
+		//then
+		require.NoError(t, err)
+		assert.Equal(t, int64(1), purged)
+		_, err = store.GetUserIncludingDeleted(context.Background(), deleted.ID)
+		require.ErrorIs(t, err, storage.ErrUserNotFound)
+		_, err = store.GetUser(context.Background(), kept.ID)
+		require.NoError(t, err)
+	})
+}
+
+func testList(t *testing.T, factory Factory) {
+	type seed struct {
+		firstname, lastname, email string
+		age                        int
+		created                    time.Duration
+	}
+	// seedList creates live users, two of them created at the same time,
+	// and returns them by email; a deleted user and a user of another
+	// tenant are created too and must never be listed.
+	seedList := func(t *testing.T, store storage.UserStorage) map[string]storage.UserDto {
+		t.Helper()
+		base := time.Now().UTC().Truncate(time.Microsecond).Add(-time.Hour)
+		users := make(map[string]storage.UserDto)
+		for _, s := range []seed{
+			{"Anna", "Smith", "anna@acme.com", 20, 0},
+			{"Andrew", "Stone", "andrew@Acme.com", 24, time.Minute},
+			{"Boris", "Ivanov", "boris@acme.com", 30, time.Minute},
+			{"Alice", "Brown", "alice@other.com", 24, 2 * time.Minute},
+			{"Carl", "Adams", "carl@acme.community", 41, 3 * time.Minute},
+		} {
+			user := storage.NewUser(uuid.New(), s.firstname, s.lastname, s.email, s.age)
+			user.Created = base.Add(s.created)
+			user.Updated = user.Created
+			require.NoError(t, store.CreateUser(context.Background(), user))
+			users[s.email] = *user
+		}
+
+		deleted := create(t, store, "deleted@acme.com")
+		require.NoError(t, store.DeleteUser(context.Background(), deleted.ID, 0))
+		require.NoError(t, store.CreateTenant(context.Background(), &storage.Tenant{ID: "acme", Name: "Acme"}))
+		require.NoError(t, store.CreateUser(storage.WithTenant(context.Background(), "acme"), newUser("other@acme.com")))
+
+		return users
+	}
+	sorted := func(users map[string]storage.UserDto, sort storage.Sort) []storage.UserDto {
+		result := make([]storage.UserDto, 0, len(users))
+		for _, user := range users {
+			result = append(result, user)
+		}
+		slices.SortFunc(result, sort.Compare)
+		return result
+	}
+	emails := func(users []storage.UserDto) []string {
+		result := make([]string, len(users))
+		for i, user := range users {
+			result[i] = user.Email
+		}
+		return result
+	}
+	// walk reads every page of query forward, then back from the last page
+	// to the first, and returns the users of both walks.
+	walk := func(t *testing.T, store storage.UserStorage, query storage.ListQuery) (forward, backward []storage.UserDto) {
+		t.Helper()
+		var last *storage.UserPage
+		for pages := 0; ; pages++ {
+			require.Less(t, pages, 10, "too many pages")
+			page, err := store.ListUsers(context.Background(), query)
+			require.NoError(t, err)
+			assert.Equal(t, pages > 0, page.Prev != nil)
+			forward = append(forward, page.Users...)
+			last = page
+			if page.Next == nil {
+				break
+			}
+			query.After = page.Next
+		}
+
+		query.After = nil
+		backward = last.Users
+		for page := last; page.Prev != nil; {
+			query.Before = page.Prev
+			var err error
+			page, err = store.ListUsers(context.Background(), query)
+			require.NoError(t, err)
+			assert.NotNil(t, page.Next)
+			backward = append(page.Users, backward...)
+		}
+		return forward, backward
+	}
+
+	t.Run("pages forward and backward", func(t *testing.T) {
+		//given
+		store := factory(t)
+		users := seedList(t, store)
+		//when
+		forward, backward := walk(t, store, storage.ListQuery{Limit: 2})
+		//then
+		want := emails(sorted(users, nil))
+		assert.Equal(t, want, emails(forward))
+		assert.Equal(t, want, emails(backward))
+	})
+
+	t.Run("counts matching users", func(t *testing.T) {
+		//given
+		store := factory(t)
+		seedList(t, store)
+		domain, err := storage.ParseCondition("email", "domain", "acme.com")
+		require.NoError(t, err)
+		//when
+		page, err := store.ListUsers(context.Background(), storage.ListQuery{Limit: 1, Filter: []storage.Condition{domain}, WithTotal: true})
+		//then
+		require.NoError(t, err)
+		require.NotNil(t, page.Total)
+		assert.Equal(t, int64(3), *page.Total)
+		assert.Len(t, page.Users, 1)
+	})
+
+	t.Run("returns first page without cursors when all fit", func(t *testing.T) {
+		//given
+		store := factory(t)
+		users := seedList(t, store)
+		//when
+		page, err := store.ListUsers(context.Background(), storage.ListQuery{Limit: len(users)})
+		//then
+		require.NoError(t, err)
+		assert.Equal(t, emails(sorted(users, nil)), emails(page.Users))
+		assert.Nil(t, page.Next)
+		assert.Nil(t, page.Prev)
+		assert.Nil(t, page.Total)
+	})
+
+	t.Run("returns empty page for empty tenant", func(t *testing.T) {
+		//given
+		store := factory(t)
+		//when
+		page, err := store.ListUsers(context.Background(), storage.ListQuery{Limit: 10, WithTotal: true})
+		//then
+		require.NoError(t, err)
+		assert.NotNil(t, page.Users)
+		assert.Empty(t, page.Users)
+		assert.Equal(t, int64(0), *page.Total)
+		assert.Nil(t, page.Next)
+	})
+
+	for _, tc := range []struct {
+		name   string
+		filter [][3]string
+		want   []string
+	}{
+		{"filters by email domain and age range", [][3]string{{"email", "domain", "ACME.com"}, {"age", "gte", "18"}, {"age", "lte", "25"}}, []string{"anna@acme.com", "andrew@Acme.com"}},
+		{"filters by name prefix ignoring case", [][3]string{{"firstname", "prefix", "an"}}, []string{"anna@acme.com", "andrew@Acme.com"}},
+		{"filters by exact name ignoring case", [][3]string{{"lastname", "", "ivanov"}}, []string{"boris@acme.com"}},
+		{"filters by exact email ignoring case", [][3]string{{"email", "eq", "ANDREW@acme.com"}}, []string{"andrew@Acme.com"}},
+		{"treats like wildcards literally", [][3]string{{"email", "prefix", "%"}}, nil},
+		{"filters by age", [][3]string{{"age", "eq", "24"}}, []string{"andrew@Acme.com", "alice@other.com"}},
+		{"filters by created range", [][3]string{{"created", "gt", "2000-01-01"}, {"version", "eq", "1"}, {"age", "gt", "24"}, {"age", "lt", "41"}}, []string{"boris@acme.com"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			//given
+			store := factory(t)
+			seedList(t, store)
+			var filter []storage.Condition
+			for _, f := range tc.filter {
+				condition, err := storage.ParseCondition(f[0], f[1], f[2])
+				require.NoError(t, err)
+				filter = append(filter, condition)
+			}
+			//when
+			forward, _ := walk(t, store, storage.ListQuery{Limit: 1, Filter: filter})
+			//then
+			assert.ElementsMatch(t, tc.want, emails(forward))
+		})
+	}
+
+	t.Run("filters by created time", func(t *testing.T) {
+		//given
+		store := factory(t)
+		users := seedList(t, store)
+		from := storage.Condition{Field: storage.FieldCreated, Op: storage.OpGte, Value: users["andrew@Acme.com"].Created}
+		to := storage.Condition{Field: storage.FieldCreated, Op: storage.OpLt, Value: users["carl@acme.community"].Created}
+		//when
+		page, err := store.ListUsers(context.Background(), storage.ListQuery{Limit: 10, Filter: []storage.Condition{from, to}})
+		//then
+		require.NoError(t, err)
+		assert.ElementsMatch(t, []string{"alice@other.com", "andrew@Acme.com", "boris@acme.com"}, emails(page.Users))
+	})
+
+	t.Run("takes a date for the whole day", func(t *testing.T) {
+		//given
+		store := factory(t)
+		for email, created := range map[string]time.Time{
+			"before@gmail.com":   time.Date(2024, 5, 5, 23, 59, 59, 0, time.UTC),
+			"midnight@gmail.com": time.Date(2024, 5, 6, 0, 0, 0, 0, time.UTC),
+			"during@gmail.com":   time.Date(2024, 5, 6, 15, 30, 0, 0, time.UTC),
+			"after@gmail.com":    time.Date(2024, 5, 7, 0, 0, 0, 0, time.UTC),
+		} {
+			user := newUser(email)
+			user.Created, user.Updated = created, created
+			require.NoError(t, store.CreateUser(context.Background(), user))
+		}
+		list := func(op string) []string {
+			condition, err := storage.ParseCondition("created", op, "2024-05-06")
+			require.NoError(t, err)
+			page, err := store.ListUsers(context.Background(), storage.ListQuery{Limit: 10, Filter: []storage.Condition{condition}})
+			require.NoError(t, err)
+			return emails(page.Users)
+		}
+		//when
+		eq, gt, gte, lt, lte := list("eq"), list("gt"), list("gte"), list("lt"), list("lte")
+		//then
+		assert.ElementsMatch(t, []string{"midnight@gmail.com", "during@gmail.com"}, eq)
+		assert.ElementsMatch(t, []string{"after@gmail.com"}, gt)
+		assert.ElementsMatch(t, []string{"midnight@gmail.com", "during@gmail.com", "after@gmail.com"}, gte)
+		assert.ElementsMatch(t, []string{"before@gmail.com"}, lt)
+		assert.ElementsMatch(t, []string{"before@gmail.com", "midnight@gmail.com", "during@gmail.com"}, lte)
+	})
+
+	t.Run("filters by internationalized domain", func(t *testing.T) {
+		//given
+		store := factory(t)
+		create(t, store, "ivan@xn--e1afmkfd.xn--p1ai")
+		create(t, store, "petr@gmail.com")
+		domain, err := storage.ParseCondition("email", "domain", "Пример.РФ")
+		require.NoError(t, err)
+		//when
+		page, err := store.ListUsers(context.Background(), storage.ListQuery{Limit: 10, Filter: []storage.Condition{domain}})
+		//then
+		require.NoError(t, err)
+		assert.Equal(t, []string{"ivan@xn--e1afmkfd.xn--p1ai"}, emails(page.Users))
+	})
+
+	for _, raw := range []string{"-created", "-age,lastname", "age,-firstname", "lastname", "-email,id"} {
+		t.Run("sorts by "+raw, func(t *testing.T) {
+			//given
+			store := factory(t)
+			users := seedList(t, store)
+			sort, err := storage.ParseSort(raw)
+			require.NoError(t, err)
+			//when
+			forward, backward := walk(t, store, storage.ListQuery{Limit: 2, Sort: sort})
+			//then
+			want := emails(sorted(users, sort))
+			assert.Equal(t, want, emails(forward))
+			assert.Equal(t, want, emails(backward))
+		})
+	}
+
+	t.Run("rejects both cursors", func(t *testing.T) {
+		//given
+		store := factory(t)
+		cursor := storage.Sort(nil).CursorOf(*newUser("ivan@gmail.com"))
+		//when
+		_, err := store.ListUsers(context.Background(), storage.ListQuery{Limit: 10, After: &cursor, Before: &cursor})
+		//then
+		require.ErrorIs(t, err, storage.ErrInvalidCursor)
+	})
+
+	t.Run("rejects cursor of another sort", func(t *testing.T) {
+		//given
+		store := factory(t)
+		cursor := storage.Sort(nil).CursorOf(*newUser("ivan@gmail.com"))
+		//when
+		_, err := store.ListUsers(context.Background(), storage.ListQuery{Limit: 10, Sort: storage.Sort{{Field: storage.FieldAge}}, After: &cursor})
+		//then
+		require.ErrorIs(t, err, storage.ErrInvalidCursor)
+	})
+}
+
+func testSearch(t *testing.T, factory Factory) {
+	// seedSearch creates live users and returns them by email; a deleted
+	// user and a user of another tenant match too but must never be found.
+	seedSearch := func(t *testing.T, store storage.UserStorage) map[string]storage.UserDto {
+		t.Helper()
+		users := make(map[string]storage.UserDto)
+		for _, name := range [][3]string{
+			{"Ivan", "Ivanov", "ivan@gmail.com"},
+			{"Ivana", "Petrova", "ivana@yandex.ru"},
+			{"Boris", "Smirnov", "boris@gmail.com"},
+			{"Anna", "Smith", "anna.ivanova@mail.ru"},
+		} {
+			user := storage.NewUser(uuid.New(), name[0], name[1], name[2], 30)
+			require.NoError(t, store.CreateUser(context.Background(), user))
+			users[name[2]] = *user
+		}
+
+		deleted := storage.NewUser(uuid.New(), "Ivan", "Ivanov", "deleted@gmail.com", 30)
+		require.NoError(t, store.CreateUser(context.Background(), deleted))
+		require.NoError(t, store.DeleteUser(context.Background(), deleted.ID, 0))
+		require.NoError(t, store.CreateTenant(context.Background(), &storage.Tenant{ID: "acme", Name: "Acme"}))
+		other := storage.NewUser(uuid.New(), "Ivan", "Ivanov", "ivan@gmail.com", 30)
+		require.NoError(t, store.CreateUser(storage.WithTenant(context.Background(), "acme"), other))
+
+		return users
+	}
+	ids := func(results []storage.SearchResult) []uuid.UUID {
+		ids := make([]uuid.UUID, len(results))
+		for i, result := range results {
+			ids[i] = result.User.ID
+		}
+		return ids
+	}
+
+	t.Run("finds users despite typos, best first", func(t *testing.T) {
+		//given
+		store := factory(t)
+		users := seedSearch(t, store)
+		//when
+		results, err := store.SearchUsers(context.Background(), "Ivan Ivnaov", 10)
+		//then
+		require.NoError(t, err)
+		require.NotEmpty(t, results)
+		assert.Equal(t, users["ivan@gmail.com"].ID, results[0].User.ID)
+		assert.Equal(t, "<em>Ivan</em>", results[0].Highlights[storage.FieldFirstname])
+		assert.NotContains(t, ids(results), users["boris@gmail.com"].ID)
+		for i := 1; i < len(results); i++ {
+			assert.GreaterOrEqual(t, results[i-1].Score, results[i].Score)
+		}
+	})
+
+	t.Run("searches email", func(t *testing.T) {
+		//given
+		store := factory(t)
+		users := seedSearch(t, store)
+		//when
+		results, err := store.SearchUsers(context.Background(), "ivanova", 10)
+		//then
+		require.NoError(t, err)
+		assert.Contains(t, ids(results), users["anna.ivanova@mail.ru"].ID)
+		assert.NotContains(t, ids(results), users["boris@gmail.com"].ID)
+	})
+
+	t.Run("ignores deleted users and other tenants", func(t *testing.T) {
+		//given
+		store := factory(t)
+		users := seedSearch(t, store)
+		//when
+		results, err := store.SearchUsers(context.Background(), "Ivan Ivanov", 10)
+		//then
+		require.NoError(t, err)
+		for _, result := range results {
+			assert.Equal(t, storage.DefaultTenant, result.User.Tenant)
+			assert.Nil(t, result.User.Deleted)
+		}
+		assert.Equal(t, users["ivan@gmail.com"].ID, results[0].User.ID)
+	})
+
+	t.Run("finds users by their current names", func(t *testing.T) {
+		//given
+		store := factory(t)
+		users := seedSearch(t, store)
+		boris := users["boris@gmail.com"]
+		_, err := store.EditUser(context.Background(), boris.ID, storage.NewUser(boris.ID, "Gleb", "Orlov", "gleb@gmail.com", 30).Patch(), 0)
+		require.NoError(t, err)
+		//when
+		renamed, err := store.SearchUsers(context.Background(), "Gleb Orlov", 10)
+		require.NoError(t, err)
+		previous, err := store.SearchUsers(context.Background(), "Boris Smirnov", 10)
+		//then
+		require.NoError(t, err)
+		assert.Equal(t, []uuid.UUID{boris.ID}, ids(renamed))
+		assert.NotContains(t, ids(previous), boris.ID)
+	})
+
+	t.Run("applies limit", func(t *testing.T) {
+		//given
+		store := factory(t)
+		seedSearch(t, store)
+		//when
+		results, err := store.SearchUsers(context.Background(), "ivan", 1)
+		//then
+		require.NoError(t, err)
+		assert.Len(t, results, 1)
+	})
+
+	t.Run("returns nothing without matches", func(t *testing.T) {
+		//given
+		store := factory(t)
+		seedSearch(t, store)
+		//when
+		results, err := store.SearchUsers(context.Background(), "xyzzy", 10)
+		//then
+		require.NoError(t, err)
+		assert.Empty(t, results)
+	})
+}
+
+func testHistory(t *testing.T, factory Factory) {
+	t.Run("lists changes newest first", func(t *testing.T) {
+		//given
+		store := factory(t)
+		ctx := storage.WithActor(context.Background(), "admin")
+		user := newUser("ivan@gmail.com")
+		require.NoError(t, store.CreateUser(ctx, user))
+		_, err := store.EditUser(ctx, user.ID, storage.NewUser(user.ID, "Petr", "Petrov", "petr@gmail.com", 31).Patch(), 0)
+		require.NoError(t, err)
+		require.NoError(t, store.DeleteUser(ctx, user.ID, 0))
+		//when
+		entries, err := store.UserHistory(context.Background(), user.ID, 10, 0)
+		page, pageErr := store.UserHistory(context.Background(), user.ID, 1, 1)
+		//then
+		require.NoError(t, err)
+		require.Len(t, entries, 3)
+		assert.Equal(t, storage.AuditDelete, entries[0].Operation)
+		assert.Equal(t, storage.AuditEdit, entries[1].Operation)
+		assert.Equal(t, storage.AuditCreate, entries[2].Operation)
+		assert.Equal(t, "admin", entries[2].Actor)
+		assert.Empty(t, entries[2].Before)
+		assert.NotEmpty(t, entries[2].After)
+		require.NoError(t, pageErr)
+		require.Len(t, page, 1)
+		assert.Equal(t, storage.AuditEdit, page[0].Operation)
+	})
+}
+
+func testOutbox(t *testing.T, factory Factory) {
+	t.Run("publishes lifecycle events once", func(t *testing.T) {
+		//given
+		store := factory(t)
+		user := create(t, store, "ivan@gmail.com")
+		require.NoError(t, store.DeleteUser(context.Background(), user.ID, 0))
+		//when
+		events, err := store.ClaimEvents(context.Background(), 10, time.Minute)
+		again, againErr := store.ClaimEvents(context.Background(), 10, time.Minute)
+		//then
+		require.NoError(t, err)
+		require.Len(t, events, 2)
+		assert.Equal(t, storage.EventUserCreated, events[0].Type)
+		assert.Equal(t, storage.EventUserDeleted, events[1].Type)
+		assert.Equal(t, user.ID, events[0].UserID)
+		assert.Equal(t, storage.DefaultTenant, events[0].Tenant)
+		assert.Equal(t, 1, events[0].Attempts)
+		require.NoError(t, againErr)
+		assert.Empty(t, again)
+		for _, event := range events {
+			require.NoError(t, store.MarkEventSent(context.Background(), event.ID))
+		}
+	})
+}
+
+func testTenants(t *testing.T, factory Factory) {
+	t.Run("isolates users of tenants", func(t *testing.T) {
+		//given
+		store := factory(t)
+		require.NoError(t, store.CreateTenant(context.Background(), &storage.Tenant{ID: "acme", Name: "Acme"}))
+		acme := storage.WithTenant(context.Background(), "acme")
+		user := newUser("ivan@gmail.com")
+		require.NoError(t, store.CreateUser(acme, user))
+		//when
+		_, getErr := store.GetUser(context.Background(), user.ID)
+		_, editErr := store.EditUser(context.Background(), user.ID, storage.NewUser(user.ID, "Petr", "Petrov", "petr@gmail.com", 30).Patch(), 0)
+		deleteErr := store.DeleteUser(context.Background(), user.ID, 0)
+		history, historyErr := store.UserHistory(context.Background(), user.ID, 10, 0)
+		//then
+		require.ErrorIs(t, getErr, storage.ErrUserNotFound)
+		require.ErrorIs(t, editErr, storage.ErrUserNotFound)
+		require.ErrorIs(t, deleteErr, storage.ErrUserNotFound)
+		require.NoError(t, historyErr)
+		assert.Empty(t, history)
+		got, err := store.GetUser(acme, user.ID)
+		require.NoError(t, err)
+		assert.Equal(t, "acme", got.Tenant)
+	})
+
+	t.Run("scopes email uniqueness to tenant", func(t *testing.T) {
+		//given
+		store := factory(t)
+		require.NoError(t, store.CreateTenant(context.Background(), &storage.Tenant{ID: "acme", Name: "Acme"}))
+		create(t, store, "ivan@gmail.com")
+		//when
+		err := store.CreateUser(storage.WithTenant(context.Background(), "acme"), newUser("ivan@gmail.com"))
+		//then
+		require.NoError(t, err)
+	})
+
+	t.Run("manages tenants", func(t *testing.T) {
+		//given
+		store := factory(t)
+		//when
+		err := store.CreateTenant(context.Background(), &storage.Tenant{ID: "acme", Name: "Acme"})
+		duplicate := store.CreateTenant(context.Background(), &storage.Tenant{ID: "acme", Name: "Acme"})
+		disabled, disableErr := store.DisableTenant(context.Background(), "acme")
+		tenants, listErr := store.ListTenants(context.Background())
+		_, missingErr := store.GetTenant(context.Background(), "missing")
+		//then
+		require.NoError(t, err)
+		require.ErrorIs(t, duplicate, storage.ErrTenantExists)
+		require.NoError(t, disableErr)
+		assert.NotNil(t, disabled.Disabled)
+		require.NoError(t, listErr)
+		require.Len(t, tenants, 2)
+		assert.Equal(t, "acme", tenants[0].ID)
+		assert.Equal(t, storage.DefaultTenant, tenants[1].ID)
+		require.ErrorIs(t, missingErr, storage.ErrTenantNotFound)
+	})
+}
+
+func testConcurrency(t *testing.T, factory Factory) {
+	const workers = 10
+
+	t.Run("serializes concurrent edits", func(t *testing.T) {
+		//given
+		store := factory(t)
+		user := create(t, store, "ivan@gmail.com")
+		//when
+		errs := parallel(workers, func(i int) error {
+			edit := storage.NewUser(user.ID, "Ivan", "Ivanov", "ivan@gmail.com", 30+i)
+			edit.Version = 0
+			_, err := store.EditUser(context.Background(), edit.ID, edit.Patch(), edit.Version)
+			return err
+		})
+		//then
+		for _, err := range errs {
+			require.NoError(t, err)
+		}
+		got, err := store.GetUser(context.Background(), user.ID)
+		require.NoError(t, err)
+		assert.Equal(t, workers+1, got.Version)
+		history, err := store.UserHistory(context.Background(), user.ID, 2*workers, 0)
+		require.NoError(t, err)
+		assert.Len(t, history, workers+1)
+	})
+
+	t.Run("lets one of concurrent versioned edits win", func(t *testing.T) {
+		//given
+		store := factory(t)
+		user := create(t, store, "ivan@gmail.com")
+		//when
+		errs := parallel(workers, func(i int) error {
+			edit := storage.NewUser(user.ID, "Ivan", "Ivanov", "ivan@gmail.com", 30+i)
+			edit.Version = 1
+			_, err := store.EditUser(context.Background(), edit.ID, edit.Patch(), edit.Version)
+			return err
+		})
+		//then
+		succeeded := 0
+		for _, err := range errs {
+			if err == nil {
+				succeeded++
+				continue
+			}
+			require.ErrorIs(t, err, storage.ErrVersionConflict)
+		}
+		assert.Equal(t, 1, succeeded)
+	})
+
+	t.Run("lets one of concurrent creates with same email win", func(t *testing.T) {
+		//given
+		store := factory(t)
+		//when
+		errs := parallel(workers, func(i int) error {
+			return store.CreateUser(context.Background(), newUser("ivan@gmail.com"))
+		})
+		//then
+		succeeded := 0
+		for _, err := range errs {
+			if err == nil {
+				succeeded++
+				continue
+			}
+			require.ErrorIs(t, err, storage.ErrEmailConflict)
+		}
+		assert.Equal(t, 1, succeeded)
+	})
+}
+
+// parallel runs fn for 0..n-1 concurrently and returns the errors by index.
+func parallel(n int, fn func(i int) error) []error {
+	errs := make([]error, n)
+	start := make(chan struct{})
+
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			errs[i] = fn(i)
+		}()
+	}
+	close(start)
+	wg.Wait()
+
+	return errs
+}
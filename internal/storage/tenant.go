@@ -0,0 +1,55 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"time"
+)
+
+var (
+	ErrTenantNotFound = errors.New("tenant not found")
+	ErrTenantExists   = errors.New("tenant already exists")
+)
+
+// DefaultTenant owns users created without a tenant in the context,
+// including every user created before tenants were introduced.
+const DefaultTenant = "default"
+
+type Tenant struct {
+	ID       string
+	Name     string
+	Created  time.Time
+	Disabled *time.Time
+}
+
+// TenantStorage manages tenants. A disabled tenant keeps its users, but
+// requests on its behalf are refused.
+type TenantStorage interface {
+	CreateTenant(ctx context.Context, tenant *Tenant) error
+	GetTenant(ctx context.Context, id string) (*Tenant, error)
+	ListTenants(ctx context.Context) ([]Tenant, error)
+	DisableTenant(ctx context.Context, id string) (*Tenant, error)
+}
+
+var tenantID = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?$`)
+
+// ValidTenantID reports whether id is a lowercase DNS label, so that every
+// tenant can also be addressed by subdomain.
+func ValidTenantID(id string) bool {
+	return tenantID.MatchString(id)
+}
+
+type tenantKey struct{}
+
+func WithTenant(ctx context.Context, tenant string) context.Context {
+	return context.WithValue(ctx, tenantKey{}, tenant)
+}
+
+// TenantFrom returns the tenant recorded in ctx, or DefaultTenant.
+func TenantFrom(ctx context.Context) string {
+	if tenant, ok := ctx.Value(tenantKey{}).(string); ok && tenant != "" {
+		return tenant
+	}
+	return DefaultTenant
+}